@@ -0,0 +1,675 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Состояние раздела администрирования: блокировки, журнал действий и входы.
+// Блокировки и журнал хранятся в отдельном файле admin.state_path,
+// чтобы не менять формат базы ссылок.
+var (
+	adminMu  sync.Mutex
+	bans     = make(map[string]*ban) // ip -> блокировка
+	auditLog []auditEntry
+	sessions = make(map[string]*adminSession) // токен -> вход
+
+	loginFailures = make(map[string]*loginFailure) // loginFailureKeys -> неудачные входы
+)
+
+// Защита входа от перебора: после maxLoginFailures ошибок за loginFailureWindow
+// логин с этого IP или сам IP блокируются на loginLockout, даже для верного
+// пароля. Логин отдельно не блокируется: иначе любой аноним запер бы
+// администратора, перебирая пароли к его логину.
+const (
+	maxLoginFailures       = 5
+	loginFailureWindow     = 15 * time.Minute
+	loginLockout           = 15 * time.Minute
+	maxLoginFailureEntries = 10000
+)
+
+type loginFailure struct {
+	Count       int
+	First       time.Time
+	LockedUntil time.Time
+}
+
+// Сколько последних записей журнала хранить
+const maxAuditEntries = 10000
+
+// Заблокированный IP: не может создавать и менять ссылки
+type ban struct {
+	IP     string    `json:"ip"`
+	Reason string    `json:"reason,omitempty"`
+	By     string    `json:"by"`
+	At     time.Time `json:"at"`
+}
+
+// Запись журнала действий администраторов
+type auditEntry struct {
+	At      time.Time `json:"at"`
+	Admin   string    `json:"admin"`
+	IP      string    `json:"ip"`
+	Action  string    `json:"action"`
+	Target  string    `json:"target,omitempty"`
+	Details string    `json:"details,omitempty"`
+}
+
+type adminState struct {
+	Bans  []*ban       `json:"bans"`
+	Audit []auditEntry `json:"audit"`
+}
+
+type adminSession struct {
+	User    string
+	CSRF    string
+	Expires time.Time
+}
+
+func adminEnabled() bool {
+	return len(config.Admin.Users) > 0
+}
+
+// Загрузка блокировок и журнала при старте
+func loadAdminState() error {
+	if config.Storage.Backend != "json" {
+		return nil
+	}
+	data, err := os.ReadFile(config.Admin.StatePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state adminState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+
+	adminMu.Lock()
+	defer adminMu.Unlock()
+	bans = make(map[string]*ban)
+	for _, b := range state.Bans {
+		bans[b.IP] = b
+	}
+	auditLog = state.Audit
+	return nil
+}
+
+// Сохранение состояния (вызывается под adminMu). Действия администраторов
+// редкие, поэтому файл пишется сразу, без фонового сохранения.
+func saveAdminState() error {
+	if config.Storage.Backend != "json" {
+		return nil
+	}
+	state := adminState{Bans: []*ban{}, Audit: auditLog}
+	for _, b := range bans {
+		state.Bans = append(state.Bans, b)
+	}
+	sort.Slice(state.Bans, func(i, j int) bool { return state.Bans[i].At.Before(state.Bans[j].At) })
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(filepath.Dir(config.Admin.StatePath), 0755)
+	return writeFileAtomic(config.Admin.StatePath, data, 0600)
+}
+
+func isBanned(ip string) bool {
+	adminMu.Lock()
+	defer adminMu.Unlock()
+	return bans[ip] != nil
+}
+
+// Запись в журнал действий и в лог
+func audit(s *adminSession, r *http.Request, action, target, details string) {
+	entry := auditEntry{
+		At:      time.Now(),
+		Admin:   s.User,
+		IP:      getIP(r),
+		Action:  action,
+		Target:  target,
+		Details: details,
+	}
+
+	adminMu.Lock()
+	auditLog = append(auditLog, entry)
+	if len(auditLog) > maxAuditEntries {
+		auditLog = append([]auditEntry(nil), auditLog[len(auditLog)-maxAuditEntries:]...)
+	}
+	err := saveAdminState()
+	adminMu.Unlock()
+
+	logEvent(slog.LevelInfo, "admin_action", "admin", entry.Admin, "ip", entry.IP, "action", action, "target", target, "details", details)
+	if err != nil {
+		logEvent(slog.LevelError, "admin_state_write_error", "path", config.Admin.StatePath, "error", err)
+	}
+}
+
+// Пароли администраторов хранятся как PBKDF2-HMAC-SHA256 с солью:
+// pbkdf2-sha256$<итерации>$<соль hex>$<хэш hex>. Хэш печатает
+// команда "url-shortener hash-password" (пароль читается со stdin)
+const (
+	adminHashScheme        = "pbkdf2-sha256"
+	adminHashIterations    = 600000
+	minAdminHashIterations = 10000
+	adminSaltBytes         = 16
+)
+
+// PBKDF2 (RFC 8018) с HMAC-SHA256, ключ длиной в один блок
+func pbkdf2SHA256(password, salt []byte, iterations int) []byte {
+	mac := hmac.New(sha256.New, password)
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+	key := append([]byte(nil), u...)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
+
+// Хэш пароля для admin.users со случайной солью
+func hashAdminPassword(password string, iterations int) string {
+	salt := make([]byte, adminSaltBytes)
+	rand.Read(salt)
+	key := pbkdf2SHA256([]byte(password), salt, iterations)
+	return fmt.Sprintf("%s$%d$%s$%s", adminHashScheme, iterations, hex.EncodeToString(salt), hex.EncodeToString(key))
+}
+
+// Разбор хэша из admin.users
+func parseAdminHash(hash string) (iterations int, salt, key []byte, ok bool) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != adminHashScheme {
+		return 0, nil, nil, false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return 0, nil, nil, false
+	}
+	salt, err = hex.DecodeString(parts[2])
+	if err != nil || len(salt) < 8 {
+		return 0, nil, nil, false
+	}
+	key, err = hex.DecodeString(parts[3])
+	if err != nil || len(key) != sha256.Size {
+		return 0, nil, nil, false
+	}
+	return iterations, salt, key, true
+}
+
+// Команда hash-password: пароль из stdin (одна строка), хэш в stdout
+func printAdminPasswordHash() {
+	data, err := io.ReadAll(io.LimitReader(os.Stdin, 4096))
+	if err != nil {
+		fatal("password_read_error", err)
+	}
+	password := strings.TrimRight(string(data), "\r\n")
+	if password == "" {
+		fatal("password_read_error", errors.New("пустой пароль"))
+	}
+	fmt.Println(hashAdminPassword(password, adminHashIterations))
+}
+
+// Хэш для проверки неизвестных логинов: ответ занимает столько же времени
+var dummyAdminHash = sync.OnceValue(func() string {
+	return hashAdminPassword(randomToken(), adminHashIterations)
+})
+
+// Проверка логина и пароля по списку admin.users
+func checkAdminPassword(login, password string) bool {
+	hash, found := dummyAdminHash(), false
+	for _, user := range config.Admin.Users {
+		if name, h, _ := strings.Cut(user, ":"); name == login && !found {
+			hash, found = h, true
+		}
+	}
+	iterations, salt, key, ok := parseAdminHash(hash)
+	if !ok {
+		return false
+	}
+	got := pbkdf2SHA256([]byte(password), salt, iterations)
+	return subtle.ConstantTimeCompare(got, key) == 1 && found
+}
+
+// Счетчики пары (IP, логин) и всего IP; в IP нет "/", поэтому ключи не пересекаются
+func loginFailureKeys(login, ip string) [2]string {
+	return [2]string{"login:" + ip + "/" + login, "ip:" + ip}
+}
+
+// Сколько еще ждать, если вход для логина с этого IP или для IP заблокирован
+func loginLockedFor(login, ip string, now time.Time) time.Duration {
+	adminMu.Lock()
+	defer adminMu.Unlock()
+	var wait time.Duration
+	for _, key := range loginFailureKeys(login, ip) {
+		if f := loginFailures[key]; f != nil {
+			wait = max(wait, f.LockedUntil.Sub(now))
+		}
+	}
+	return wait
+}
+
+// Учет неудачного входа; при пятой ошибке за окно включается блокировка
+func recordLoginFailure(login, ip string, now time.Time) {
+	adminMu.Lock()
+	defer adminMu.Unlock()
+	if len(loginFailures) >= maxLoginFailureEntries {
+		for key, f := range loginFailures {
+			if now.Sub(f.First) > loginFailureWindow && now.After(f.LockedUntil) {
+				delete(loginFailures, key)
+			}
+		}
+	}
+	for _, key := range loginFailureKeys(login, ip) {
+		f := loginFailures[key]
+		if f == nil || now.Sub(f.First) > loginFailureWindow {
+			f = &loginFailure{First: now}
+			loginFailures[key] = f
+		}
+		f.Count++
+		if f.Count >= maxLoginFailures {
+			f.LockedUntil = now.Add(loginLockout)
+			f.Count, f.First = 0, now
+		}
+	}
+}
+
+// Успешный вход сбрасывает счетчики этого IP
+func clearLoginFailures(login, ip string) {
+	adminMu.Lock()
+	defer adminMu.Unlock()
+	for _, key := range loginFailureKeys(login, ip) {
+		delete(loginFailures, key)
+	}
+}
+
+const adminCookie = "admin_session"
+
+// Текущий вход администратора или nil
+func adminFrom(r *http.Request) *adminSession {
+	c, err := r.Cookie(adminCookie)
+	if err != nil {
+		return nil
+	}
+	adminMu.Lock()
+	defer adminMu.Unlock()
+	s := sessions[c.Value]
+	if s == nil {
+		return nil
+	}
+	if time.Now().After(s.Expires) {
+		delete(sessions, c.Value)
+		return nil
+	}
+	return s
+}
+
+func startAdminSession(w http.ResponseWriter, r *http.Request, user string) *adminSession {
+	token := randomToken()
+	s := &adminSession{
+		User:    user,
+		CSRF:    randomToken(),
+		Expires: time.Now().Add(config.Admin.SessionTTL),
+	}
+
+	adminMu.Lock()
+	now := time.Now()
+	for t, old := range sessions {
+		if now.After(old.Expires) {
+			delete(sessions, t)
+		}
+	}
+	sessions[token] = s
+	adminMu.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     adminCookie,
+		Value:    token,
+		Path:     "/admin",
+		MaxAge:   int(config.Admin.SessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   requestScheme(r) == "https",
+		SameSite: http.SameSiteStrictMode,
+	})
+	return s
+}
+
+// Обертка для страниц администратора: нужен вход, а POST-запросам - CSRF токен
+func requireAdmin(next func(w http.ResponseWriter, r *http.Request, s *adminSession)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !adminEnabled() {
+			http.NotFound(w, r)
+			return
+		}
+		s := adminFrom(r)
+		if s == nil {
+			http.Redirect(w, r, "/admin/login", http.StatusFound)
+			return
+		}
+		if r.Method == "POST" && subtle.ConstantTimeCompare([]byte(r.FormValue("csrf")), []byte(s.CSRF)) != 1 {
+			http.Error(w, localeFrom(r).T("error.csrf"), http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		next(w, r, s)
+	}
+}
+
+// Адрес возврата к результатам поиска. Параметры пересобираются,
+// чтобы back не стал открытым редиректом.
+func adminBackURL(back string) string {
+	query, _ := url.ParseQuery(back)
+	if len(query) == 0 {
+		return "/admin"
+	}
+	return "/admin?" + query.Encode()
+}
+
+// Фильтр поиска ссылок
+type adminFilter struct {
+	Query  string // часть кода или адреса
+	Owner  string // IP владельца
+	Domain string
+	From   string // дата создания, ГГГГ-ММ-ДД
+	To     string
+	Status string // "" - любой, иначе active, paused, disabled или expired
+}
+
+func parseAdminFilter(r *http.Request) adminFilter {
+	q := r.URL.Query()
+	return adminFilter{
+		Query:  strings.TrimSpace(q.Get("q")),
+		Owner:  strings.TrimSpace(q.Get("owner")),
+		Domain: q.Get("domain"),
+		From:   q.Get("from"),
+		To:     q.Get("to"),
+		Status: q.Get("status"),
+	}
+}
+
+func (f adminFilter) encode() string {
+	q := url.Values{}
+	for name, value := range map[string]string{"q": f.Query, "owner": f.Owner, "domain": f.Domain, "from": f.From, "to": f.To, "status": f.Status} {
+		if value != "" {
+			q.Set(name, value)
+		}
+	}
+	return q.Encode()
+}
+
+// Строка результатов поиска
+type adminLinkRow struct {
+	Domain      string
+	Code        string
+	ShortURL    string
+	OriginalURL string
+	Owner       string
+	OwnerBanned bool
+	CreatedAt   time.Time
+	Visits      int
+	Disabled    bool   // отключена администратором
+	Status      string // active, paused, disabled или expired
+}
+
+// Поиск по всем ссылкам; возвращает первые limit новых и общее число найденных
+func searchLinks(r *http.Request, f adminFilter, limit int) ([]adminLinkRow, int) {
+	query := strings.ToLower(f.Query)
+	var from, to time.Time
+	if t, err := time.ParseInLocation("2006-01-02", f.From, time.Local); err == nil {
+		from = t
+	}
+	if t, err := time.ParseInLocation("2006-01-02", f.To, time.Local); err == nil {
+		to = t.AddDate(0, 0, 1)
+	}
+
+	var rows []adminLinkRow
+	now := time.Now()
+	mutex.RLock()
+	for key, link := range links {
+		switch {
+		case query != "" && !strings.Contains(strings.ToLower(key.Code), query) && !strings.Contains(strings.ToLower(link.OriginalURL), query):
+			continue
+		case f.Owner != "" && link.IP != f.Owner:
+			continue
+		case f.Domain != "" && key.Domain != f.Domain:
+			continue
+		case !from.IsZero() && link.CreatedAt.Before(from):
+			continue
+		case !to.IsZero() && !link.CreatedAt.Before(to):
+			continue
+		case f.Status != "" && statusName(link.state(now)) != f.Status:
+			continue
+		}
+		rows = append(rows, adminLinkRow{
+			Domain:      key.Domain,
+			Code:        key.Code,
+			OriginalURL: link.OriginalURL,
+			Owner:       link.IP,
+			CreatedAt:   link.CreatedAt,
+			Visits:      link.Visits.Load(),
+			Disabled:    link.Disabled,
+			Status:      statusName(link.state(now)),
+		})
+	}
+	mutex.RUnlock()
+
+	sort.Slice(rows, func(i, j int) bool { return rows[i].CreatedAt.After(rows[j].CreatedAt) })
+	total := len(rows)
+	if len(rows) > limit {
+		rows = rows[:limit]
+	}
+
+	adminMu.Lock()
+	for i := range rows {
+		rows[i].ShortURL = shortLinkURL(r, rows[i].Domain, rows[i].Code)
+		rows[i].OwnerBanned = bans[rows[i].Owner] != nil
+	}
+	adminMu.Unlock()
+	return rows, total
+}
+
+func newAdminPage(r *http.Request, name string, s *adminSession) adminPage {
+	return adminPage{page: newPage(r, name), Admin: s.User, CSRF: s.CSRF, Section: name}
+}
+
+// Страницы раздела /admin
+func registerAdminHandlers() {
+	http.HandleFunc("/admin/login", adminLoginHandler)
+
+	http.HandleFunc("/admin/logout", requireAdmin(func(w http.ResponseWriter, r *http.Request, s *adminSession) {
+		if r.Method != "POST" {
+			http.Redirect(w, r, "/admin", http.StatusFound)
+			return
+		}
+		if c, err := r.Cookie(adminCookie); err == nil {
+			adminMu.Lock()
+			delete(sessions, c.Value)
+			adminMu.Unlock()
+		}
+		http.SetCookie(w, &http.Cookie{Name: adminCookie, Path: "/admin", MaxAge: -1})
+		audit(s, r, "logout", "", "")
+		http.Redirect(w, r, "/", http.StatusFound)
+	}))
+
+	// Поиск ссылок
+	http.HandleFunc("/admin", requireAdmin(func(w http.ResponseWriter, r *http.Request, s *adminSession) {
+		filter := parseAdminFilter(r)
+		rows, total := searchLinks(r, filter, 200)
+		renderPage(w, r, "admin", adminLinksPage{
+			adminPage: newAdminPage(r, "admin", s),
+			Filter:    filter,
+			Domains:   domainChoices(),
+			Rows:      rows,
+			Total:     total,
+			Back:      filter.encode(),
+		})
+	}))
+
+	// Действия со ссылкой: отключить, включить, удалить, передать другому владельцу
+	http.HandleFunc("/admin/links", requireAdmin(adminLinksHandler))
+
+	// Блокировки IP
+	http.HandleFunc("/admin/bans", requireAdmin(func(w http.ResponseWriter, r *http.Request, s *adminSession) {
+		if r.Method == "POST" {
+			ip := strings.TrimSpace(r.FormValue("ip"))
+			if net.ParseIP(ip) == nil {
+				http.Error(w, localeFrom(r).T("admin.invalid_ip"), http.StatusBadRequest)
+				return
+			}
+			reason := strings.TrimSpace(r.FormValue("reason"))
+			action := r.FormValue("action")
+
+			adminMu.Lock()
+			switch action {
+			case "ban":
+				bans[ip] = &ban{IP: ip, Reason: reason, By: s.User, At: time.Now()}
+			case "unban":
+				delete(bans, ip)
+			}
+			adminMu.Unlock()
+			if action == "ban" || action == "unban" {
+				audit(s, r, action, ip, reason)
+			}
+
+			if back := r.FormValue("back"); back != "" {
+				http.Redirect(w, r, adminBackURL(back), http.StatusFound)
+			} else {
+				http.Redirect(w, r, "/admin/bans", http.StatusFound)
+			}
+			return
+		}
+
+		data := adminBansPage{adminPage: newAdminPage(r, "admin_bans", s)}
+		adminMu.Lock()
+		for _, b := range bans {
+			data.Bans = append(data.Bans, *b)
+		}
+		adminMu.Unlock()
+		sort.Slice(data.Bans, func(i, j int) bool { return data.Bans[i].At.After(data.Bans[j].At) })
+		renderPage(w, r, "admin_bans", data)
+	}))
+
+	// Журнал действий, новые записи сверху
+	http.HandleFunc("/admin/audit", requireAdmin(func(w http.ResponseWriter, r *http.Request, s *adminSession) {
+		data := adminAuditPage{adminPage: newAdminPage(r, "admin_audit", s)}
+		adminMu.Lock()
+		for i := len(auditLog) - 1; i >= 0 && len(data.Entries) < 500; i-- {
+			data.Entries = append(data.Entries, auditLog[i])
+		}
+		adminMu.Unlock()
+		renderPage(w, r, "admin_audit", data)
+	}))
+}
+
+// Действия администратора со ссылкой (POST /admin/links)
+func adminLinksHandler(w http.ResponseWriter, r *http.Request, s *adminSession) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/admin", http.StatusFound)
+		return
+	}
+	key := linkKey{r.FormValue("domain"), r.FormValue("code")}
+	action := r.FormValue("action")
+	owner := strings.TrimSpace(r.FormValue("owner"))
+	if action == "reassign" && net.ParseIP(owner) == nil {
+		http.Error(w, localeFrom(r).T("admin.invalid_ip"), http.StatusBadRequest)
+		return
+	}
+
+	var details string
+	var deleted *linkEvent
+	mutex.Lock()
+	link, exists := links[key]
+	if exists {
+		switch action {
+		// Флаг отключения отдельный: включение не снимает паузу владельца
+		case "disable":
+			link.Disabled = true
+		case "enable":
+			link.Disabled = false
+		case "delete":
+			event := deleteLink(key, link)
+			deleted = &event
+			details = link.OriginalURL
+		case "reassign":
+			details = link.IP + " -> " + owner
+			removeOwnerLink(link.IP, key)
+			reassignInLeaderboard(key, link, owner)
+			link.IP = owner
+			ipLinks[owner] = append(ipLinks[owner], key)
+		default:
+			exists = false
+		}
+	}
+	mutex.Unlock()
+
+	if deleted != nil {
+		publishEvent(*deleted)
+	}
+	if exists {
+		markDirty()
+		audit(s, r, action, shortLinkURL(r, key.Domain, key.Code), details)
+	}
+	http.Redirect(w, r, adminBackURL(r.FormValue("back")), http.StatusFound)
+}
+
+// Вход администратора
+func adminLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if !adminEnabled() {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	data := adminLoginPage{page: newPage(r, "admin_login")}
+
+	if r.Method == "POST" {
+		user, ip, now := r.FormValue("user"), getIP(r), time.Now()
+		data.User = user
+		if wait := loginLockedFor(user, ip, now); wait > 0 {
+			logEvent(slog.LevelWarn, "admin_login_locked", "user", user, "ip", ip)
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			data.Locked = int(wait.Minutes()) + 1
+			renderPageStatus(w, r, http.StatusTooManyRequests, "admin_login", data)
+			return
+		}
+		if checkAdminPassword(user, r.FormValue("password")) {
+			clearLoginFailures(user, ip)
+			s := startAdminSession(w, r, user)
+			audit(s, r, "login", "", "")
+			http.Redirect(w, r, "/admin", http.StatusFound)
+			return
+		}
+
+		logEvent(slog.LevelWarn, "admin_login_failed", "user", user, "ip", ip)
+		recordLoginFailure(user, ip, now)
+		data.Failed = true
+		renderPageStatus(w, r, http.StatusUnauthorized, "admin_login", data)
+		return
+	}
+	renderPage(w, r, "admin_login", data)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Заблокированный IP не обходит блокировку подменой X-Forwarded-For
+func TestBanUsesConnectionAddress(t *testing.T) {
+	resetState()
+	config.Storage.Backend = "memory"
+	config.Server.TrustedProxies = []string{"10.0.0.1"}
+	bans["203.0.113.7"] = &ban{IP: "203.0.113.7"}
+	handler := requireOwner(func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, tc := range []struct {
+		remote, forwarded string
+		code              int
+	}{
+		{"203.0.113.7:5000", "", http.StatusForbidden},
+		{"203.0.113.7:5000", "198.51.100.1", http.StatusForbidden},
+		{"10.0.0.1:5000", "198.51.100.1, 203.0.113.7", http.StatusForbidden},
+		{"10.0.0.1:5000", "203.0.113.7, 198.51.100.1", http.StatusOK},
+		{"198.51.100.1:5000", "203.0.113.7", http.StatusOK},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/my", nil)
+		r.RemoteAddr = tc.remote
+		if tc.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tc.forwarded)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%s, XFF %q: код %d, ожидался %d", tc.remote, tc.forwarded, w.Code, tc.code)
+		}
+	}
+}
+
+func TestPBKDF2Vector(t *testing.T) {
+	// RFC 7914, раздел 11
+	got := hex.EncodeToString(pbkdf2SHA256([]byte("passwd"), []byte("salt"), 1))
+	if want := "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc"; got != want {
+		t.Errorf("pbkdf2SHA256 = %s, ожидалось %s", got, want)
+	}
+}
+
+func TestCheckAdminPassword(t *testing.T) {
+	resetState()
+	hash := hashAdminPassword("длинный пароль", minAdminHashIterations)
+	if other := hashAdminPassword("длинный пароль", minAdminHashIterations); other == hash {
+		t.Error("одинаковый хэш для двух вызовов: соль не случайная")
+	}
+	config.Admin.Users = []string{"admin:" + hash}
+
+	for _, tc := range []struct {
+		login, password string
+		ok              bool
+	}{
+		{"admin", "длинный пароль", true},
+		{"admin", "длинный пароль ", false},
+		{"admin", "", false},
+		{"root", "длинный пароль", false},
+	} {
+		if got := checkAdminPassword(tc.login, tc.password); got != tc.ok {
+			t.Errorf("checkAdminPassword(%q, %q) = %v", tc.login, tc.password, got)
+		}
+	}
+
+	for hash, ok := range map[string]bool{
+		hashAdminPassword("x", minAdminHashIterations): true,
+		hashAdminPassword("x", 1000):                   false,
+		strings.Repeat("ab", 32):                       false,
+		"pbkdf2-sha256$10000$zz$00":                    false,
+		"bcrypt$10000$00112233445566778899$00":         false,
+	} {
+		if got := validAdminHash(hash); got != ok {
+			t.Errorf("validAdminHash(%q) = %v, ожидалось %v", hash, got, ok)
+		}
+	}
+}
+
+// После пяти ошибок вход блокируется и по логину, и по IP
+func TestAdminLoginLockout(t *testing.T) {
+	resetState()
+	config.Storage.Backend = "memory"
+	config.Admin.Users = []string{"admin:" + hashAdminPassword("верный", minAdminHashIterations)}
+	if err := loadLocales(); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(user, password, ip string) *httptest.ResponseRecorder {
+		form := url.Values{"user": {user}, "password": {password}}
+		r := httptest.NewRequest(http.MethodPost, "/admin/login", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.RemoteAddr = ip + ":5000"
+		w := httptest.NewRecorder()
+		adminLoginHandler(w, r)
+		return w
+	}
+
+	for i := 0; i < maxLoginFailures; i++ {
+		if w := login("admin", "неверный", "198.51.100.1"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("попытка %d: код %d", i+1, w.Code)
+		}
+	}
+	w := login("admin", "верный", "198.51.100.1")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("после %d ошибок: код %d, Retry-After %q", maxLoginFailures, w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := login("other", "верный", "198.51.100.1"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("IP заблокирован, но для другого логина код %d", w.Code)
+	}
+
+	// Блокировка истекает
+	for _, f := range loginFailures {
+		f.LockedUntil = time.Now().Add(-time.Second)
+	}
+	if w := login("admin", "верный", "198.51.100.1"); w.Code != http.StatusFound {
+		t.Errorf("после блокировки: код %d", w.Code)
+	}
+	if len(loginFailures) != 0 {
+		t.Errorf("успешный вход не сбросил счетчики: %d", len(loginFailures))
+	}
+}
+
+// Чужой перебор пароля к логину не запирает администратора на его IP
+func TestAdminLoginLockoutPerIP(t *testing.T) {
+	resetState()
+	config.Storage.Backend = "memory"
+	config.Admin.Users = []string{"admin:" + hashAdminPassword("верный", minAdminHashIterations)}
+	if err := loadLocales(); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(password, ip string) int {
+		form := url.Values{"user": {"admin"}, "password": {password}}
+		r := httptest.NewRequest(http.MethodPost, "/admin/login", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.RemoteAddr = ip + ":5000"
+		w := httptest.NewRecorder()
+		adminLoginHandler(w, r)
+		return w.Code
+	}
+
+	for i := 0; i < maxLoginFailures; i++ {
+		login("неверный", "203.0.113.7")
+	}
+	if code := login("верный", "203.0.113.7"); code != http.StatusTooManyRequests {
+		t.Errorf("IP перебора не заблокирован: код %d", code)
+	}
+	if code := login("верный", "198.51.100.2"); code != http.StatusFound {
+		t.Errorf("верный пароль с другого IP: код %d, ожидался вход", code)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// JSON API кабинета. Владелец определяется так же, как в /my - по IP.
+
+type apiLink struct {
+	ShortURL      string         `json:"short_url"`
+	Domain        string         `json:"domain,omitempty"`
+	Code          string         `json:"code"`
+	OriginalURL   string         `json:"original_url"`
+	Title         string         `json:"title,omitempty"`
+	Description   string         `json:"description,omitempty"`
+	Favicon       string         `json:"favicon,omitempty"`
+	OGTitle       string         `json:"og_title,omitempty"`
+	OGDescription string         `json:"og_description,omitempty"`
+	OGImage       string         `json:"og_image,omitempty"`
+	ForwardQuery  bool           `json:"forward_query,omitempty"`
+	Rules         []redirectRule `json:"rules,omitempty"`
+	Variants      []variantStats `json:"variants,omitempty"`
+	Fallback      string         `json:"fallback,omitempty"`
+	Alerts        []alertRule    `json:"alerts,omitempty"`
+	Tags          []string       `json:"tags"`
+	Folder        string         `json:"folder,omitempty"`
+	Visits        int            `json:"visits"`
+	Status        string         `json:"status"`
+	CreatedAt     time.Time      `json:"created_at"`
+	ExpiresAt     *time.Time     `json:"expires_at,omitempty"`
+}
+
+// Тело POST /api/links
+type apiLinkRequest struct {
+	URL     string   `json:"url"`
+	Domain  string   `json:"domain"`
+	Title   string   `json:"title"`
+	Tags    []string `json:"tags"`
+	Folder  string   `json:"folder"`
+	Expires string   `json:"expires"` // 1h, 1d, 7d, 30d или 365d
+	UTM     struct {
+		Source   string `json:"source"`
+		Medium   string `json:"medium"`
+		Campaign string `json:"campaign"`
+		Term     string `json:"term"`
+		Content  string `json:"content"`
+	} `json:"utm"`
+	ForwardQuery bool           `json:"forward_query"`
+	Rules        []redirectRule `json:"rules"`
+	Variants     []*linkVariant `json:"variants"` // вес и адрес; счетчики игнорируются
+	Fallback     string         `json:"fallback"` // веб-адрес для диплинка
+	Alerts       []*alertRule   `json:"alerts"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Тело запроса к API - только application/json: HTML-форма с чужого сайта
+// такой тип не отправит, а fetch с ним требует CORS-разрешения, которого
+// сервер не дает. Поэтому API обходится без CSRF-токена.
+func acceptsBody(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == "GET" || r.Method == "HEAD" {
+		return true
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		writeJSON(w, http.StatusUnsupportedMediaType, map[string]string{"error": "Content-Type must be application/json"})
+		return false
+	}
+	return true
+}
+
+// GET /api/links?tag=&folder=&q= - ссылки владельца, новые сверху;
+// POST /api/links - создание ссылки
+func apiLinksHandler(w http.ResponseWriter, r *http.Request) {
+	if !acceptsBody(w, r) {
+		return
+	}
+	if r.Method == "POST" {
+		apiCreateLink(w, r)
+		return
+	}
+	if r.Method != "GET" {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	filter := parseLinkFilter(r.URL.Query())
+	ip := getIP(r)
+	now := time.Now()
+
+	result := []apiLink{}
+	mutex.RLock()
+	search := newSearchQuery(filter.Query)
+	for _, key := range ipLinks[ip] {
+		link, exists := links[key]
+		if !exists || !filter.match(link) || !search.match(key) {
+			continue
+		}
+		result = append(result, apiLink{
+			Domain:        key.Domain,
+			Code:          key.Code,
+			OriginalURL:   link.OriginalURL,
+			Title:         link.Title,
+			Description:   link.Description,
+			Favicon:       link.Favicon,
+			OGTitle:       link.OGTitle,
+			OGDescription: link.OGDescription,
+			OGImage:       link.OGImage,
+			ForwardQuery:  link.ForwardQuery,
+			Rules:         link.Rules,
+			Variants:      collectVariantStats(link.Variants),
+			Fallback:      link.Fallback,
+			Alerts:        copyAlerts(link.Alerts),
+			Tags:          append([]string{}, link.Tags...),
+			Folder:        link.Folder,
+			Visits:        link.Visits.Load(),
+			Status:        statusName(link.state(now)),
+			CreatedAt:     link.CreatedAt,
+			ExpiresAt:     link.ExpiresAt,
+		})
+	}
+	mutex.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool { return result[i].CreatedAt.After(result[j].CreatedAt) })
+	for i := range result {
+		result[i].ShortURL = shortLinkURL(r, result[i].Domain, result[i].Code)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"links": result})
+}
+
+func apiCreateLink(w http.ResponseWriter, r *http.Request) {
+	var req apiLinkRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON: " + err.Error()})
+		return
+	}
+	if strings.TrimSpace(req.URL) == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "url is required"})
+		return
+	}
+	now := time.Now()
+	expires := parseExpiry(req.Expires, now)
+	if req.Expires != "" && expires == nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unknown expires value"})
+		return
+	}
+	originalURL, err := applyUTM(normalizeURL(req.URL), map[string]string{
+		"utm_source":   req.UTM.Source,
+		"utm_medium":   req.UTM.Medium,
+		"utm_campaign": req.UTM.Campaign,
+		"utm_term":     req.UTM.Term,
+		"utm_content":  req.UTM.Content,
+	})
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid url"})
+		return
+	}
+
+	if err := normalizeRules(req.Rules); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid rules: " + errorText(locales["en"], err)})
+		return
+	}
+	fallback, err := normalizeFallback(req.Fallback)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid fallback: " + errorText(locales["en"], err)})
+		return
+	}
+	if fallback != "" && !isDeepLink(originalURL) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "fallback is only allowed for app links (deeplinks.schemes)"})
+		return
+	}
+	if len(req.Alerts) > 0 && !config.Features.Notifications {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "notifications are disabled"})
+		return
+	}
+	variants, err := newVariants(req.Variants)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid variants: " + errorText(locales["en"], err)})
+		return
+	}
+
+	domain := req.Domain
+	if !isKnownDomain(domain) {
+		domain = requestDomain(r)
+	}
+	ip := getIP(r)
+	if isBanned(ip) {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "banned"})
+		return
+	}
+	if err := normalizeAlerts(req.Alerts, ip); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid alerts: " + errorText(locales["en"], err)})
+		return
+	}
+
+	link := &Link{
+		OriginalURL:  originalURL,
+		CreatedAt:    now,
+		IP:           ip,
+		Domain:       domain,
+		ExpiresAt:    expires,
+		Tags:         parseTags(strings.Join(req.Tags, ",")),
+		Folder:       normalizeFolder(req.Folder),
+		Title:        cleanText(req.Title, maxTitleLength),
+		ForwardQuery: req.ForwardQuery,
+		Rules:        req.Rules,
+		Variants:     variants,
+		Fallback:     fallback,
+		Alerts:       req.Alerts,
+	}
+	key := createLink(link)
+	w.Header().Set("Location", "/api/links?q="+url.QueryEscape(key.Code))
+	writeJSON(w, http.StatusCreated, apiLink{
+		ShortURL:     shortLinkURL(r, key.Domain, key.Code),
+		Domain:       key.Domain,
+		Code:         key.Code,
+		OriginalURL:  link.OriginalURL,
+		Title:        link.Title,
+		ForwardQuery: link.ForwardQuery,
+		Rules:        link.Rules,
+		Variants:     collectVariantStats(link.Variants),
+		Fallback:     link.Fallback,
+		Alerts:       copyAlerts(link.Alerts),
+		Tags:         append([]string{}, link.Tags...),
+		Folder:       link.Folder,
+		Status:       statusName(statusActive),
+		CreatedAt:    link.CreatedAt,
+		ExpiresAt:    link.ExpiresAt,
+	})
+}
+
+// GET /api/tags - теги и папки владельца со счетчиками
+func apiTagsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	type count struct {
+		Name   string `json:"name"`
+		Links  int    `json:"links"`
+		Visits int    `json:"visits"`
+	}
+	convert := func(list []tagCount) []count {
+		result := []count{}
+		for _, c := range list {
+			result = append(result, count{c.Name, c.Links, c.Visits})
+		}
+		return result
+	}
+
+	mutex.RLock()
+	tags, folders := collectTags(ipLinks[getIP(r)])
+	mutex.RUnlock()
+	writeJSON(w, http.StatusOK, map[string]any{"tags": convert(tags), "folders": convert(folders)})
+}
@@ -0,0 +1,726 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Конфигурация сервиса
+type Config struct {
+	Server    ServerConfig
+	Storage   StorageConfig
+	Codes     CodesConfig
+	Stats     StatsConfig
+	UI        UIConfig
+	I18n      I18nConfig
+	Log       LogConfig
+	Limits    LimitsConfig
+	Admin     AdminConfig
+	Metadata  MetadataConfig
+	GeoIP     GeoIPConfig
+	DeepLinks DeepLinksConfig
+	Webhooks  WebhooksConfig
+	Notify    NotifyConfig
+	Features  FeaturesConfig
+}
+
+type ServerConfig struct {
+	ListenAddr   string   // адрес, на котором слушает HTTP сервер
+	BaseURL      string   // публичный адрес для коротких ссылок (пусто - берём из запроса)
+	Scheme       string   // схема для ссылок, если BaseURL не задан: auto, http или https
+	AllowedHosts []string // допустимые значения заголовка Host
+	Domains      []string // короткие домены со своими наборами кодов
+	// Адреса и подсети прокси, которым доверяем X-Forwarded-For и X-Forwarded-Proto
+	TrustedProxies []string
+
+	ShutdownTimeout time.Duration // сколько ждать завершения запросов при остановке
+}
+
+type StorageConfig struct {
+	Backend   string        // json или memory
+	Path      string        // путь к файлу базы данных
+	Autosave  time.Duration // максимальная задержка сохранения после изменения
+	SaveDelay time.Duration // пауза без изменений, после которой база сохраняется
+}
+
+type CodesConfig struct {
+	Length   int    // длина короткого кода
+	Alphabet string // символы, из которых генерируется код
+}
+
+type StatsConfig struct {
+	LeaderboardSize int    // сколько ссылок хранит каждый рейтинг
+	MissesPath      string // файл с переходами по несуществующим кодам
+}
+
+type UIConfig struct {
+	ThemeDir string // каталог с шаблонами и статикой, заменяющими встроенные
+}
+
+type I18nConfig struct {
+	DefaultLanguage string // язык интерфейса, если браузер не указал поддерживаемый
+	LogLanguage     string // язык сообщений в консоли
+}
+
+type LogConfig struct {
+	Level     string // debug, info, warn или error
+	Format    string // text или json
+	AccessLog bool   // писать строку на каждый HTTP запрос
+}
+
+type LimitsConfig struct {
+	RequestsPerMinute int // запросов в минуту с одного IP, 0 - без ограничения
+	Burst             int // сколько запросов можно сделать подряд сверх среднего темпа
+}
+
+type AdminConfig struct {
+	Users      []string      // администраторы в виде логин:хэш-пароля (hash-password); пусто - /admin выключен
+	StatePath  string        // файл с блокировками и журналом действий
+	SessionTTL time.Duration // время жизни входа
+}
+
+type MetadataConfig struct {
+	Fetch        bool          // загружать название и описание страницы назначения
+	Timeout      time.Duration // общее время загрузки одной страницы
+	MaxBytes     int           // сколько байт страницы читать
+	AllowPrivate bool          // разрешить адреса внутренней сети (только для отладки)
+}
+
+type GeoIPConfig struct {
+	Path string // CSV с диапазонами адресов и странами; пусто - правила по стране не работают
+}
+
+type DeepLinksConfig struct {
+	Schemes       []string      // схемы приложений, на которые можно делать ссылки
+	FallbackDelay time.Duration // сколько ждать открытия приложения перед переходом на веб-адрес
+}
+
+type WebhooksConfig struct {
+	Path         string        // файл с подписками и очередью доставок
+	Timeout      time.Duration // время одной попытки доставки
+	MaxAttempts  int           // попыток, после которых доставка считается недоставленной
+	RetryDelay   time.Duration // пауза перед первым повтором, дальше удваивается
+	AllowPrivate bool          // разрешить адреса внутренней сети
+}
+
+type NotifyConfig struct {
+	Path     string // файл с лентой уведомлений
+	SMTPAddr string // SMTP-релей для писем, host:port; пусто - письма не отправляются
+	SMTPFrom string // адрес отправителя писем
+	// Адреса и домены (@example.com), на которые владельцы могут получать
+	// письма; пусто - канал email выключен
+	EmailAllow []string
+}
+
+type FeaturesConfig struct {
+	Dashboard bool // страница /my
+	Stats     bool // страница /stats
+	Top       bool // страница /top
+	Metrics   bool // метрики Prometheus на /metrics
+	Webhooks  bool // вебхуки владельцев на /my/webhooks
+
+	Notifications bool // уведомления о переходах по правилам ссылок
+}
+
+// Значения по умолчанию
+func defaultConfig() Config {
+	return Config{
+		Server: ServerConfig{
+			ListenAddr: ":8974",
+			Scheme:     "auto",
+
+			ShutdownTimeout: 10 * time.Second,
+		},
+		Storage: StorageConfig{
+			Backend:   "json",
+			Path:      "data/links.json",
+			Autosave:  30 * time.Second,
+			SaveDelay: 2 * time.Second,
+		},
+		Codes: CodesConfig{
+			Length:   6,
+			Alphabet: "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789",
+		},
+		Stats: StatsConfig{
+			LeaderboardSize: 100,
+			MissesPath:      "data/misses.json",
+		},
+		I18n: I18nConfig{
+			DefaultLanguage: "ru",
+			LogLanguage:     "ru",
+		},
+		Log: LogConfig{
+			Level:     "info",
+			Format:    "text",
+			AccessLog: true,
+		},
+		Limits: LimitsConfig{
+			Burst: 20,
+		},
+		Admin: AdminConfig{
+			StatePath:  "data/admin.json",
+			SessionTTL: 12 * time.Hour,
+		},
+		Metadata: MetadataConfig{
+			Timeout:  5 * time.Second,
+			MaxBytes: 512 << 10,
+		},
+		DeepLinks: DeepLinksConfig{
+			FallbackDelay: 1500 * time.Millisecond,
+		},
+		Webhooks: WebhooksConfig{
+			Path:        "data/webhooks.json",
+			Timeout:     10 * time.Second,
+			MaxAttempts: 8,
+			RetryDelay:  30 * time.Second,
+		},
+		Notify: NotifyConfig{
+			Path:     "data/notifications.json",
+			SMTPFrom: "linkshorter@localhost",
+		},
+		Features: FeaturesConfig{
+			Dashboard: true,
+			Stats:     true,
+			Top:       true,
+			Metrics:   true,
+
+			Notifications: true,
+		},
+	}
+}
+
+// Префикс переменных окружения: server.listen -> LINKSHORTER_SERVER_LISTEN
+const envPrefix = "LINKSHORTER_"
+
+// Описание одной настройки: ключ в файле, флаг командной строки и setter
+type configOption struct {
+	key   string
+	flag  string
+	usage string
+	set   optionSetter
+}
+
+// Разбор значения настройки. Булевы флаги можно указать без значения:
+// -dashboard равносильно -dashboard=true.
+type optionSetter struct {
+	apply  func(c *Config, value string) error
+	isBool bool
+}
+
+var configOptions = []configOption{
+	{"server.listen", "listen", "адрес HTTP сервера", stringOpt(func(c *Config) *string { return &c.Server.ListenAddr })},
+	{"server.base_url", "base-url", "публичный адрес коротких ссылок", stringOpt(func(c *Config) *string { return &c.Server.BaseURL })},
+	{"server.scheme", "scheme", "схема ссылок, если base_url не задан: auto, http или https", stringOpt(func(c *Config) *string { return &c.Server.Scheme })},
+	{"server.allowed_hosts", "allowed-hosts", "допустимые домены через запятую", listOpt(func(c *Config) *[]string { return &c.Server.AllowedHosts })},
+	{"server.domains", "domains", "короткие домены через запятую, например https://x.co", listOpt(func(c *Config) *[]string { return &c.Server.Domains })},
+	{"server.trusted_proxies", "trusted-proxies", "прокси, которым доверяем X-Forwarded-For и X-Forwarded-Proto: IP или подсети через запятую", listOpt(func(c *Config) *[]string { return &c.Server.TrustedProxies })},
+	{"server.shutdown_timeout", "shutdown-timeout", "сколько ждать завершения запросов при остановке", durationOpt(func(c *Config) *time.Duration { return &c.Server.ShutdownTimeout })},
+	{"storage.backend", "storage", "хранилище: json или memory", stringOpt(func(c *Config) *string { return &c.Storage.Backend })},
+	{"storage.path", "db", "путь к файлу базы данных", stringOpt(func(c *Config) *string { return &c.Storage.Path })},
+	{"storage.autosave", "autosave", "максимальная задержка сохранения после изменения", durationOpt(func(c *Config) *time.Duration { return &c.Storage.Autosave })},
+	{"storage.save_delay", "save-delay", "пауза без изменений перед сохранением", durationOpt(func(c *Config) *time.Duration { return &c.Storage.SaveDelay })},
+	{"codes.length", "code-length", "длина короткого кода", intOpt(func(c *Config) *int { return &c.Codes.Length })},
+	{"codes.alphabet", "alphabet", "алфавит короткого кода", stringOpt(func(c *Config) *string { return &c.Codes.Alphabet })},
+	{"stats.leaderboard_size", "leaderboard-size", "сколько ссылок хранит каждый рейтинг", intOpt(func(c *Config) *int { return &c.Stats.LeaderboardSize })},
+	{"stats.misses_path", "misses-path", "файл с переходами по несуществующим кодам", stringOpt(func(c *Config) *string { return &c.Stats.MissesPath })},
+	{"ui.theme_dir", "theme", "каталог темы: templates/ и static/ поверх встроенных", stringOpt(func(c *Config) *string { return &c.UI.ThemeDir })},
+	{"i18n.default_language", "lang", "язык интерфейса по умолчанию: ru или en", stringOpt(func(c *Config) *string { return &c.I18n.DefaultLanguage })},
+	{"i18n.log_language", "log-lang", "язык сообщений в консоли: ru или en", stringOpt(func(c *Config) *string { return &c.I18n.LogLanguage })},
+	{"log.level", "log-level", "уровень логов: debug, info, warn или error", stringOpt(func(c *Config) *string { return &c.Log.Level })},
+	{"log.format", "log-format", "формат логов: text или json", stringOpt(func(c *Config) *string { return &c.Log.Format })},
+	{"log.access_log", "access-log", "писать журнал HTTP запросов", boolOpt(func(c *Config) *bool { return &c.Log.AccessLog })},
+	{"limits.requests_per_minute", "rate-limit", "запросов в минуту с одного IP, 0 - без ограничения", intOpt(func(c *Config) *int { return &c.Limits.RequestsPerMinute })},
+	{"limits.burst", "rate-burst", "запас запросов сверх среднего темпа", intOpt(func(c *Config) *int { return &c.Limits.Burst })},
+	{"admin.users", "admin-users", "администраторы через запятую: логин:хэш-пароля (см. команду hash-password)", listOpt(func(c *Config) *[]string { return &c.Admin.Users })},
+	{"admin.state_path", "admin-state", "файл с блокировками и журналом действий администраторов", stringOpt(func(c *Config) *string { return &c.Admin.StatePath })},
+	{"admin.session_ttl", "admin-session-ttl", "время жизни входа администратора", durationOpt(func(c *Config) *time.Duration { return &c.Admin.SessionTTL })},
+	{"metadata.fetch", "metadata-fetch", "загружать название и описание страниц назначения", boolOpt(func(c *Config) *bool { return &c.Metadata.Fetch })},
+	{"metadata.timeout", "metadata-timeout", "время загрузки одной страницы", durationOpt(func(c *Config) *time.Duration { return &c.Metadata.Timeout })},
+	{"metadata.max_bytes", "metadata-max-bytes", "сколько байт страницы читать", intOpt(func(c *Config) *int { return &c.Metadata.MaxBytes })},
+	{"metadata.allow_private", "metadata-allow-private", "разрешить загрузку из внутренней сети", boolOpt(func(c *Config) *bool { return &c.Metadata.AllowPrivate })},
+	{"geoip.path", "geoip", "CSV база стран по IP для правил перенаправления", stringOpt(func(c *Config) *string { return &c.GeoIP.Path })},
+	{"deeplinks.schemes", "deeplink-schemes", "схемы приложений для диплинков через запятую, например myapp,tg", listOpt(func(c *Config) *[]string { return &c.DeepLinks.Schemes })},
+	{"deeplinks.fallback_delay", "deeplink-fallback-delay", "сколько ждать открытия приложения перед переходом на веб-адрес", durationOpt(func(c *Config) *time.Duration { return &c.DeepLinks.FallbackDelay })},
+	{"webhooks.path", "webhooks-state", "файл с подписками на вебхуки и очередью доставок", stringOpt(func(c *Config) *string { return &c.Webhooks.Path })},
+	{"webhooks.timeout", "webhooks-timeout", "время одной попытки доставки вебхука", durationOpt(func(c *Config) *time.Duration { return &c.Webhooks.Timeout })},
+	{"webhooks.max_attempts", "webhooks-max-attempts", "попыток доставки вебхука до переноса в недоставленные", intOpt(func(c *Config) *int { return &c.Webhooks.MaxAttempts })},
+	{"webhooks.retry_delay", "webhooks-retry-delay", "пауза перед первым повтором доставки, дальше удваивается", durationOpt(func(c *Config) *time.Duration { return &c.Webhooks.RetryDelay })},
+	{"webhooks.allow_private", "webhooks-allow-private", "разрешить вебхуки на адреса внутренней сети", boolOpt(func(c *Config) *bool { return &c.Webhooks.AllowPrivate })},
+	{"notify.path", "notify-state", "файл с лентой уведомлений", stringOpt(func(c *Config) *string { return &c.Notify.Path })},
+	{"notify.smtp_addr", "smtp-addr", "SMTP-релей для писем с уведомлениями, host:port", stringOpt(func(c *Config) *string { return &c.Notify.SMTPAddr })},
+	{"notify.smtp_from", "smtp-from", "адрес отправителя писем с уведомлениями", stringOpt(func(c *Config) *string { return &c.Notify.SMTPFrom })},
+	{"notify.email_allow", "notify-email-allow", "адреса и домены (@example.com) через запятую, на которые разрешены письма", listOpt(func(c *Config) *[]string { return &c.Notify.EmailAllow })},
+	{"features.dashboard", "dashboard", "включить страницу /my", boolOpt(func(c *Config) *bool { return &c.Features.Dashboard })},
+	{"features.stats", "stats", "включить страницу /stats", boolOpt(func(c *Config) *bool { return &c.Features.Stats })},
+	{"features.top", "top", "включить страницу /top", boolOpt(func(c *Config) *bool { return &c.Features.Top })},
+	{"features.metrics", "metrics", "включить метрики Prometheus на /metrics", boolOpt(func(c *Config) *bool { return &c.Features.Metrics })},
+	{"features.webhooks", "webhooks", "включить вебхуки владельцев на /my/webhooks", boolOpt(func(c *Config) *bool { return &c.Features.Webhooks })},
+	{"features.notifications", "notifications", "включить уведомления о переходах", boolOpt(func(c *Config) *bool { return &c.Features.Notifications })},
+}
+
+func stringOpt(field func(c *Config) *string) optionSetter {
+	return optionSetter{apply: func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}}
+}
+
+func listOpt(field func(c *Config) *[]string) optionSetter {
+	return optionSetter{apply: func(c *Config, value string) error {
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*field(c) = items
+		return nil
+	}}
+}
+
+func intOpt(field func(c *Config) *int) optionSetter {
+	return optionSetter{apply: func(c *Config, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("ожидается целое число, получено %q", value)
+		}
+		*field(c) = n
+		return nil
+	}}
+}
+
+func boolOpt(field func(c *Config) *bool) optionSetter {
+	return optionSetter{isBool: true, apply: func(c *Config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("ожидается true или false, получено %q", value)
+		}
+		*field(c) = b
+		return nil
+	}}
+}
+
+func durationOpt(field func(c *Config) *time.Duration) optionSetter {
+	return optionSetter{apply: func(c *Config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("ожидается длительность (например 30s), получено %q", value)
+		}
+		*field(c) = d
+		return nil
+	}}
+}
+
+func findConfigOption(key string) *configOption {
+	for i := range configOptions {
+		if configOptions[i].key == key {
+			return &configOptions[i]
+		}
+	}
+	return nil
+}
+
+func (o configOption) envName() string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(o.key, ".", "_"))
+}
+
+// Загрузка конфигурации: значения по умолчанию < файл < переменные окружения < флаги
+func loadConfig(args []string) (Config, error) {
+	cfg := defaultConfig()
+
+	fs := flag.NewFlagSet("link-shorter", flag.ContinueOnError)
+	configPath := fs.String("config", "", "путь к файлу конфигурации (TOML)")
+	for _, opt := range configOptions {
+		usage := opt.usage + " (" + opt.key + ")"
+		if opt.set.isBool {
+			fs.Bool(opt.flag, false, usage)
+		} else {
+			fs.String(opt.flag, "", usage)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	// Файл конфигурации: флаг, затем переменная окружения, затем config.toml в текущем каталоге
+	path := *configPath
+	if path == "" {
+		path = os.Getenv(envPrefix + "CONFIG")
+	}
+	explicit := path != ""
+	if path == "" {
+		path = "config.toml"
+	}
+	if err := cfg.loadFile(path); err != nil {
+		if explicit || !errors.Is(err, os.ErrNotExist) {
+			return cfg, err
+		}
+	}
+
+	for _, opt := range configOptions {
+		if value, ok := os.LookupEnv(opt.envName()); ok {
+			if err := opt.set.apply(&cfg, value); err != nil {
+				return cfg, fmt.Errorf("%s: %w", opt.envName(), err)
+			}
+		}
+	}
+
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "config" || flagErr != nil {
+			return
+		}
+		for _, opt := range configOptions {
+			if opt.flag == f.Name {
+				if err := opt.set.apply(&cfg, f.Value.String()); err != nil {
+					flagErr = fmt.Errorf("-%s: %w", f.Name, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return cfg, flagErr
+	}
+
+	return cfg, cfg.validate()
+}
+
+// Чтение файла конфигурации в формате TOML (секции, строки, числа, булевы значения и массивы строк)
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return fmt.Errorf("%s:%d: некорректная секция %q", path, lineNo, line)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+
+		name, raw, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("%s:%d: ожидается ключ = значение", path, lineNo)
+		}
+		key := strings.TrimSpace(name)
+		if section != "" {
+			key = section + "." + key
+		}
+
+		opt := findConfigOption(key)
+		if opt == nil {
+			return fmt.Errorf("%s:%d: неизвестный параметр %q", path, lineNo, key)
+		}
+		value, err := parseTOMLValue(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s: %w", path, lineNo, key, err)
+		}
+		if err := opt.set.apply(c, value); err != nil {
+			return fmt.Errorf("%s:%d: %s: %w", path, lineNo, key, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// Удаление комментария, если # стоит не внутри строки
+func stripComment(line string) string {
+	inString := false
+	for i, ch := range line {
+		switch {
+		case ch == '"' && (i == 0 || line[i-1] != '\\'):
+			inString = !inString
+		case ch == '#' && !inString:
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// Значение приводится к строке: массивы склеиваются через запятую
+func parseTOMLValue(raw string) (string, error) {
+	switch {
+	case raw == "":
+		return "", errors.New("пустое значение")
+	case strings.HasPrefix(raw, "["):
+		if !strings.HasSuffix(raw, "]") {
+			return "", errors.New("незакрытый массив")
+		}
+		var items []string
+		for _, item := range strings.Split(raw[1:len(raw)-1], ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			value, err := parseTOMLValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, value)
+		}
+		return strings.Join(items, ","), nil
+	case strings.HasPrefix(raw, `"`):
+		value, err := strconv.Unquote(raw)
+		if err != nil {
+			return "", fmt.Errorf("некорректная строка %s", raw)
+		}
+		return value, nil
+	default:
+		return raw, nil
+	}
+}
+
+// Проверка конфигурации при старте
+func (c *Config) validate() error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if _, _, err := net.SplitHostPort(c.Server.ListenAddr); err != nil {
+		fail("server.listen: некорректный адрес %q: %v", c.Server.ListenAddr, err)
+	}
+
+	if c.Server.BaseURL != "" {
+		u, err := url.Parse(c.Server.BaseURL)
+		switch {
+		case err != nil:
+			fail("server.base_url: %v", err)
+		case u.Scheme != "http" && u.Scheme != "https":
+			fail("server.base_url: схема должна быть http или https, получено %q", u.Scheme)
+		case u.Host == "":
+			fail("server.base_url: не указан домен")
+		case strings.Trim(u.Path, "/") != "" || u.RawQuery != "" || u.Fragment != "":
+			fail("server.base_url: адрес не должен содержать путь или параметры")
+		}
+		c.Server.BaseURL = strings.TrimSuffix(c.Server.BaseURL, "/")
+	}
+
+	domainHosts := make(map[string]bool)
+	for i, raw := range c.Server.Domains {
+		u, err := url.Parse(raw)
+		switch {
+		case err != nil:
+			fail("server.domains: %v", err)
+		case u.Scheme != "http" && u.Scheme != "https":
+			fail("server.domains: %q: схема должна быть http или https", raw)
+		case u.Host == "":
+			fail("server.domains: %q: не указан домен", raw)
+		case strings.Trim(u.Path, "/") != "" || u.RawQuery != "" || u.Fragment != "":
+			fail("server.domains: %q: адрес не должен содержать путь или параметры", raw)
+		case domainHosts[strings.ToLower(u.Host)]:
+			fail("server.domains: домен %q указан дважды", u.Host)
+		default:
+			domainHosts[strings.ToLower(u.Host)] = true
+			c.Server.Domains[i] = strings.TrimSuffix(raw, "/")
+		}
+	}
+
+	if c.Server.Scheme != "auto" && c.Server.Scheme != "http" && c.Server.Scheme != "https" {
+		fail("server.scheme: должна быть auto, http или https, получено %q", c.Server.Scheme)
+	}
+
+	for i, host := range c.Server.AllowedHosts {
+		if strings.ContainsAny(host, "/?#@ ") {
+			fail("server.allowed_hosts: %q должен быть доменом без схемы и пути", host)
+		}
+		c.Server.AllowedHosts[i] = strings.ToLower(host)
+	}
+
+	for _, proxy := range c.Server.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			fail("server.trusted_proxies: %q не является IP адресом или подсетью", proxy)
+		}
+	}
+
+	if c.Server.ShutdownTimeout <= 0 {
+		fail("server.shutdown_timeout: должен быть больше нуля, получено %s", c.Server.ShutdownTimeout)
+	}
+
+	switch c.Storage.Backend {
+	case "json":
+		if c.Storage.Path == "" {
+			fail("storage.path: путь к базе данных не задан")
+		}
+	case "memory":
+	default:
+		fail("storage.backend: неизвестное хранилище %q (json или memory)", c.Storage.Backend)
+	}
+	if c.Storage.Autosave < time.Second {
+		fail("storage.autosave: интервал должен быть не меньше 1s, получено %s", c.Storage.Autosave)
+	}
+	if c.Storage.SaveDelay <= 0 || c.Storage.SaveDelay > c.Storage.Autosave {
+		fail("storage.save_delay: должна быть больше нуля и не больше autosave, получено %s", c.Storage.SaveDelay)
+	}
+
+	if c.Codes.Length < 4 || c.Codes.Length > 32 {
+		fail("codes.length: длина должна быть от 4 до 32, получено %d", c.Codes.Length)
+	}
+	seen := make(map[rune]bool)
+	for _, ch := range c.Codes.Alphabet {
+		if !isCodeChar(ch) {
+			fail("codes.alphabet: недопустимый символ %q (разрешены латиница, цифры, - и _)", ch)
+			break
+		}
+		if seen[ch] {
+			fail("codes.alphabet: символ %q повторяется", ch)
+			break
+		}
+		seen[ch] = true
+	}
+	if len(seen) < 2 {
+		fail("codes.alphabet: нужно минимум 2 разных символа")
+	}
+
+	if c.Stats.LeaderboardSize < 5 || c.Stats.LeaderboardSize > 10000 {
+		fail("stats.leaderboard_size: должен быть от 5 до 10000, получено %d", c.Stats.LeaderboardSize)
+	}
+	if c.Storage.Backend == "json" && c.Stats.MissesPath == "" {
+		fail("stats.misses_path: путь не задан")
+	}
+
+	if c.UI.ThemeDir != "" {
+		if info, err := os.Stat(c.UI.ThemeDir); err != nil || !info.IsDir() {
+			fail("ui.theme_dir: каталог %q не найден", c.UI.ThemeDir)
+		}
+	}
+
+	if !isSupportedLanguage(c.I18n.DefaultLanguage) {
+		fail("i18n.default_language: неподдерживаемый язык %q (%s)", c.I18n.DefaultLanguage, strings.Join(supportedLanguages, ", "))
+	}
+	if !isSupportedLanguage(c.I18n.LogLanguage) {
+		fail("i18n.log_language: неподдерживаемый язык %q (%s)", c.I18n.LogLanguage, strings.Join(supportedLanguages, ", "))
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		fail("log.level: неизвестный уровень %q (debug, info, warn или error)", c.Log.Level)
+	}
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		fail("log.format: должен быть text или json, получено %q", c.Log.Format)
+	}
+
+	if c.Limits.RequestsPerMinute < 0 {
+		fail("limits.requests_per_minute: не может быть отрицательным, получено %d", c.Limits.RequestsPerMinute)
+	}
+	if c.Limits.Burst < 1 {
+		fail("limits.burst: должен быть не меньше 1, получено %d", c.Limits.Burst)
+	}
+
+	logins := make(map[string]bool)
+	for _, user := range c.Admin.Users {
+		login, hash, _ := strings.Cut(user, ":")
+		switch {
+		case login == "":
+			fail("admin.users: %q: не указан логин (логин:хэш-пароля)", user)
+		case !validAdminHash(hash):
+			fail("admin.users: %s: хэш пароля должен иметь вид %s$итерации$соль$хэш (url-shortener hash-password)", login, adminHashScheme)
+		case logins[login]:
+			fail("admin.users: логин %q указан дважды", login)
+		}
+		logins[login] = true
+	}
+	if len(c.Admin.Users) > 0 && c.Storage.Backend == "json" && c.Admin.StatePath == "" {
+		fail("admin.state_path: путь не задан")
+	}
+	if c.Admin.SessionTTL < time.Minute {
+		fail("admin.session_ttl: должно быть не меньше 1m, получено %s", c.Admin.SessionTTL)
+	}
+
+	if c.Metadata.Timeout < 100*time.Millisecond || c.Metadata.Timeout > time.Minute {
+		fail("metadata.timeout: должно быть от 100ms до 1m, получено %s", c.Metadata.Timeout)
+	}
+	if c.Metadata.MaxBytes < 1024 || c.Metadata.MaxBytes > 16<<20 {
+		fail("metadata.max_bytes: должно быть от 1024 до 16777216, получено %d", c.Metadata.MaxBytes)
+	}
+
+	if c.GeoIP.Path != "" {
+		if info, err := os.Stat(c.GeoIP.Path); err != nil || info.IsDir() {
+			fail("geoip.path: файл %q не найден", c.GeoIP.Path)
+		}
+	}
+
+	for _, scheme := range c.DeepLinks.Schemes {
+		switch {
+		case !isSchemeName(scheme):
+			fail("deeplinks.schemes: %q не похоже на схему (буква, затем буквы, цифры, +, - и .)", scheme)
+		case hasTag(forbiddenSchemes, strings.ToLower(scheme)):
+			fail("deeplinks.schemes: схему %q разрешить нельзя", scheme)
+		}
+	}
+	if c.DeepLinks.FallbackDelay < 100*time.Millisecond || c.DeepLinks.FallbackDelay > 30*time.Second {
+		fail("deeplinks.fallback_delay: должно быть от 100ms до 30s, получено %s", c.DeepLinks.FallbackDelay)
+	}
+
+	if c.Features.Webhooks {
+		if c.Storage.Backend == "json" && c.Webhooks.Path == "" {
+			fail("webhooks.path: путь не задан")
+		}
+		if c.Webhooks.Timeout < 100*time.Millisecond || c.Webhooks.Timeout > time.Minute {
+			fail("webhooks.timeout: должно быть от 100ms до 1m, получено %s", c.Webhooks.Timeout)
+		}
+		if c.Webhooks.MaxAttempts < 1 || c.Webhooks.MaxAttempts > 20 {
+			fail("webhooks.max_attempts: должно быть от 1 до 20, получено %d", c.Webhooks.MaxAttempts)
+		}
+		if c.Webhooks.RetryDelay < time.Second || c.Webhooks.RetryDelay > maxWebhookBackoff {
+			fail("webhooks.retry_delay: должна быть от 1s до 1h, получено %s", c.Webhooks.RetryDelay)
+		}
+	}
+
+	if c.Features.Notifications {
+		if c.Storage.Backend == "json" && c.Notify.Path == "" {
+			fail("notify.path: путь не задан")
+		}
+		if c.Notify.SMTPAddr != "" {
+			if _, _, err := net.SplitHostPort(c.Notify.SMTPAddr); err != nil {
+				fail("notify.smtp_addr: некорректный адрес %q: %v", c.Notify.SMTPAddr, err)
+			}
+			if _, err := mail.ParseAddress(c.Notify.SMTPFrom); err != nil {
+				fail("notify.smtp_from: некорректный адрес %q", c.Notify.SMTPFrom)
+			}
+		}
+		for _, allow := range c.Notify.EmailAllow {
+			if domain, ok := strings.CutPrefix(allow, "@"); ok {
+				if domain == "" || strings.ContainsAny(domain, "@ ") {
+					fail("notify.email_allow: некорректный домен %q", allow)
+				}
+			} else if addr, err := mail.ParseAddress(allow); err != nil || addr.Address != allow {
+				fail("notify.email_allow: %q не похоже ни на адрес, ни на @домен", allow)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Хэш из admin.users в формате hashAdminPassword; слишком мало итераций
+// делают перебор дешевым
+func validAdminHash(hash string) bool {
+	iterations, _, _, ok := parseAdminHash(hash)
+	return ok && iterations >= minAdminHashIterations
+}
+
+// Символы, допустимые в коротком коде
+func isCodeChar(ch rune) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '-' || ch == '_'
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStripComment(t *testing.T) {
+	for line, want := range map[string]string{
+		`port = 8080`:                        `port = 8080`,
+		`port = 8080 # порт`:                 `port = 8080 `,
+		`# весь комментарий`:                 ``,
+		`title = "a # b"`:                    `title = "a # b"`,
+		`title = "a # b" # комментарий`:      `title = "a # b" `,
+		`title = "кавычка \" # внутри" # да`: `title = "кавычка \" # внутри" `,
+		`hosts = ["a", "b"] # список`:        `hosts = ["a", "b"] `,
+	} {
+		if got := stripComment(line); got != want {
+			t.Errorf("stripComment(%q) = %q, ожидалось %q", line, got, want)
+		}
+	}
+}
+
+func TestParseTOMLValue(t *testing.T) {
+	for _, tc := range []struct {
+		raw, want string
+		ok        bool
+	}{
+		{`8080`, "8080", true},
+		{`true`, "true", true},
+		{`"data/links.json"`, "data/links.json", true},
+		{`"с \"кавычками\""`, `с "кавычками"`, true},
+		{`"a # b"`, "a # b", true},
+		{`["a.com", "b.com"]`, "a.com,b.com", true},
+		{`[ "a.com" , ]`, "a.com", true},
+		{`[]`, "", true},
+		{``, "", false},
+		{`"незакрытая`, "", false},
+		{`["a.com"`, "", false},
+		{`["a.com", "b]`, "", false},
+	} {
+		got, err := parseTOMLValue(tc.raw)
+		if (err == nil) != tc.ok {
+			t.Errorf("parseTOMLValue(%q): ошибка %v, ожидался успех %v", tc.raw, err, tc.ok)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("parseTOMLValue(%q) = %q, ожидалось %q", tc.raw, got, tc.want)
+		}
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	write := func(text string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`# пример
+[server]
+listen = ":9090" # комментарий после значения
+base_url = "https://sho.rt"
+
+[codes]
+length = 8
+
+[features]
+dashboard = false
+`)
+	cfg := defaultConfig()
+	if err := cfg.loadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.ListenAddr != ":9090" || cfg.Server.BaseURL != "https://sho.rt" || cfg.Codes.Length != 8 || cfg.Features.Dashboard {
+		t.Errorf("разобрано %+v, %+v, %+v", cfg.Server, cfg.Codes, cfg.Features)
+	}
+
+	for _, bad := range []string{
+		"[server\nlisten = \":1\"",
+		"[server]\nlisten",
+		"[server]\nunknown = 1",
+		"[codes]\nlength = \"десять\"",
+		"[server]\nbase_url = \"незакрытая",
+		"[codes]\nalphabet = [\"a\"",
+		"[features]\ndashboard = \"да\"",
+	} {
+		write(bad)
+		cfg := defaultConfig()
+		if err := cfg.loadFile(path); err == nil {
+			t.Errorf("loadFile(%q): ожидалась ошибка", bad)
+		}
+	}
+}
+
+// Булевы флаги работают и без значения
+func TestLoadConfigBoolFlags(t *testing.T) {
+	cfg, err := loadConfig([]string{"-dashboard=false", "-top=false", "-dashboard", "-listen", ":9191"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Features.Dashboard || cfg.Features.Top || cfg.Server.ListenAddr != ":9191" {
+		t.Errorf("разобрано %+v, %+v", cfg.Server, cfg.Features)
+	}
+
+	if _, err := loadConfig([]string{"-code-length"}); err == nil {
+		t.Error("-code-length без значения: ожидалась ошибка")
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"time"
+)
+
+// Счётчик переходов. Увеличивается атомарно, поэтому редиректу
+// достаточно блокировки на чтение для поиска ссылки.
+// В JSON сохраняется как обычное число.
+type Counter struct {
+	n atomic.Int64
+}
+
+func (c *Counter) Inc() int64 {
+	return c.n.Add(1)
+}
+
+func (c *Counter) Load() int {
+	return int(c.n.Load())
+}
+
+func (c *Counter) Store(v int) {
+	c.n.Store(int64(v))
+}
+
+func (c *Counter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.n.Load())
+}
+
+func (c *Counter) UnmarshalJSON(data []byte) error {
+	var v int64
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	c.n.Store(v)
+	return nil
+}
+
+// Время последнего события, обновляется атомарно без блокировки ссылки.
+// В JSON - строка RFC 3339 или null, если события не было.
+type Timestamp struct {
+	n atomic.Int64 // UnixNano, 0 - не было
+}
+
+func (t *Timestamp) Store(v time.Time) {
+	t.n.Store(v.UnixNano())
+}
+
+func (t *Timestamp) Load() time.Time {
+	n := t.n.Load()
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+func (t *Timestamp) MarshalJSON() ([]byte, error) {
+	if t.n.Load() == 0 {
+		return []byte("null"), nil
+	}
+	return json.Marshal(t.Load())
+}
+
+func (t *Timestamp) UnmarshalJSON(data []byte) error {
+	var v *time.Time
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v == nil || v.IsZero() {
+		t.n.Store(0)
+	} else {
+		t.Store(*v)
+	}
+	return nil
+}
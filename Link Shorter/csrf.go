@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+)
+
+// Защита форм владельца от подделки запросов с чужих сайтов.
+// Входа у владельца нет, поэтому токен хранится в cookie браузера
+// и повторяется в скрытом поле формы: чужая страница может отправить
+// форму, но не знает значения cookie. У администраторов токен свой,
+// в сессии (requireAdmin).
+const csrfCookie = "csrf"
+
+type csrfKey struct{}
+
+// Токен текущего запроса; cookie ставится, только когда токен нужен странице
+type csrfState struct {
+	w     http.ResponseWriter
+	token string
+}
+
+// Middleware: запоминает токен из cookie для csrfToken
+func withCSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st := &csrfState{w: w}
+		if c, err := r.Cookie(csrfCookie); err == nil && isCSRFToken(c.Value) {
+			st.token = c.Value
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), csrfKey{}, st)))
+	})
+}
+
+// Токен для скрытого поля "csrf" в формах страницы
+func csrfToken(r *http.Request) string {
+	st, ok := r.Context().Value(csrfKey{}).(*csrfState)
+	if !ok {
+		return ""
+	}
+	if st.token == "" {
+		st.token = randomToken()
+		http.SetCookie(st.w, &http.Cookie{
+			Name:     csrfCookie,
+			Value:    st.token,
+			Path:     "/",
+			MaxAge:   365 * 24 * 3600,
+			HttpOnly: true,
+			Secure:   requestScheme(r) == "https",
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	return st.token
+}
+
+func randomToken() string {
+	var b [32]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func isCSRFToken(s string) bool {
+	_, err := hex.DecodeString(s)
+	return len(s) == 64 && err == nil
+}
+
+// Токен из формы совпадает с cookie
+func validCSRF(r *http.Request) bool {
+	c, err := r.Cookie(csrfCookie)
+	if err != nil || !isCSRFToken(c.Value) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(r.FormValue("csrf")), []byte(c.Value)) == 1
+}
+
+// Обертка для форм владельца: POST без верного токена отклоняется
+func requireCSRF(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && !validCSRF(r) {
+			http.Error(w, localeFrom(r).T("error.csrf"), http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Обертка для всех действий владельца со ссылками: заблокированный IP
+// получает 403 на любом маршруте, затем проверяется токен формы
+func requireOwner(next http.HandlerFunc) http.HandlerFunc {
+	checked := requireCSRF(next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if isBanned(getIP(r)) {
+			http.Error(w, localeFrom(r).T("error.banned"), http.StatusForbidden)
+			return
+		}
+		checked(w, r)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCSRFToken(t *testing.T) {
+	var token string
+	handler := withCSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = csrfToken(r)
+		if csrfToken(r) != token {
+			t.Error("токен изменился в пределах запроса")
+		}
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/my", nil))
+	cookies := w.Result().Cookies()
+	if !isCSRFToken(token) || len(cookies) != 1 || cookies[0].Value != token || !cookies[0].HttpOnly {
+		t.Fatalf("новый токен %q, cookie %+v", token, cookies)
+	}
+
+	// Токен из cookie используется повторно, cookie не переустанавливается
+	r := httptest.NewRequest(http.MethodGet, "/my", nil)
+	r.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	first := token
+	handler.ServeHTTP(w, r)
+	if token != first || len(w.Result().Cookies()) != 0 {
+		t.Errorf("токен %q вместо %q", token, first)
+	}
+}
+
+func TestRequireCSRF(t *testing.T) {
+	token := randomToken()
+	handler := requireCSRF(func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, tc := range []struct {
+		name, method, cookie, field string
+		code                        int
+	}{
+		{"GET без токена", http.MethodGet, "", "", http.StatusOK},
+		{"POST с токеном", http.MethodPost, token, token, http.StatusOK},
+		{"POST без cookie", http.MethodPost, "", token, http.StatusForbidden},
+		{"POST без поля", http.MethodPost, token, "", http.StatusForbidden},
+		{"POST с чужим токеном", http.MethodPost, token, randomToken(), http.StatusForbidden},
+		{"POST с пустыми значениями", http.MethodPost, "", "", http.StatusForbidden},
+	} {
+		form := url.Values{"csrf": {tc.field}}
+		r := httptest.NewRequest(tc.method, "/delete/abc", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if tc.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: csrfCookie, Value: tc.cookie})
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%s: код %d, ожидался %d", tc.name, w.Code, tc.code)
+		}
+	}
+}
+
+// Блокировка IP действует до проверки токена и на любой метод
+func TestRequireOwnerBanned(t *testing.T) {
+	resetState()
+	bans["203.0.113.7"] = &ban{IP: "203.0.113.7"}
+	token := randomToken()
+	handler := requireOwner(func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, tc := range []struct {
+		method, ip string
+		code       int
+	}{
+		{http.MethodGet, "203.0.113.7", http.StatusForbidden},
+		{http.MethodPost, "203.0.113.7", http.StatusForbidden},
+		{http.MethodGet, "198.51.100.1", http.StatusOK},
+		{http.MethodPost, "198.51.100.1", http.StatusOK},
+	} {
+		form := url.Values{"csrf": {token}}
+		r := httptest.NewRequest(tc.method, "/delete/abc", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.AddCookie(&http.Cookie{Name: csrfCookie, Value: token})
+		r.RemoteAddr = tc.ip + ":5000"
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%s с %s: код %d, ожидался %d", tc.method, tc.ip, w.Code, tc.code)
+		}
+	}
+}
+
+// API принимает тело только в JSON: форма с чужого сайта не создаст ссылку
+func TestAPIRequiresJSON(t *testing.T) {
+	resetState()
+	config.Storage.Backend = "memory"
+	for contentType, code := range map[string]int{
+		"application/json":                  http.StatusCreated,
+		"application/json; charset=utf-8":   http.StatusCreated,
+		"":                                  http.StatusUnsupportedMediaType,
+		"text/plain":                        http.StatusUnsupportedMediaType,
+		"application/x-www-form-urlencoded": http.StatusUnsupportedMediaType,
+		"multipart/form-data; boundary=x":   http.StatusUnsupportedMediaType,
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/api/links", strings.NewReader(`{"url": "https://example.com"}`))
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		apiLinksHandler(w, r)
+		if w.Code != code {
+			t.Errorf("Content-Type %q: код %d, ожидался %d", contentType, w.Code, code)
+		}
+	}
+}
+
+// Изменение ссылки проходит те же проверки, что и остальные действия владельца
+func TestEditRequiresOwner(t *testing.T) {
+	code := seedLinks(t, 1)[0]
+	config.Storage.Backend = "memory"
+	link := links[linkKey{"", code}]
+	link.IP = "203.0.113.7"
+	token := randomToken()
+	handler := requireOwner(editHandler)
+
+	edit := func(title, csrf string) int {
+		form := url.Values{"title": {title}, "csrf": {csrf}}
+		r := httptest.NewRequest(http.MethodPost, editURL("", code), strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.AddCookie(&http.Cookie{Name: csrfCookie, Value: token})
+		r.RemoteAddr = link.IP + ":5000"
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	if code := edit("без токена", ""); code != http.StatusForbidden || link.Title != "" {
+		t.Errorf("без токена: код %d, название %q", code, link.Title)
+	}
+	if code := edit("с токеном", token); code != http.StatusFound || link.Title != "с токеном" {
+		t.Errorf("с токеном: код %d, название %q", code, link.Title)
+	}
+	bans[link.IP] = &ban{IP: link.IP}
+	if code := edit("после блокировки", token); code != http.StatusForbidden || link.Title != "с токеном" {
+		t.Errorf("заблокированный владелец: код %d, название %q", code, link.Title)
+	}
+}
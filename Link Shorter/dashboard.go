@@ -0,0 +1,223 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ссылок на одной странице кабинета
+const dashboardPageSize = 25
+
+// Порядок ссылок в кабинете; первый - по умолчанию
+var dashboardSorts = []string{"visits", "created", "last_visit", "alpha"}
+
+// Вид кабинета: фильтры, поиск, порядок и страница
+type linkFilter struct {
+	Tag    string
+	Folder string
+	Query  string // поиск по коду и адресу
+	Sort   string
+	Page   int // с 1
+}
+
+func parseLinkFilter(q url.Values) linkFilter {
+	f := linkFilter{
+		Tag:    normalizeTag(q.Get("tag")),
+		Folder: normalizeFolder(q.Get("folder")),
+		Query:  strings.TrimSpace(q.Get("q")),
+		Sort:   dashboardSorts[0],
+		Page:   1,
+	}
+	for _, s := range dashboardSorts {
+		if q.Get("sort") == s {
+			f.Sort = s
+		}
+	}
+	if page, err := strconv.Atoi(q.Get("page")); err == nil && page > 1 {
+		f.Page = page
+	}
+	return f
+}
+
+// Подходит ли ссылка под фильтр по тегу и папке
+func (f linkFilter) match(link *Link) bool {
+	if f.Tag != "" && !hasTag(link.Tags, f.Tag) {
+		return false
+	}
+	if f.Folder != "" && link.Folder != f.Folder {
+		return false
+	}
+	return true
+}
+
+func (f linkFilter) filtered() bool {
+	return f.Tag != "" || f.Folder != "" || f.Query != ""
+}
+
+func (f linkFilter) values() url.Values {
+	q := url.Values{}
+	if f.Tag != "" {
+		q.Set("tag", f.Tag)
+	}
+	if f.Folder != "" {
+		q.Set("folder", f.Folder)
+	}
+	if f.Query != "" {
+		q.Set("q", f.Query)
+	}
+	if f.Sort != dashboardSorts[0] {
+		q.Set("sort", f.Sort)
+	}
+	if f.Page > 1 {
+		q.Set("page", strconv.Itoa(f.Page))
+	}
+	return q
+}
+
+// Адрес кабинета с этим видом
+func (f linkFilter) url() string {
+	if q := f.values(); len(q) > 0 {
+		return "/my?" + q.Encode()
+	}
+	return "/my"
+}
+
+func (f linkFilter) withPage(page int) string {
+	f.Page = page
+	return f.url()
+}
+
+// Сортировка ссылок кабинета; при равенстве новые выше
+func sortLinkStats(list []LinkStats, by string) {
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		switch by {
+		case "visits":
+			if a.Visits != b.Visits {
+				return a.Visits > b.Visits
+			}
+		case "last_visit":
+			if !a.LastVisit.Equal(b.LastVisit) {
+				return a.LastVisit.After(b.LastVisit)
+			}
+		case "alpha":
+			if x, y := sortName(a), sortName(b); x != y {
+				return x < y
+			}
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.ShortCode < b.ShortCode
+	})
+}
+
+// Имя ссылки для сортировки по алфавиту: название, а без него адрес
+func sortName(s LinkStats) string {
+	if s.Title != "" {
+		return strings.ToLower(s.Title)
+	}
+	name := strings.TrimPrefix(strings.TrimPrefix(s.OriginalURL, "https://"), "http://")
+	return strings.ToLower(strings.TrimPrefix(name, "www."))
+}
+
+// Кабинет владельца: фильтр по тегу и папке, поиск, сортировка и страницы
+func dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	ip := getIP(r)
+	filter := parseLinkFilter(r.URL.Query())
+
+	now := time.Now()
+	mutex.RLock()
+	userCodes := ipLinks[ip]
+	tags, folders := collectTags(userCodes)
+	search := newSearchQuery(filter.Query)
+
+	userLinks := make([]LinkStats, 0, len(userCodes))
+	for _, key := range userCodes {
+		link, exists := links[key]
+		if !exists || !filter.match(link) || !search.match(key) {
+			continue
+		}
+		userLinks = append(userLinks, LinkStats{
+			ShortCode:   key.Code,
+			OriginalURL: link.OriginalURL,
+			Visits:      link.Visits.Load(),
+			CreatedAt:   link.CreatedAt,
+			Domain:      key.Domain,
+			Status:      link.state(now),
+			ExpiresAt:   link.ExpiresAt,
+			Tags:        append([]string(nil), link.Tags...),
+			Folder:      link.Folder,
+			LastVisit:   link.LastVisit.Load(),
+			Title:       link.Title,
+			Description: link.Description,
+			Favicon:     link.Favicon,
+
+			OGTitle:       link.OGTitle,
+			OGDescription: link.OGDescription,
+			OGImage:       link.OGImage,
+			ForwardQuery:  link.ForwardQuery,
+			Rules:         link.Rules,
+			Variants:      formatVariants(link.Variants),
+			Fallback:      link.Fallback,
+			Alerts:        formatAlerts(link.Alerts),
+		})
+	}
+	mutex.RUnlock()
+
+	sortLinkStats(userLinks, filter.Sort)
+
+	// Страница за пределами списка - последняя
+	pages := (len(userLinks) + dashboardPageSize - 1) / dashboardPageSize
+	if filter.Page > pages && pages > 0 {
+		filter.Page = pages
+	}
+	start := (filter.Page - 1) * dashboardPageSize
+	end := min(start+dashboardPageSize, len(userLinks))
+
+	data := myPage{
+		page:     newPage(r, "my"),
+		IP:       ip,
+		Total:    len(userCodes),
+		Found:    len(userLinks),
+		Filter:   filter,
+		Filtered: filter.filtered(),
+		Sorts:    dashboardSorts,
+		Tags:     tags,
+		Folders:  folders,
+		Back:     filter.values().Encode(),
+	}
+	if config.Features.Notifications {
+		data.Notifications = ownerFeed(r, ip, alertFeedOnMyPage)
+	}
+	if pages > 1 {
+		data.Pagination = &pagination{Page: filter.Page, Pages: pages}
+		if filter.Page > 1 {
+			data.Pagination.PrevURL = filter.withPage(filter.Page - 1)
+		}
+		if filter.Page < pages {
+			data.Pagination.NextURL = filter.withPage(filter.Page + 1)
+		}
+	}
+	for _, linkStat := range userLinks[start:end] {
+		card := newLinkCard(r, linkStat, 0)
+		card.Key = formatLinkKey(linkKey{linkStat.Domain, linkStat.ShortCode})
+		card.EditURL = editURL(linkStat.Domain, linkStat.ShortCode)
+		card.OGTitle, card.OGDescription, card.OGImage = linkStat.OGTitle, linkStat.OGDescription, linkStat.OGImage
+		card.ForwardQuery = linkStat.ForwardQuery
+		card.Rules = formatRules(linkStat.Rules)
+		card.Variants = linkStat.Variants
+		card.Alerts, card.AlertsOn = linkStat.Alerts, config.Features.Notifications
+		card.StatsURL = linkStatsURL(linkStat.Domain, linkStat.ShortCode)
+		card.DeleteURL = "/delete/" + linkStat.ShortCode + "?domain=" + url.QueryEscape(linkStat.Domain)
+		if linkStat.Status == statusActive || linkStat.Status == statusPaused {
+			card.PauseURL = pauseURL(linkStat.Domain, linkStat.ShortCode)
+		}
+		data.Links = append(data.Links, card)
+	}
+	renderPage(w, r, "my", data)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Диплинки: ссылки на приложения по собственным схемам (myapp://product/42).
+// Схемы разрешает администратор в deeplinks.schemes. Перенаправлять на такой
+// адрес напрямую нельзя: без приложения браузер покажет ошибку. Поэтому
+// посетитель получает промежуточную страницу, которая пытается открыть
+// приложение и через deeplinks.fallback_delay уходит на веб-адрес.
+
+// Схемы, которые нельзя разрешить: они исполняют код, открывают локальные
+// данные или и так обрабатываются как обычные ссылки
+var forbiddenSchemes = []string{"http", "https", "javascript", "vbscript", "data", "file", "blob", "about", "filesystem"}
+
+// Имя схемы по RFC 3986: буква, затем буквы, цифры, +, - и .
+func isSchemeName(s string) bool {
+	for i, ch := range s {
+		switch {
+		case ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z':
+		case i > 0 && (ch >= '0' && ch <= '9' || ch == '+' || ch == '-' || ch == '.'):
+		default:
+			return false
+		}
+	}
+	return s != ""
+}
+
+// Адрес по схеме из deeplinks.schemes
+func isDeepLink(rawURL string) bool {
+	scheme, _, ok := strings.Cut(rawURL, ":")
+	if !ok || !isSchemeName(scheme) {
+		return false
+	}
+	for _, allowed := range config.DeepLinks.Schemes {
+		if strings.EqualFold(scheme, allowed) {
+			_, err := url.Parse(rawURL)
+			return err == nil
+		}
+	}
+	return false
+}
+
+// Обычный веб-адрес http(s)
+func isWebURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// Веб-адрес, на который уходит посетитель без приложения. Пустой - можно
+// только попробовать открыть приложение.
+func normalizeFallback(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", nil
+	}
+	u, err := url.Parse(normalizeURL(raw))
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return "", inputErr("input.bad_target", raw)
+	}
+	return u.String(), nil
+}
+
+// Адрес страницы в вебе: для диплинка - запасной адрес, иначе сам адрес
+// назначения (вызывается под mutex.RLock)
+func (link *Link) webURL() string {
+	if isDeepLink(link.OriginalURL) {
+		return link.Fallback
+	}
+	return link.OriginalURL
+}
+
+// Промежуточная страница диплинка
+func renderDeepLink(w http.ResponseWriter, r *http.Request, app, fallback string) {
+	w.Header().Set("Cache-Control", "no-store")
+	renderPage(w, r, "deeplink", deepLinkPage{
+		page: newPage(r, "deeplink"),
+		// Схема проверена по списку администратора, шаблону можно не
+		// заменять ее на #ZgotmplZ
+		App:      template.URL(app),
+		Fallback: fallback,
+		Delay:    config.DeepLinks.FallbackDelay.Milliseconds(),
+	})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeURLDeepLinks(t *testing.T) {
+	config = defaultConfig()
+	config.DeepLinks.Schemes = []string{"myapp", "tg"}
+
+	for raw, want := range map[string]string{
+		"myapp://product/42":   "myapp://product/42",
+		"MyApp://product/42":   "MyApp://product/42",
+		"tg:resolve?domain=x":  "tg:resolve?domain=x",
+		"otherapp://product":   "https://otherapp://product",
+		"example.com/myapp://": "https://example.com/myapp://",
+		"javascript:alert(1)":  "https://javascript:alert(1)",
+		" https://example.com": "https://example.com",
+	} {
+		if got := normalizeURL(raw); got != want {
+			t.Errorf("normalizeURL(%q) = %q, ожидалось %q", raw, got, want)
+		}
+	}
+}
+
+func TestRedirectDeepLinkPage(t *testing.T) {
+	seedLinks(t, 1)
+	config.DeepLinks.Schemes = []string{"myapp"}
+	link := links[linkKey{"", "c00000"}]
+	link.OriginalURL, link.Fallback = "myapp://product/42", "https://example.com/p/42"
+	if err := loadLocales(); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	redirectShortLink(w, httptest.NewRequest(http.MethodGet, "/c00000", nil))
+	body := w.Body.String()
+	if w.Code != http.StatusOK || w.Header().Get("Location") != "" {
+		t.Fatalf("ожидалась промежуточная страница, получен код %d", w.Code)
+	}
+	for _, want := range []string{`href="myapp://product/42"`, `"https://example.com/p/42"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("на странице нет %s", want)
+		}
+	}
+	if link.Visits.Load() != 1 {
+		t.Errorf("переход не засчитан")
+	}
+}
+
+func TestNormalizeFallback(t *testing.T) {
+	for raw, want := range map[string]string{
+		"":                       "",
+		" example.com/p ":        "https://example.com/p",
+		"http://example.com/p?x": "http://example.com/p?x",
+	} {
+		if got, err := normalizeFallback(raw); err != nil || got != want {
+			t.Errorf("normalizeFallback(%q) = %q, %v; ожидалось %q", raw, got, err, want)
+		}
+	}
+	if _, err := normalizeFallback("https://"); err == nil {
+		t.Error("адрес без хоста принят")
+	}
+}
+
+// Схему убрали из deeplinks.schemes после создания ссылки: посетитель
+// уходит на запасной адрес, а без него видит страницу состояния
+func TestRedirectDeepLinkSchemeRevoked(t *testing.T) {
+	seedLinks(t, 2)
+	config.DeepLinks.Schemes = nil
+	if err := loadLocales(); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	withFallback, bare := links[linkKey{"", "c00000"}], links[linkKey{"", "c00001"}]
+	withFallback.OriginalURL, withFallback.Fallback = "myapp://product/42", "https://example.com/p/42"
+	bare.OriginalURL = "myapp://product/43"
+
+	w := httptest.NewRecorder()
+	redirectShortLink(w, httptest.NewRequest(http.MethodGet, "/c00000", nil))
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://example.com/p/42" {
+		t.Errorf("с запасным адресом: код %d, Location %q", w.Code, w.Header().Get("Location"))
+	}
+
+	w = httptest.NewRecorder()
+	redirectShortLink(w, httptest.NewRequest(http.MethodGet, "/c00001", nil))
+	if w.Code != http.StatusGone || w.Header().Get("Location") != "" || strings.Contains(w.Body.String(), "myapp:") {
+		t.Errorf("без запасного адреса: код %d, Location %q", w.Code, w.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Ключ ссылки: у каждого короткого домена свое пространство кодов
+type linkKey struct {
+	Domain string
+	Code   string
+}
+
+// Короткий домен из конфигурации
+type shortDomain struct {
+	Host    string // ключ пространства имен, например x.co
+	BaseURL string // адрес для коротких ссылок, например https://x.co
+}
+
+// Список коротких доменов. Пустой список - один общий набор кодов.
+func shortDomains() []shortDomain {
+	var domains []shortDomain
+	for _, raw := range config.Server.Domains {
+		u, err := url.Parse(raw)
+		if err != nil {
+			continue
+		}
+		domains = append(domains, shortDomain{
+			Host:    strings.ToLower(u.Host),
+			BaseURL: strings.TrimSuffix(raw, "/"),
+		})
+	}
+	return domains
+}
+
+// Поиск домена по заголовку Host (с портом или без)
+func findDomain(host string) (shortDomain, bool) {
+	host = strings.ToLower(host)
+	name := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		name = h
+	}
+	for _, d := range shortDomains() {
+		if d.Host == host || d.Host == name {
+			return d, true
+		}
+	}
+	return shortDomain{}, false
+}
+
+// Домен по умолчанию - первый в списке
+func defaultDomain() string {
+	if domains := shortDomains(); len(domains) > 0 {
+		return domains[0].Host
+	}
+	return ""
+}
+
+// Пространство имен для запроса: домен из Host или домен по умолчанию
+func requestDomain(r *http.Request) string {
+	if d, ok := findDomain(r.Host); ok {
+		return d.Host
+	}
+	return defaultDomain()
+}
+
+// Проверка, что домен есть в конфигурации
+func isKnownDomain(domain string) bool {
+	for _, d := range shortDomains() {
+		if d.Host == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// Полный адрес короткой ссылки с учетом ее домена
+func shortLinkURL(r *http.Request, domain, code string) string {
+	for _, d := range shortDomains() {
+		if d.Host == domain {
+			return d.BaseURL + "/" + code
+		}
+	}
+	return getCurrentDomain(r) + "/" + code
+}
+
+// Домены для выбора в формах; пусто, если выбирать не из чего
+func domainChoices() []string {
+	domains := shortDomains()
+	if len(domains) < 2 {
+		return nil
+	}
+	hosts := make([]string, len(domains))
+	for i, d := range domains {
+		hosts[i] = d.Host
+	}
+	return hosts
+}
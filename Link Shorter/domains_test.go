@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestShortDomains(t *testing.T) {
+	config = defaultConfig()
+	if defaultDomain() != "" || domainChoices() != nil {
+		t.Error("без доменов должно быть одно общее пространство кодов")
+	}
+
+	config.Server.Domains = []string{"https://X.co/", "http://go.example:8080"}
+	want := []shortDomain{{"x.co", "https://X.co"}, {"go.example:8080", "http://go.example:8080"}}
+	if got := shortDomains(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("shortDomains = %+v, ожидалось %+v", got, want)
+	}
+	if defaultDomain() != "x.co" {
+		t.Errorf("defaultDomain = %q", defaultDomain())
+	}
+	if got := domainChoices(); !reflect.DeepEqual(got, []string{"x.co", "go.example:8080"}) {
+		t.Errorf("domainChoices = %q", got)
+	}
+	if !isKnownDomain("x.co") || isKnownDomain("") || isKnownDomain("evil.example") {
+		t.Error("isKnownDomain принимает неизвестный домен")
+	}
+
+	for host, want := range map[string]string{
+		"x.co":            "x.co",
+		"X.CO:443":        "x.co",
+		"go.example:8080": "go.example:8080",
+		"go.example":      "x.co", // без порта не совпадает, берется домен по умолчанию
+		"localhost:8080":  "x.co",
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Host = host
+		if got := requestDomain(r); got != want {
+			t.Errorf("requestDomain(%q) = %q, ожидалось %q", host, got, want)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Host = "go.example:8080"
+	if got := shortLinkURL(r, "x.co", "abc"); got != "https://X.co/abc" {
+		t.Errorf("shortLinkURL = %q", got)
+	}
+	if got := shortLinkURL(r, "", "abc"); got != "http://go.example:8080/abc" {
+		t.Errorf("shortLinkURL без домена = %q", got)
+	}
+}
+
+// Один и тот же код на разных доменах - разные ссылки
+func TestDomainNamespaces(t *testing.T) {
+	seedLinks(t, 0)
+	config.Storage.Backend = "memory"
+	config.Server.Domains = []string{"https://x.co", "https://y.co"}
+	links[linkKey{"x.co", "same"}] = &Link{OriginalURL: "https://example.com/x", ShortCode: "same"}
+	links[linkKey{"y.co", "same"}] = &Link{OriginalURL: "https://example.com/y", ShortCode: "same"}
+
+	for host, want := range map[string]string{
+		"x.co":      "https://example.com/x",
+		"y.co":      "https://example.com/y",
+		"localhost": "https://example.com/x",
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/same", nil)
+		r.Host = host
+		w := httptest.NewRecorder()
+		if !redirectShortLink(w, r) {
+			t.Errorf("%s/same: ссылка не найдена", host)
+			continue
+		}
+		if got := w.Header().Get("Location"); got != want {
+			t.Errorf("%s/same -> %q, ожидалось %q", host, got, want)
+		}
+	}
+
+	key := createLink(&Link{OriginalURL: "https://example.com/new", Domain: "y.co"})
+	if key.Domain != "y.co" || links[key] == nil || links[linkKey{"x.co", key.Code}] != nil {
+		t.Errorf("ссылка создана не в своем пространстве: %+v", key)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// Внутренняя шина событий ссылок. Обработчики запроса только кладут событие
+// в очередь и не ждут подписчиков (вебхуков и т.п.), которые разбирают
+// очередь в отдельной горутине.
+
+const (
+	eventLinkCreated = "link.created"
+	eventLinkDeleted = "link.deleted"
+	eventLinkExpired = "link.expired"
+	eventLinkVisits  = "link.visits" // число переходов достигло порога
+	eventLinkAlert   = "link.alert"  // сработало правило уведомления
+)
+
+// Все события в порядке показа в интерфейсе
+var linkEventTypes = []string{eventLinkCreated, eventLinkDeleted, eventLinkExpired, eventLinkVisits, eventLinkAlert}
+
+type linkEvent struct {
+	Type   string
+	Key    linkKey
+	Owner  string // IP владельца ссылки
+	URL    string // адрес назначения
+	Visits int
+	Time   time.Time
+	Alert  *notification // для link.alert
+}
+
+var (
+	eventQueue    = make(chan linkEvent, 1024)
+	eventHandlers []func(linkEvent) // регистрируются до запуска runEventBus
+
+	// Пороги переходов, о которых кто-то хочет знать. Проверяется на каждом
+	// переходе, поэтому хранится готовым множеством и меняется целиком.
+	visitThresholds atomic.Pointer[map[int]bool]
+)
+
+// Подписка на события; вызывать до запуска шины
+func onEvent(handler func(linkEvent)) {
+	eventHandlers = append(eventHandlers, handler)
+}
+
+// Отправка события без ожидания. Если подписчики не успевают, событие
+// теряется и попадает в счетчик потерь: замедлять перенаправления ради них
+// нельзя. Уже стоящие в очереди события при остановке раздаются подписчикам.
+func publishEvent(e linkEvent) {
+	if len(eventHandlers) == 0 {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	select {
+	case eventQueue <- e:
+	default:
+		eventsDropped.Inc()
+		logEvent(slog.LevelWarn, "event_dropped", "event", e.Type, "short_code", e.Key.Code, "domain", e.Key.Domain)
+	}
+}
+
+// Событие ссылки (вызывается под mutex)
+func linkEventOf(eventType string, key linkKey, link *Link) linkEvent {
+	return linkEvent{
+		Type:   eventType,
+		Key:    key,
+		Owner:  link.IP,
+		URL:    link.OriginalURL,
+		Visits: link.Visits.Load(),
+	}
+}
+
+// Событие о пороге переходов, если visits - один из отслеживаемых порогов
+func checkVisitThreshold(key linkKey, owner, target string, visits int) {
+	if thresholds := visitThresholds.Load(); thresholds != nil && (*thresholds)[visits] {
+		publishEvent(linkEvent{Type: eventLinkVisits, Key: key, Owner: owner, URL: target, Visits: visits})
+	}
+}
+
+func setVisitThresholds(list []int) {
+	set := make(map[int]bool, len(list))
+	for _, n := range list {
+		set[n] = true
+	}
+	visitThresholds.Store(&set)
+}
+
+// Истекшие ссылки: срок проверяется при переходе, поэтому событие об
+// истечении ищется периодически. since - время прошлой проверки.
+func publishExpired(since, now time.Time) {
+	mutex.RLock()
+	var events []linkEvent
+	for key, link := range links {
+		if link.ExpiresAt != nil && link.ExpiresAt.After(since) && !link.ExpiresAt.After(now) {
+			e := linkEventOf(eventLinkExpired, key, link)
+			e.Time = *link.ExpiresAt
+			events = append(events, e)
+		}
+	}
+	mutex.RUnlock()
+	for _, e := range events {
+		publishEvent(e)
+	}
+}
+
+// Разбор очереди событий до остановки шины. Заодно раз в полминуты
+// ищутся истекшие ссылки; истекшие, пока сервер был остановлен, события
+// не получают. Шина останавливается после запросов и фоновых задач,
+// а события, оставшиеся в очереди, перед выходом раздаются подписчикам.
+func runEventBus(ctx context.Context) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	swept := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			drainEvents()
+			return
+		case now := <-ticker.C:
+			publishExpired(swept, now)
+			swept = now
+		case e := <-eventQueue:
+			dispatchEvent(e)
+		}
+	}
+}
+
+func dispatchEvent(e linkEvent) {
+	for _, handler := range eventHandlers {
+		handler(e)
+	}
+}
+
+// Раздача событий, уже стоящих в очереди
+func drainEvents() {
+	for {
+		select {
+		case e := <-eventQueue:
+			dispatchEvent(e)
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Подписчик для теста; прежние восстанавливаются по окончании
+func captureEvents(t *testing.T) *[]linkEvent {
+	t.Helper()
+	var got []linkEvent
+	saved := eventHandlers
+	eventHandlers = []func(linkEvent){func(e linkEvent) { got = append(got, e) }}
+	t.Cleanup(func() { eventHandlers = saved })
+	return &got
+}
+
+// При полной очереди событие теряется сразу: отправитель не ждет подписчиков
+func TestPublishEventDoesNotBlock(t *testing.T) {
+	resetState()
+	captureEvents(t)
+	for len(eventQueue) < cap(eventQueue) {
+		eventQueue <- linkEvent{Type: eventLinkCreated}
+	}
+
+	dropped := eventsDropped.Load()
+	done := make(chan struct{})
+	go func() {
+		publishEvent(linkEvent{Type: eventLinkDeleted})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("отправитель ждет места в очереди")
+	}
+	if eventsDropped.Load() != dropped+1 {
+		t.Errorf("потерь %d, ожидалась 1", eventsDropped.Load()-dropped)
+	}
+
+	// Место освободилось: событие снова попадает в очередь
+	<-eventQueue
+	publishEvent(linkEvent{Type: eventLinkDeleted})
+	if eventsDropped.Load() != dropped+1 || len(eventQueue) != cap(eventQueue) {
+		t.Errorf("событие потеряно: потерь %d, в очереди %d", eventsDropped.Load()-dropped, len(eventQueue))
+	}
+	resetState()
+}
+
+// Остановленная шина раздает события, оставшиеся в очереди
+func TestEventBusDrainsOnStop(t *testing.T) {
+	resetState()
+	got := captureEvents(t)
+	for i := 0; i < 5; i++ {
+		publishEvent(linkEvent{Type: eventLinkCreated, Visits: i})
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	runEventBus(ctx)
+	if len(*got) != 5 || len(eventQueue) != 0 {
+		t.Errorf("разобрано %d событий, в очереди осталось %d", len(*got), len(eventQueue))
+	}
+}
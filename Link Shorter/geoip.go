@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Локальная база стран по IP для правил перенаправления. Формат - CSV,
+// по строке на диапазон:
+//
+//	1.0.0.0,1.0.0.255,AU       (начало,конец,страна - как в DB-IP Lite)
+//	2a02:6b8::/32,RU           (сеть,страна)
+//
+// Пустые строки и строки с # пропускаются.
+
+type geoRange struct {
+	start, end netip.Addr
+	country    string
+}
+
+type geoDB struct {
+	ranges []geoRange // по возрастанию start, без пересечений
+}
+
+// База, загруженная при старте; nil - правила по стране не срабатывают
+var geoIP *geoDB
+
+func loadGeoDB(path string) (*geoDB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	db := &geoDB{}
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r, err := parseGeoLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+		}
+		db.ranges = append(db.ranges, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(db.ranges, func(i, j int) bool { return db.ranges[i].start.Less(db.ranges[j].start) })
+	for i := 1; i < len(db.ranges); i++ {
+		if !db.ranges[i-1].end.Less(db.ranges[i].start) {
+			return nil, fmt.Errorf("%s: диапазоны %s и %s пересекаются", path, db.ranges[i-1].start, db.ranges[i].start)
+		}
+	}
+	return db, nil
+}
+
+func parseGeoLine(line string) (geoRange, error) {
+	fields := strings.Split(line, ",")
+	for i := range fields {
+		fields[i] = strings.Trim(strings.TrimSpace(fields[i]), `"`)
+	}
+	var r geoRange
+	switch len(fields) {
+	case 2:
+		prefix, err := netip.ParsePrefix(fields[0])
+		if err != nil {
+			return r, err
+		}
+		prefix = prefix.Masked()
+		r.start, r.end = prefix.Addr().Unmap(), lastAddr(prefix).Unmap()
+	case 3:
+		start, err := netip.ParseAddr(fields[0])
+		if err != nil {
+			return r, err
+		}
+		end, err := netip.ParseAddr(fields[1])
+		if err != nil {
+			return r, err
+		}
+		r.start, r.end = start.Unmap(), end.Unmap()
+		if r.start.Is4() != r.end.Is4() || r.end.Less(r.start) {
+			return r, fmt.Errorf("некорректный диапазон %s - %s", start, end)
+		}
+	default:
+		return r, fmt.Errorf("ожидается начало,конец,страна или сеть,страна")
+	}
+	r.country = strings.ToUpper(fields[len(fields)-1])
+	if !isCountryCode(r.country) {
+		return r, fmt.Errorf("некорректный код страны %q", r.country)
+	}
+	return r, nil
+}
+
+// Последний адрес сети
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	b := prefix.Addr().As16()
+	bits := prefix.Bits()
+	if prefix.Addr().Is4() {
+		bits += 96
+	}
+	for i := bits; i < 128; i++ {
+		b[i/8] |= 1 << (7 - i%8)
+	}
+	addr := netip.AddrFrom16(b)
+	if prefix.Addr().Is4() {
+		addr = addr.Unmap()
+	}
+	return addr
+}
+
+func isCountryCode(s string) bool {
+	return len(s) == 2 && s[0] >= 'A' && s[0] <= 'Z' && s[1] >= 'A' && s[1] <= 'Z'
+}
+
+// Страна адреса или пустая строка
+func (db *geoDB) country(ip string) string {
+	if db == nil {
+		return ""
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+	// Последний диапазон, начинающийся не позже адреса
+	i := sort.Search(len(db.ranges), func(i int) bool { return addr.Less(db.ranges[i].start) }) - 1
+	if i >= 0 && db.ranges[i].start.Is4() == addr.Is4() && !db.ranges[i].end.Less(addr) {
+		return db.ranges[i].country
+	}
+	return ""
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Состояние для проверок готовности
+var (
+	startedAt = time.Now()
+	dbLoaded  atomic.Bool // loadDatabase завершилась успешно
+
+	saveStateMu sync.Mutex
+	lastSaveErr error     // ошибка последнего сохранения, nil - успешно
+	lastSaveAt  time.Time // время последней попытки
+)
+
+// Результат последнего сохранения базы
+func recordSaveResult(err error) {
+	saveStateMu.Lock()
+	defer saveStateMu.Unlock()
+	lastSaveErr = err
+	lastSaveAt = time.Now()
+}
+
+// Пробы оркестратора не пишутся в журнал запросов, не ограничиваются
+// по частоте и не проверяют Host: их шлют напрямую на адрес пода.
+func isProbePath(path string) bool {
+	return path == "/healthz" || path == "/readyz"
+}
+
+type healthCheck struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type healthResponse struct {
+	Status string        `json:"status"`
+	Uptime string        `json:"uptime"`
+	Checks []healthCheck `json:"checks,omitempty"`
+}
+
+// /healthz: процесс жив и отвечает
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, healthResponse{
+		Status: "ok",
+		Uptime: time.Since(startedAt).Round(time.Second).String(),
+	})
+}
+
+// /readyz: база загружена, последнее сохранение удалось, каталог данных доступен на запись
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	checks := []healthCheck{{Name: "database_loaded", OK: dbLoaded.Load()}}
+	if !checks[0].OK {
+		checks[0].Error = "database is not loaded"
+	}
+
+	if config.Storage.Backend == "json" {
+		saveStateMu.Lock()
+		save := healthCheck{Name: "last_save", OK: lastSaveErr == nil}
+		if lastSaveErr != nil {
+			save.Error = lastSaveErr.Error() + " (at " + lastSaveAt.Format(time.RFC3339) + ")"
+		}
+		saveStateMu.Unlock()
+
+		writable := healthCheck{Name: "data_dir_writable", OK: true}
+		if err := checkWritable(filepath.Dir(config.Storage.Path)); err != nil {
+			writable.OK = false
+			writable.Error = err.Error()
+		}
+		checks = append(checks, save, writable)
+	}
+
+	resp := healthResponse{
+		Status: "ok",
+		Uptime: time.Since(startedAt).Round(time.Second).String(),
+		Checks: checks,
+	}
+	code := http.StatusOK
+	for _, c := range checks {
+		if !c.OK {
+			resp.Status = "unavailable"
+			code = http.StatusServiceUnavailable
+		}
+	}
+	writeHealth(w, code, resp)
+}
+
+func writeHealth(w http.ResponseWriter, code int, resp healthResponse) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Проверка записи: создаем и удаляем временный файл
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func readyz(t *testing.T) (int, map[string]bool) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	readyzHandler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var resp healthResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("ответ /readyz не JSON: %v", err)
+	}
+	checks := make(map[string]bool)
+	for _, c := range resp.Checks {
+		checks[c.Name] = c.OK
+	}
+	return w.Code, checks
+}
+
+func TestReadyzWaitsForDatabase(t *testing.T) {
+	seedLinks(t, 1)
+	config.Storage.Path = filepath.Join(t.TempDir(), "links.json")
+
+	if code, checks := readyz(t); code != http.StatusServiceUnavailable || checks["database_loaded"] {
+		t.Errorf("до загрузки базы: код %d, проверки %v", code, checks)
+	}
+	dbLoaded.Store(true)
+	if code, checks := readyz(t); code != http.StatusOK {
+		t.Errorf("после загрузки базы: код %d, проверки %v", code, checks)
+	}
+}
+
+func TestReadyzAfterFailedSave(t *testing.T) {
+	path := seedSaver(t)
+
+	// Вместо файла базы - каталог: запись не удается, а сам каталог данных доступен
+	config.Storage.Path = t.TempDir()
+	markDirty()
+	if err := flushDatabase(); err == nil {
+		t.Fatal("сохранение в каталог должно завершиться ошибкой")
+	}
+	code, checks := readyz(t)
+	if code != http.StatusServiceUnavailable || checks["last_save"] || !checks["data_dir_writable"] {
+		t.Errorf("после неудачного сохранения: код %d, проверки %v", code, checks)
+	}
+
+	// Следующее удачное сохранение возвращает готовность
+	config.Storage.Path = path
+	if err := flushDatabase(); err != nil {
+		t.Fatal(err)
+	}
+	if code, checks := readyz(t); code != http.StatusOK {
+		t.Errorf("после удачного сохранения: код %d, проверки %v", code, checks)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	resetState()
+	w := httptest.NewRecorder()
+	healthzHandler(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("/healthz: код %d, Content-Type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+}
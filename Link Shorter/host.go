@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Список доменов, с которыми разрешено обращаться к сервису.
+// Пустой список означает, что заголовку Host доверяем (режим разработки).
+func allowedHosts() []string {
+	var hosts []string
+	if config.Server.BaseURL != "" {
+		if u, err := url.Parse(config.Server.BaseURL); err == nil {
+			hosts = append(hosts, strings.ToLower(u.Host))
+		}
+	}
+	for _, d := range shortDomains() {
+		hosts = append(hosts, d.Host)
+	}
+	return append(hosts, config.Server.AllowedHosts...)
+}
+
+// Проверка заголовка Host по списку разрешенных доменов.
+// Совпадение либо точное (с портом), либо по имени хоста без порта.
+func hostAllowed(host string) bool {
+	hosts := allowedHosts()
+	if len(hosts) == 0 {
+		return true
+	}
+
+	host = strings.ToLower(host)
+	name := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		name = h
+	}
+	for _, allowed := range hosts {
+		if allowed == host || allowed == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware: отклоняем запросы с чужим заголовком Host,
+// чтобы /shorten не раздавал ссылки на подставленный домен
+func checkHost(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !hostAllowed(r.Host) && !isProbePath(r.URL.Path) {
+			http.Error(w, localeFrom(r).T("error.unknown_host"), http.StatusMisdirectedRequest)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Схема для ссылок, когда публичный адрес не задан.
+// X-Forwarded-Proto учитываем, только если запрос пришел от доверенного прокси.
+func requestScheme(r *http.Request) string {
+	if config.Server.Scheme != "auto" {
+		return config.Server.Scheme
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	if isTrustedProxy(remote) && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+		return "https"
+	}
+	return "http"
+}
+
+// Адрес из server.trusted_proxies
+func isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range config.Server.TrustedProxies {
+		if _, n, err := net.ParseCIDR(proxy); err == nil {
+			if n.Contains(ip) {
+				return true
+			}
+		} else if other := net.ParseIP(proxy); other != nil && other.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHostAllowed(t *testing.T) {
+	config = defaultConfig()
+	if !hostAllowed("anything.example") {
+		t.Error("без списка доменов Host должен приниматься")
+	}
+
+	config.Server.BaseURL = "https://Sho.rt"
+	config.Server.Domains = []string{"https://x.co"}
+	config.Server.AllowedHosts = []string{"www.sho.rt", "localhost:8080"}
+	for host, want := range map[string]bool{
+		"sho.rt":         true,
+		"SHO.RT":         true,
+		"sho.rt:443":     true,
+		"x.co":           true,
+		"x.co:8443":      true,
+		"www.sho.rt":     true,
+		"localhost:8080": true,
+		"localhost":      false,
+		"localhost:9090": false,
+		"evil.example":   false,
+		"sho.rt.evil":    false,
+		"":               false,
+	} {
+		if got := hostAllowed(host); got != want {
+			t.Errorf("hostAllowed(%q) = %v, ожидалось %v", host, got, want)
+		}
+	}
+}
+
+func TestCheckHost(t *testing.T) {
+	config = defaultConfig()
+	config.Server.AllowedHosts = []string{"sho.rt"}
+	handler := checkHost(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, tc := range []struct {
+		host, path string
+		code       int
+	}{
+		{"sho.rt", "/", http.StatusOK},
+		{"evil.example", "/", http.StatusMisdirectedRequest},
+		{"evil.example", "/healthz", http.StatusOK},
+	} {
+		r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		r.Host = tc.host
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tc.code {
+			t.Errorf("Host %q, %s: код %d, ожидался %d", tc.host, tc.path, w.Code, tc.code)
+		}
+	}
+}
+
+func TestRequestScheme(t *testing.T) {
+	config = defaultConfig()
+	plain := httptest.NewRequest(http.MethodGet, "/", nil)
+	secure := httptest.NewRequest(http.MethodGet, "/", nil)
+	secure.TLS = &tls.ConnectionState{}
+	config.Server.TrustedProxies = []string{"10.0.0.0/8"}
+	proxied := httptest.NewRequest(http.MethodGet, "/", nil)
+	proxied.RemoteAddr = "10.1.2.3:5000"
+	proxied.Header.Set("X-Forwarded-Proto", "HTTPS")
+	spoofed := httptest.NewRequest(http.MethodGet, "/", nil)
+	spoofed.RemoteAddr = "203.0.113.7:5000"
+	spoofed.Header.Set("X-Forwarded-Proto", "https")
+
+	for _, tc := range []struct {
+		scheme string
+		r      *http.Request
+		want   string
+	}{
+		{"auto", plain, "http"},
+		{"auto", secure, "https"},
+		{"auto", proxied, "https"},
+		{"auto", spoofed, "http"}, // клиент не прокси: заголовок игнорируется
+		{"http", secure, "http"},
+		{"https", plain, "https"},
+	} {
+		config.Server.Scheme = tc.scheme
+		if got := requestScheme(tc.r); got != tc.want {
+			t.Errorf("scheme %s: requestScheme = %q, ожидалось %q", tc.scheme, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Поддерживаемые языки; каталог каждого лежит в locales/<код>.json
+var supportedLanguages = []string{"ru", "en"}
+
+// Каталог сообщений одного языка
+type locale struct {
+	Code       string                       `json:"-"`
+	Name       string                       `json:"name"`
+	DateFormat string                       `json:"date_format"`
+	Messages   map[string]string            `json:"messages"`
+	Plurals    map[string]map[string]string `json:"plurals"`
+}
+
+var (
+	locales   = make(map[string]*locale)
+	logLocale = &locale{Code: "ru"} // язык консольных сообщений
+)
+
+// Загрузка каталогов при старте (после конфигурации)
+func loadLocales() error {
+	fsys := assetsFS()
+	for _, code := range supportedLanguages {
+		data, err := fs.ReadFile(fsys, "locales/"+code+".json")
+		if err != nil {
+			return fmt.Errorf("каталог %s: %w", code, err)
+		}
+		loc := &locale{Code: code}
+		if err := json.Unmarshal(data, loc); err != nil {
+			return fmt.Errorf("каталог %s: %w", code, err)
+		}
+		locales[code] = loc
+	}
+	logLocale = locales[config.I18n.LogLanguage]
+	return nil
+}
+
+// Перевод сообщения; аргументы подставляются как в fmt.Sprintf.
+// Если перевода нет, возвращается сам ключ - так пропуск сразу виден.
+func (l *locale) T(key string, args ...any) string {
+	msg, ok := l.Messages[key]
+	if !ok {
+		msg = key
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// Ошибка во введенных владельцем данных: ключ каталога и аргументы.
+// Текст выбирается по языку запроса (errorText); в журнал и там, где языка
+// нет, ошибка попадает на языке консоли. Аргументы-ошибки тоже переводятся,
+// так вложенные ошибки ("строка 2: ...") собираются на одном языке.
+type inputError struct {
+	key  string
+	args []any
+}
+
+func inputErr(key string, args ...any) error {
+	return &inputError{key, args}
+}
+
+func (e *inputError) Error() string {
+	return e.text(logLocale)
+}
+
+func (e *inputError) text(l *locale) string {
+	args := make([]any, len(e.args))
+	for i, arg := range e.args {
+		if err, ok := arg.(error); ok {
+			arg = errorText(l, err)
+		}
+		args[i] = arg
+	}
+	return l.T(e.key, args...)
+}
+
+// Текст ошибки на языке l; ошибки не из каталога возвращаются как есть
+func errorText(l *locale, err error) string {
+	ie, ok := err.(*inputError)
+	if !ok || l == nil {
+		return err.Error()
+	}
+	return ie.text(l)
+}
+
+// Число с правильной формой слова: "5 переходов", "1 visit"
+func (l *locale) N(key string, n int) string {
+	forms := l.Plurals[key]
+	msg, ok := forms[pluralCategory(l.Code, n)]
+	if !ok {
+		msg, ok = forms["other"]
+	}
+	if !ok {
+		return strconv.Itoa(n) + " " + key
+	}
+	return fmt.Sprintf(msg, n)
+}
+
+// Дата в формате языка
+func (l *locale) Date(t time.Time) string {
+	return t.Format(l.DateFormat)
+}
+
+// Категория множественного числа по правилам CLDR для целых чисел
+func pluralCategory(lang string, n int) string {
+	if n < 0 {
+		n = -n
+	}
+	switch lang {
+	case "ru":
+		switch {
+		case n%10 == 1 && n%100 != 11:
+			return "one"
+		case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+			return "few"
+		default:
+			return "many"
+		}
+	default:
+		if n == 1 {
+			return "one"
+		}
+		return "other"
+	}
+}
+
+func isSupportedLanguage(code string) bool {
+	for _, lang := range supportedLanguages {
+		if lang == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Выбор языка интерфейса из заголовка Accept-Language
+func parseAcceptLanguage(header string) string {
+	for _, lang := range acceptedLanguages(header) {
+		if isSupportedLanguage(lang) {
+			return lang
+		}
+	}
+	return ""
+}
+
+// Языки из Accept-Language (основной подтег) по убыванию веса q
+func acceptedLanguages(header string) []string {
+	type candidate struct {
+		lang string
+		q    float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		lang, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if lang == "" || lang == "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		if q > 0 {
+			candidates = append(candidates, candidate{lang, q})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	langs := make([]string, len(candidates))
+	for i, c := range candidates {
+		langs[i] = c.lang
+	}
+	return langs
+}
+
+type localeKey struct{}
+
+// Middleware выбора языка: параметр ?lang= (запоминается в cookie),
+// затем cookie, затем Accept-Language, затем язык по умолчанию
+func withLocale(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lang := r.URL.Query().Get("lang")
+		if isSupportedLanguage(lang) {
+			http.SetCookie(w, &http.Cookie{
+				Name:     "lang",
+				Value:    lang,
+				Path:     "/",
+				MaxAge:   365 * 24 * 3600,
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		} else if c, err := r.Cookie("lang"); err == nil && isSupportedLanguage(c.Value) {
+			lang = c.Value
+		} else if accepted := parseAcceptLanguage(r.Header.Get("Accept-Language")); accepted != "" {
+			lang = accepted
+		} else {
+			lang = config.I18n.DefaultLanguage
+		}
+
+		ctx := context.WithValue(r.Context(), localeKey{}, locales[lang])
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Язык текущего запроса
+func localeFrom(r *http.Request) *locale {
+	if l, ok := r.Context().Value(localeKey{}).(*locale); ok && l != nil {
+		return l
+	}
+	if l := locales[config.I18n.DefaultLanguage]; l != nil {
+		return l
+	}
+	return logLocale
+}
+
+// Переключатель языка в меню
+type languageLink struct {
+	Name   string
+	URL    string
+	Active bool
+}
+
+func languageLinks(r *http.Request) []languageLink {
+	current := localeFrom(r)
+	var result []languageLink
+	for _, code := range supportedLanguages {
+		query := r.URL.Query()
+		query.Set("lang", code)
+		u := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+		result = append(result, languageLink{
+			Name:   locales[code].Name,
+			URL:    u.String(),
+			Active: code == current.Code,
+		})
+	}
+	return result
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// Каждый ключ inputErr из исходников есть во всех каталогах с теми же подстановками
+func TestInputErrorKeysInCatalog(t *testing.T) {
+	if err := loadLocales(); err != nil {
+		t.Fatal(err)
+	}
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := regexp.MustCompile(`inputErr\("([^"]+)"`)
+	verb := regexp.MustCompile(`%[a-z]`)
+	keys := make(map[string]string) // ключ -> файл
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		src, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range call.FindAllSubmatch(src, -1) {
+			keys[string(m[1])] = name
+		}
+	}
+	if len(keys) == 0 {
+		t.Fatal("в исходниках не найдено ни одного inputErr")
+	}
+
+	base := locales[supportedLanguages[0]]
+	for key, file := range keys {
+		for _, code := range supportedLanguages {
+			msg, ok := locales[code].Messages[key]
+			if !ok {
+				t.Errorf("%s: ключа %q нет в каталоге %s", file, key, code)
+				continue
+			}
+			if got, want := verb.FindAllString(msg, -1), verb.FindAllString(base.Messages[key], -1); strings.Join(got, "") != strings.Join(want, "") {
+				t.Errorf("%s: подстановки %v в каталоге %s, а в %s - %v", key, got, code, base.Code, want)
+			}
+		}
+	}
+}
+
+// Вложенные ошибки собираются на одном языке; Error() - на языке журнала
+func TestErrorText(t *testing.T) {
+	if err := loadLocales(); err != nil {
+		t.Fatal(err)
+	}
+	err := inputErr("input.line", 2, inputErr("input.no_target"))
+	for code, want := range map[string]string{
+		"en": "line 2: no address after ->",
+		"ru": "строка 2: нет адреса после ->",
+	} {
+		if got := errorText(locales[code], err); got != want {
+			t.Errorf("%s: %q, ожидалось %q", code, got, want)
+		}
+	}
+	if err.Error() != errorText(logLocale, err) {
+		t.Errorf("Error() = %q не на языке журнала", err.Error())
+	}
+	if got := errorText(locales["en"], os.ErrNotExist); got != os.ErrNotExist.Error() {
+		t.Errorf("ошибка не из каталога: %q", got)
+	}
+}
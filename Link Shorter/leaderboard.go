@@ -0,0 +1,577 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Окно рейтинга
+type window string
+
+const (
+	windowAll window = ""
+	window24h window = "24h"
+	window7d  window = "7d"
+	window30d window = "30d"
+)
+
+var leaderboardWindows = []window{windowAll, window24h, window7d, window30d}
+
+// Окно из параметра запроса; неизвестное значение - за всё время
+func parseWindow(value string) window {
+	for _, w := range leaderboardWindows {
+		if string(w) == value {
+			return w
+		}
+	}
+	return windowAll
+}
+
+// Рейтинги и счетчики живут под отдельной блокировкой leaderMu.
+// Переход по ссылке ее не берет: он только кладет запись в visitQueue,
+// а рейтинги обновляет фоновый runLeaderboardRefresh пачками.
+// Порядок блокировок: сначала mutex, затем leaderMu.
+var (
+	leaderMu   sync.Mutex
+	boards     = make(map[boardID]*leaderboard)
+	activity   = make(map[linkKey]*linkActivity)
+	totals     = make(map[string]*domainTotals)
+	visitQueue = make(chan visit, 8192)
+)
+
+// Переход, ожидающий учета в рейтингах
+type visit struct {
+	key  linkKey
+	link *Link
+	at   time.Time
+}
+
+// Рейтинг для окна и домена (пустой домен - по всем доменам)
+type boardID struct {
+	window window
+	domain string
+}
+
+// Итоги по домену для страниц статистики
+type domainTotals struct {
+	links  int
+	visits int
+	ips    map[string]int // ip -> количество ссылок
+}
+
+type boardEntry struct {
+	key   linkKey
+	link  *Link
+	count int
+}
+
+// Строка рейтинга для страниц
+func (e boardEntry) stats() LinkStats {
+	return LinkStats{
+		ShortCode:   e.key.Code,
+		OriginalURL: e.link.OriginalURL,
+		Visits:      e.count,
+		CreatedAt:   e.link.CreatedAt,
+		IP:          e.link.IP,
+		Domain:      e.key.Domain,
+		Title:       e.link.Title,
+		Favicon:     e.link.Favicon,
+	}
+}
+
+// Первые K ссылок, отсортированные по убыванию переходов.
+// Переход сдвигает ссылку вверх за O(K), чтение первых N - O(N).
+type leaderboard struct {
+	size    int
+	entries []boardEntry
+	index   map[linkKey]int
+	partial bool // из полного рейтинга удаляли ссылки, нужен пересчет
+}
+
+func newLeaderboard(size int) *leaderboard {
+	return &leaderboard{size: size, index: make(map[linkKey]int)}
+}
+
+// Порядок рейтингов и полной сортировки в getTopLinks: по переходам,
+// при равенстве новые выше, затем по коду
+func ranksAbove(a, b boardEntry) bool {
+	if a.count != b.count {
+		return a.count > b.count
+	}
+	if !a.link.CreatedAt.Equal(b.link.CreatedAt) {
+		return a.link.CreatedAt.After(b.link.CreatedAt)
+	}
+	return a.key.Code < b.key.Code
+}
+
+// Новое значение счетчика ссылки
+func (b *leaderboard) update(key linkKey, link *Link, count int) {
+	if i, ok := b.index[key]; ok {
+		b.entries[i].count = count
+		b.fix(i)
+		return
+	}
+
+	e := boardEntry{key, link, count}
+	if len(b.entries) < b.size {
+		b.entries = append(b.entries, e)
+		b.index[key] = len(b.entries) - 1
+		b.fix(len(b.entries) - 1)
+		return
+	}
+
+	last := len(b.entries) - 1
+	if last < 0 || !ranksAbove(e, b.entries[last]) {
+		return
+	}
+	delete(b.index, b.entries[last].key)
+	b.entries[last] = e
+	b.index[key] = last
+	b.fix(last)
+}
+
+// Перемещение элемента на свое место
+func (b *leaderboard) fix(i int) {
+	for i > 0 && ranksAbove(b.entries[i], b.entries[i-1]) {
+		b.swap(i, i-1)
+		i--
+	}
+	for i < len(b.entries)-1 && ranksAbove(b.entries[i+1], b.entries[i]) {
+		b.swap(i, i+1)
+		i++
+	}
+}
+
+func (b *leaderboard) swap(i, j int) {
+	b.entries[i], b.entries[j] = b.entries[j], b.entries[i]
+	b.index[b.entries[i].key] = i
+	b.index[b.entries[j].key] = j
+}
+
+// Удаление ссылки за O(K). Место в полном рейтинге освобождается,
+// следующую ссылку найдет периодический пересчет.
+func (b *leaderboard) remove(key linkKey) {
+	i, ok := b.index[key]
+	if !ok {
+		return
+	}
+	if len(b.entries) == b.size {
+		b.partial = true
+	}
+	delete(b.index, key)
+	b.entries = append(b.entries[:i], b.entries[i+1:]...)
+	for ; i < len(b.entries); i++ {
+		b.index[b.entries[i].key] = i
+	}
+}
+
+func (b *leaderboard) top(n int) []boardEntry {
+	if n <= 0 || n > len(b.entries) {
+		n = len(b.entries)
+	}
+	return append([]boardEntry(nil), b.entries[:n]...)
+}
+
+// Заполнение рейтинга с нуля
+func (b *leaderboard) reset(entries []boardEntry) {
+	sort.Slice(entries, func(i, j int) bool { return ranksAbove(entries[i], entries[j]) })
+	if len(entries) > b.size {
+		entries = entries[:b.size]
+	}
+	b.entries = entries
+	b.partial = false
+	b.index = make(map[linkKey]int, len(entries))
+	for i, e := range entries {
+		b.index[e.key] = i
+	}
+}
+
+// Переходы по часам за сутки и по дням за месяц
+type visitBucket struct {
+	at int64 // номер часа или дня с начала эпохи
+	n  int
+}
+
+type linkActivity struct {
+	link  *Link
+	hours [24]visitBucket
+	days  [30]visitBucket
+}
+
+func (a *linkActivity) add(now time.Time) {
+	hour := now.Unix() / 3600
+	day := hour / 24
+	if b := &a.hours[hour%24]; b.at == hour {
+		b.n++
+	} else {
+		*b = visitBucket{hour, 1}
+	}
+	if b := &a.days[day%30]; b.at == day {
+		b.n++
+	} else {
+		*b = visitBucket{day, 1}
+	}
+}
+
+func (a *linkActivity) count(w window, now time.Time) int {
+	hour := now.Unix() / 3600
+	day := hour / 24
+	total := 0
+	switch w {
+	case window24h:
+		for _, b := range a.hours {
+			if b.at > hour-24 {
+				total += b.n
+			}
+		}
+	case window7d, window30d:
+		days := int64(7)
+		if w == window30d {
+			days = 30
+		}
+		for _, b := range a.days {
+			if b.at > day-days {
+				total += b.n
+			}
+		}
+	}
+	return total
+}
+
+// Переходы по часам и дням в файле базы: пары [номер часа или дня, переходы]
+type activityState struct {
+	Hours [][2]int64 `json:"hours,omitempty"`
+	Days  [][2]int64 `json:"days,omitempty"`
+}
+
+func (a *linkActivity) state() *activityState {
+	st := &activityState{}
+	for _, b := range a.hours {
+		if b.n > 0 {
+			st.Hours = append(st.Hours, [2]int64{b.at, int64(b.n)})
+		}
+	}
+	for _, b := range a.days {
+		if b.n > 0 {
+			st.Days = append(st.Days, [2]int64{b.at, int64(b.n)})
+		}
+	}
+	return st
+}
+
+func (a *linkActivity) restore(st *activityState) {
+	for _, p := range st.Hours {
+		if p[0] >= 0 && p[1] > 0 {
+			a.hours[p[0]%24] = visitBucket{p[0], int(p[1])}
+		}
+	}
+	for _, p := range st.Days {
+		if p[0] >= 0 && p[1] > 0 {
+			a.days[p[0]%30] = visitBucket{p[0], int(p[1])}
+		}
+	}
+}
+
+// Рейтинги, которые затрагивает ссылка: общий и ее домена
+func boardDomains(key linkKey) []string {
+	if key.Domain == "" {
+		return []string{""}
+	}
+	return []string{"", key.Domain}
+}
+
+func getBoard(w window, domain string) *leaderboard {
+	id := boardID{w, domain}
+	b := boards[id]
+	if b == nil {
+		b = newLeaderboard(config.Stats.LeaderboardSize)
+		boards[id] = b
+	}
+	return b
+}
+
+func getTotals(domain string) *domainTotals {
+	t := totals[domain]
+	if t == nil {
+		t = &domainTotals{ips: make(map[string]int)}
+		totals[domain] = t
+	}
+	return t
+}
+
+// Учет перехода по ссылке: без блокировок, если очередь не переполнена.
+// При переполнении переход учитывается сразу, вместе с накопленными.
+func recordVisit(key linkKey, link *Link) {
+	v := visit{key, link, time.Now()}
+	select {
+	case visitQueue <- v:
+		return
+	default:
+	}
+
+	leaderMu.Lock()
+	drainVisits()
+	applyVisit(v)
+	leaderMu.Unlock()
+}
+
+// Учет переходов, накопленных в очереди (вызывается под leaderMu).
+// Берет не больше, чем было в очереди на момент вызова.
+func drainVisits() {
+	for n := len(visitQueue); n > 0; n-- {
+		select {
+		case v := <-visitQueue:
+			applyVisit(v)
+		default:
+			return
+		}
+	}
+}
+
+// Перенос перехода в рейтинги и итоги (вызывается под leaderMu)
+func applyVisit(v visit) {
+	key, link := v.key, v.link
+	now := v.at
+
+	// Ссылку могли удалить, пока переход ждал в очереди
+	if link.removed {
+		return
+	}
+
+	act := activity[key]
+	if act == nil {
+		act = &linkActivity{link: link}
+		activity[key] = act
+	}
+	act.add(now)
+
+	// Счетчик читаем при учете, а не при переходе: он только растет,
+	// поэтому рейтинг не откатится назад из-за порядка в очереди
+	visits := link.Visits.Load()
+
+	link.counted++
+	for _, domain := range boardDomains(key) {
+		getTotals(domain).visits++
+		getBoard(windowAll, domain).update(key, link, visits)
+		for _, w := range leaderboardWindows[1:] {
+			getBoard(w, domain).update(key, link, act.count(w, now))
+		}
+	}
+}
+
+// Новая ссылка (вызывается под mutex)
+func addToLeaderboard(key linkKey, link *Link) {
+	leaderMu.Lock()
+	defer leaderMu.Unlock()
+
+	link.counted = link.Visits.Load()
+	for _, domain := range boardDomains(key) {
+		t := getTotals(domain)
+		t.links++
+		t.visits += link.counted
+		t.ips[link.IP]++
+		getBoard(windowAll, domain).update(key, link, link.counted)
+	}
+}
+
+// Удаление ссылки (вызывается под mutex после удаления из links).
+// Освободившиеся места в рейтингах занимают следующие ссылки
+// при ближайшем пересчете в runLeaderboardRefresh.
+func removeFromLeaderboard(key linkKey, link *Link) {
+	leaderMu.Lock()
+	defer leaderMu.Unlock()
+
+	// Переходы из очереди должны попасть в link.counted до вычитания из итогов
+	drainVisits()
+	link.removed = true
+	delete(activity, key)
+	for _, domain := range boardDomains(key) {
+		t := getTotals(domain)
+		t.links--
+		t.visits -= link.counted
+		if t.ips[link.IP]--; t.ips[link.IP] <= 0 {
+			delete(t.ips, link.IP)
+		}
+		for _, w := range leaderboardWindows {
+			if b := boards[boardID{w, domain}]; b != nil {
+				b.remove(key)
+			}
+		}
+	}
+}
+
+// Статистика по часам и дням для сохранения (вызывается под mutex)
+func activitySnapshot() map[linkKey]*activityState {
+	leaderMu.Lock()
+	defer leaderMu.Unlock()
+	drainVisits()
+
+	states := make(map[linkKey]*activityState, len(activity))
+	for key, act := range activity {
+		states[key] = act.state()
+	}
+	return states
+}
+
+// Статистика из базы (вызывается под mutex до rebuildLeaderboards,
+// которая отбросит вышедшие из окон переходы)
+func restoreActivity(states map[linkKey]*activityState) {
+	leaderMu.Lock()
+	defer leaderMu.Unlock()
+
+	activity = make(map[linkKey]*linkActivity, len(states))
+	for key, st := range states {
+		if link := links[key]; link != nil {
+			act := &linkActivity{link: link}
+			act.restore(st)
+			activity[key] = act
+		}
+	}
+}
+
+// Смена владельца ссылки (вызывается под mutex до изменения link.IP)
+func reassignInLeaderboard(key linkKey, link *Link, newIP string) {
+	leaderMu.Lock()
+	defer leaderMu.Unlock()
+
+	for _, domain := range boardDomains(key) {
+		t := getTotals(domain)
+		if t.ips[link.IP]--; t.ips[link.IP] <= 0 {
+			delete(t.ips, link.IP)
+		}
+		t.ips[newIP]++
+	}
+}
+
+// Полный пересчет (вызывается под mutex после загрузки базы)
+func rebuildLeaderboards() {
+	leaderMu.Lock()
+	defer leaderMu.Unlock()
+	drainVisits()
+
+	totals = make(map[string]*domainTotals)
+	for key, link := range links {
+		link.counted = link.Visits.Load()
+		for _, domain := range boardDomains(key) {
+			t := getTotals(domain)
+			t.links++
+			t.visits += link.counted
+			t.ips[link.IP]++
+		}
+	}
+	rebuildAllTime()
+	refreshWindows(time.Now())
+}
+
+// Пересчет рейтингов за всё время по всем ссылкам (вызывается под mutex)
+func rebuildAllTime() {
+	entries := make(map[string][]boardEntry)
+	for key, link := range links {
+		for _, domain := range boardDomains(key) {
+			entries[domain] = append(entries[domain], boardEntry{key, link, link.Visits.Load()})
+		}
+	}
+	for id, b := range boards {
+		if id.window == windowAll {
+			b.reset(entries[id.domain])
+			delete(entries, id.domain)
+		}
+	}
+	for domain, list := range entries {
+		getBoard(windowAll, domain).reset(list)
+	}
+}
+
+// Дозаполнение рейтингов за всё время, из которых удаляли ссылки
+func refillAllTime() {
+	for id, b := range boards {
+		if id.window == windowAll && b.partial {
+			rebuildAllTime()
+			return
+		}
+	}
+}
+
+// Пересчет рейтингов за период: старые переходы выпадают из окна.
+// Ссылки без переходов за 30 дней перестают отслеживаться.
+func refreshWindows(now time.Time) {
+	entries := make(map[boardID][]boardEntry)
+	for key, act := range activity {
+		if act.count(window30d, now) == 0 {
+			delete(activity, key)
+			continue
+		}
+		for _, domain := range boardDomains(key) {
+			for _, w := range leaderboardWindows[1:] {
+				if n := act.count(w, now); n > 0 {
+					id := boardID{w, domain}
+					entries[id] = append(entries[id], boardEntry{key, act.link, n})
+				}
+			}
+		}
+	}
+	for id, b := range boards {
+		if id.window != windowAll {
+			b.reset(entries[id])
+			delete(entries, id)
+		}
+	}
+	for id, list := range entries {
+		getBoard(id.window, id.domain).reset(list)
+	}
+}
+
+// Учет переходов из очереди и ежеминутный пересчет: рейтинги за период
+// и рейтинги за всё время, из которых удаляли ссылки.
+// При остановке очередь разбирается до конца, чтобы попасть в последнее сохранение.
+func runLeaderboardRefresh(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			leaderMu.Lock()
+			drainVisits()
+			leaderMu.Unlock()
+			return
+		case v := <-visitQueue:
+			leaderMu.Lock()
+			applyVisit(v)
+			drainVisits()
+			leaderMu.Unlock()
+		case now := <-ticker.C:
+			mutex.RLock()
+			leaderMu.Lock()
+			drainVisits()
+			refillAllTime()
+			refreshWindows(now)
+			leaderMu.Unlock()
+			mutex.RUnlock()
+		}
+	}
+}
+
+// Первые n ссылок рейтинга (вызывается под mutex.RLock)
+func leaderboardTop(w window, domain string, n int) []LinkStats {
+	leaderMu.Lock()
+	defer leaderMu.Unlock()
+	drainVisits()
+
+	var stats []LinkStats
+	for _, e := range getBoard(w, domain).top(n) {
+		stats = append(stats, e.stats())
+	}
+	return stats
+}
+
+// Итоги по домену: ссылок, переходов, уникальных IP
+func leaderboardTotals(domain string) (int, int, int) {
+	leaderMu.Lock()
+	defer leaderMu.Unlock()
+	drainVisits()
+
+	t := getTotals(domain)
+	return t.links, t.visits, len(t.ips)
+}
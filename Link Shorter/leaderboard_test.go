@@ -0,0 +1,211 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func boardCodes(b *leaderboard) []string {
+	var codes []string
+	for i, e := range b.entries {
+		if b.index[e.key] != i {
+			panic("индекс рейтинга рассогласован")
+		}
+		codes = append(codes, e.key.Code)
+	}
+	return codes
+}
+
+func TestLeaderboardRanking(t *testing.T) {
+	now := time.Now()
+	old := &Link{CreatedAt: now.Add(-time.Hour)}
+	fresh := &Link{CreatedAt: now}
+	b := newLeaderboard(3)
+
+	b.update(linkKey{"", "a"}, old, 5)
+	b.update(linkKey{"", "b"}, old, 7)
+	b.update(linkKey{"", "c"}, fresh, 5)
+	if got := boardCodes(b); !reflect.DeepEqual(got, []string{"b", "c", "a"}) {
+		t.Fatalf("порядок %v: при равенстве новая ссылка выше", got)
+	}
+
+	// Слабее последнего - не попадает, сильнее - вытесняет его
+	b.update(linkKey{"", "d"}, old, 4)
+	b.update(linkKey{"", "e"}, old, 6)
+	if got := boardCodes(b); !reflect.DeepEqual(got, []string{"b", "e", "c"}) {
+		t.Fatalf("после вытеснения %v", got)
+	}
+
+	// Рост счетчика поднимает ссылку
+	b.update(linkKey{"", "c"}, fresh, 8)
+	if got := boardCodes(b); !reflect.DeepEqual(got, []string{"c", "b", "e"}) {
+		t.Fatalf("после перехода %v", got)
+	}
+	if top := b.top(2); len(top) != 2 || top[0].count != 8 || top[1].count != 7 {
+		t.Errorf("top(2) = %+v", top)
+	}
+}
+
+func TestLeaderboardRemove(t *testing.T) {
+	link := &Link{}
+	b := newLeaderboard(3)
+	b.update(linkKey{"", "a"}, link, 3)
+	b.update(linkKey{"", "b"}, link, 2)
+	b.remove(linkKey{"", "b"})
+	if got := boardCodes(b); !reflect.DeepEqual(got, []string{"a"}) || b.partial {
+		t.Fatalf("неполный рейтинг: %v, partial %v", got, b.partial)
+	}
+
+	b.update(linkKey{"", "b"}, link, 2)
+	b.update(linkKey{"", "c"}, link, 1)
+	b.remove(linkKey{"", "a"})
+	b.remove(linkKey{"", "missing"})
+	if got := boardCodes(b); !reflect.DeepEqual(got, []string{"b", "c"}) || !b.partial {
+		t.Fatalf("после удаления из полного рейтинга: %v, partial %v", got, b.partial)
+	}
+	b.reset([]boardEntry{{linkKey{"", "x"}, link, 1}})
+	if b.partial {
+		t.Error("пересчет не снял отметку partial")
+	}
+}
+
+func TestLinkActivityWindows(t *testing.T) {
+	now := time.Date(2026, 3, 31, 12, 30, 0, 0, time.UTC)
+	act := &linkActivity{}
+	// Переходы идут по времени: новый перезаписывает ячейку часа или дня,
+	// которую занимал переход из прошлого круга (25 ч и 1 ч назад)
+	for _, ago := range []time.Duration{40 * 24 * time.Hour, 20 * 24 * time.Hour, 6 * 24 * time.Hour, 25 * time.Hour, 23 * time.Hour, time.Hour, 0} {
+		act.add(now.Add(-ago))
+	}
+
+	for w, want := range map[window]int{window24h: 3, window7d: 5, window30d: 6} {
+		if got := act.count(w, now); got != want {
+			t.Errorf("count(%s) = %d, ожидалось %d", w, got, want)
+		}
+	}
+
+	restored := &linkActivity{}
+	restored.restore(act.state())
+	if restored.hours != act.hours || restored.days != act.days {
+		t.Error("статистика изменилась после сохранения и загрузки")
+	}
+}
+
+func visitLink(t *testing.T, code string, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if !redirectShortLink(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/"+code, nil)) {
+			t.Fatalf("ссылка %s не найдена", code)
+		}
+	}
+}
+
+func topCodes(w window, n int) []string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	var codes []string
+	for _, s := range leaderboardTop(w, "", n) {
+		codes = append(codes, s.ShortCode)
+	}
+	return codes
+}
+
+func TestLeaderboardVisitsAndRemoval(t *testing.T) {
+	codes := seedLinks(t, 4)
+	config.Storage.Backend = "memory"
+	config.Stats.LeaderboardSize = 3
+	boards = make(map[boardID]*leaderboard)
+	rebuildLeaderboards()
+	for i, code := range codes {
+		links[linkKey{"", code}].IP = "1.2.3.4"
+		visitLink(t, code, i+1)
+	}
+
+	want := []string{codes[3], codes[2], codes[1]}
+	for _, w := range leaderboardWindows {
+		if got := topCodes(w, 0); !reflect.DeepEqual(got, want) {
+			t.Errorf("рейтинг %q: %v, ожидалось %v", w, got, want)
+		}
+	}
+	if n, visits, ips := leaderboardTotals(""); n != 4 || visits != 10 || ips != 1 {
+		t.Errorf("итоги: %d ссылок, %d переходов, %d IP", n, visits, ips)
+	}
+
+	key := linkKey{"", codes[3]}
+	mutex.Lock()
+	link := links[key]
+	delete(links, key)
+	removeFromLeaderboard(key, link)
+	mutex.Unlock()
+
+	if got := topCodes(windowAll, 0); !reflect.DeepEqual(got, []string{codes[2], codes[1]}) {
+		t.Errorf("после удаления %v", got)
+	}
+	if n, visits, _ := leaderboardTotals(""); n != 3 || visits != 6 {
+		t.Errorf("итоги после удаления: %d ссылок, %d переходов", n, visits)
+	}
+
+	// Освободившееся место занимает следующая ссылка при пересчете
+	mutex.RLock()
+	leaderMu.Lock()
+	refillAllTime()
+	refreshWindows(time.Now())
+	leaderMu.Unlock()
+	mutex.RUnlock()
+	want = []string{codes[2], codes[1], codes[0]}
+	for _, w := range leaderboardWindows {
+		if got := topCodes(w, 0); !reflect.DeepEqual(got, want) {
+			t.Errorf("рейтинг %q после пересчета: %v, ожидалось %v", w, got, want)
+		}
+	}
+}
+
+// Рейтинги за период переживают перезапуск
+func TestActivityPersisted(t *testing.T) {
+	codes := seedLinks(t, 2)
+	config.Storage.Path = filepath.Join(t.TempDir(), "links.json")
+	dbLoaded.Store(true)
+	visitLink(t, codes[1], 2)
+	visitLink(t, codes[0], 1)
+	if err := saveDatabase(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := config.Storage.Path
+	resetState()
+	config.Storage.Path = path
+	loadDatabase()
+	if got := topCodes(window24h, 0); !reflect.DeepEqual(got, []string{codes[1], codes[0]}) {
+		t.Errorf("рейтинг за сутки после загрузки: %v", got)
+	}
+}
+
+// Полная сортировка для "Все ссылки" дает тот же порядок, что и рейтинг
+func TestTopLinksMatchLeaderboard(t *testing.T) {
+	codes := seedLinks(t, 6)
+	created := time.Now().Truncate(time.Second)
+	for i, code := range codes {
+		link := links[linkKey{"", code}]
+		link.CreatedAt = created
+		link.Visits.Store(i % 2)
+	}
+	rebuildLeaderboards()
+
+	mutex.RLock()
+	full := getTopLinks(0, "")
+	mutex.RUnlock()
+	var got []string
+	for _, s := range full {
+		got = append(got, s.ShortCode)
+	}
+	if want := topCodes(windowAll, 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("getTopLinks: %v, рейтинг: %v", got, want)
+	}
+	if !reflect.DeepEqual(got, []string{codes[1], codes[3], codes[5], codes[0], codes[2], codes[4]}) {
+		t.Errorf("порядок при равенстве: %v", got)
+	}
+}
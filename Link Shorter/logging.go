@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Настройка логгера по секции [log]
+func setupLogger(cfg LogConfig) {
+	var level slog.Level
+	level.UnmarshalText([]byte(cfg.Level))
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	if cfg.Format == "json" {
+		handler = slog.NewJSONHandler(os.Stdout, opts)
+	} else {
+		handler = slog.NewTextHandler(os.Stdout, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// Событие в лог: текст на языке логов, ключ события в поле event,
+// данные - отдельными полями, чтобы их можно было фильтровать
+func logEvent(level slog.Level, event string, attrs ...any) {
+	attrs = append([]any{"event", event}, attrs...)
+	slog.Log(context.Background(), level, logLocale.T("log."+event), attrs...)
+}
+
+// Ошибка, после которой работать дальше нельзя
+func fatal(event string, err error) {
+	logEvent(slog.LevelError, event, "error", err)
+	os.Exit(1)
+}
+
+// Данные запроса, которые обработчики дописывают для журнала
+type requestInfo struct {
+	ID        string
+	ShortCode string
+}
+
+type requestInfoKey struct{}
+
+func requestInfoFrom(r *http.Request) *requestInfo {
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		return info
+	}
+	return &requestInfo{}
+}
+
+// ID запроса: из X-Request-ID прокси, если он безопасен, иначе случайный
+func requestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); id != "" && len(id) <= 64 && isRequestIDSafe(id) {
+		return id
+	}
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func isRequestIDSafe(id string) bool {
+	for _, ch := range id {
+		if !isCodeChar(ch) && ch != '.' && ch != ':' {
+			return false
+		}
+	}
+	return true
+}
+
+// ResponseWriter, запоминающий статус и размер ответа
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += n
+	return n, err
+}
+
+// Middleware журнала запросов. Стоит первым в цепочке, чтобы
+// в журнал попадали и отклоненные проверкой Host запросы.
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := &requestInfo{ID: requestID(r)}
+		w.Header().Set("X-Request-ID", info.ID)
+		ctx := context.WithValue(r.Context(), requestInfoKey{}, info)
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		if !config.Log.AccessLog || isProbePath(r.URL.Path) {
+			return
+		}
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		level := slog.LevelInfo
+		if rec.status >= 500 {
+			level = slog.LevelError
+		}
+		attrs := []any{
+			"request_id", info.ID,
+			"method", r.Method,
+			"host", r.Host,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"latency", time.Since(start),
+			"ip", getIP(r),
+		}
+		if info.ShortCode != "" {
+			attrs = append(attrs, "short_code", info.ShortCode)
+		}
+		logEvent(level, "http_request", attrs...)
+	})
+}
@@ -1,27 +1,68 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"math/rand"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
-	"math/rand"
+	"syscall"
 	"time"
 )
 
 // Структура для хранения ссылки
 type Link struct {
-	OriginalURL string    `json:"original_url"`
-	ShortCode   string    `json:"short_code"`
-	CreatedAt   time.Time `json:"created_at"`
-	IP          string    `json:"ip"`
-	Visits      int       `json:"visits"`
+	OriginalURL string     `json:"original_url"`
+	ShortCode   string     `json:"short_code"`
+	CreatedAt   time.Time  `json:"created_at"`
+	IP          string     `json:"ip"`
+	Visits      Counter    `json:"visits"`
+	Domain      string     `json:"domain,omitempty"`
+	Status      string     `json:"status,omitempty"`   // пауза владельца
+	Disabled    bool       `json:"disabled,omitempty"` // отключена администратором
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Tags        []string   `json:"tags,omitempty"`
+	Folder      string     `json:"folder,omitempty"`
+	LastVisit   Timestamp  `json:"last_visit_at"`
+	Title       string     `json:"title,omitempty"`
+	Description string     `json:"description,omitempty"`
+	Favicon     string     `json:"favicon,omitempty"`
+
+	// Карточка превью для мессенджеров и соцсетей
+	OGTitle       string `json:"og_title,omitempty"`
+	OGDescription string `json:"og_description,omitempty"`
+	OGImage       string `json:"og_image,omitempty"`
+
+	// Передавать параметры короткой ссылки на адрес назначения
+	ForwardQuery bool `json:"forward_query,omitempty"`
+
+	// Правила перенаправления по платформе, языку, стране и времени;
+	// OriginalURL - если ни одно не подошло
+	Rules []redirectRule `json:"rules,omitempty"`
+
+	// Варианты A/B-теста; если заданы, OriginalURL не используется
+	Variants []*linkVariant `json:"variants,omitempty"`
+
+	// Веб-адрес для диплинка (OriginalURL по схеме приложения), если
+	// приложение не открылось
+	Fallback string `json:"fallback,omitempty"`
+
+	// Правила уведомлений о переходах
+	Alerts []*alertRule `json:"alerts,omitempty"`
+
+	// Служебные поля рейтинга, защищены leaderMu
+	removed bool // ссылка удалена
+	counted int  // переходы, учтенные в итогах статистики
 }
 
 // Структура для сортировки по посещениям
@@ -31,948 +72,785 @@ type LinkStats struct {
 	Visits      int
 	CreatedAt   time.Time
 	IP          string
+	Domain      string
+	Status      string
+	ExpiresAt   *time.Time
+	Tags        []string
+	Folder      string
+	LastVisit   time.Time
+	Title       string
+	Description string
+	Favicon     string
+
+	OGTitle       string
+	OGDescription string
+	OGImage       string
+	ForwardQuery  bool
+	Rules         []redirectRule
+	Variants      string // варианты A/B-теста в текстовом виде
+	Fallback      string
+	Alerts        string // правила уведомлений в текстовом виде
 }
 
 // Глобальные переменные
 var (
-	links      = make(map[string]*Link)    // short_code -> Link
-	ipLinks    = make(map[string][]string) // ip -> []short_codes
-	mutex      sync.RWMutex
-	dbFile     = "data/links.json"
+	links     = make(map[linkKey]*Link)    // (domain, short_code) -> Link
+	ipLinks   = make(map[string][]linkKey) // ip -> [](domain, short_code)
+	mutex     sync.RWMutex
+	saveMutex sync.Mutex // не даёт двум сохранениям писать файл одновременно
+	config    = defaultConfig()
 )
 
 func main() {
 	rand.Seed(time.Now().UnixNano())
-	
+
+	// Встроенные переводы нужны для сообщений до загрузки конфигурации
+	loadLocales()
+
+	// Хэш пароля администратора для admin.users: url-shortener hash-password
+	if len(os.Args) > 1 && os.Args[1] == "hash-password" {
+		printAdminPasswordHash()
+		return
+	}
+
+	// Загружаем конфигурацию
+	cfg, err := loadConfig(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		fatal("config_error", err)
+	}
+	config = cfg
+	setupLogger(config.Log)
+
 	// Создаем папку для базы данных
-	os.MkdirAll("data", 0755)
-	
-	// Загружаем базу данных
-	loadDatabase()
-	
+	if config.Storage.Backend == "json" {
+		os.MkdirAll(filepath.Dir(config.Storage.Path), 0755)
+	}
+
+	// Загружаем шаблоны страниц и переводы
+	if err := loadTemplates(); err != nil {
+		fatal("templates_error", err)
+	}
+	if err := loadLocales(); err != nil {
+		fatal("templates_error", err)
+	}
+
+	// Загружаем базу данных; пока она не загружена, /readyz отвечает 503,
+	// а сохранение отказывается перезаписывать файл
+	if err := loadDatabase(); err == nil {
+		dbLoaded.Store(true)
+	}
+	if err := loadAdminState(); err != nil {
+		fatal("admin_state_read_error", err)
+	}
+	if err := loadMisses(); err != nil {
+		fatal("misses_read_error", err)
+	}
+	if config.Features.Webhooks {
+		if err := loadWebhookState(); err != nil {
+			fatal("webhook_state_read_error", err)
+		}
+		onEvent(queueWebhookEvent)
+	}
+	if config.Features.Notifications {
+		if err := loadFeed(); err != nil {
+			fatal("notify_state_read_error", err)
+		}
+	}
+	if config.GeoIP.Path != "" {
+		db, err := loadGeoDB(config.GeoIP.Path)
+		if err != nil {
+			fatal("geoip_error", err)
+		}
+		geoIP = db
+		logEvent(slog.LevelInfo, "geoip_loaded", "path", config.GeoIP.Path, "ranges", len(db.ranges))
+	}
+
 	// Стартовая страница
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		// Если это короткая ссылка - перенаправляем
 		if r.URL.Path != "/" {
-			shortCode := strings.TrimPrefix(r.URL.Path, "/")
-			mutex.RLock()
-			link, exists := links[shortCode]
-			mutex.RUnlock()
-			
-			if exists {
-				// Увеличиваем счетчик посещений
-				mutex.Lock()
-				link.Visits++
-				mutex.Unlock()
-				
-				// Сохраняем изменения
-				go func() {
-					mutex.RLock()
-					saveDatabase()
-					mutex.RUnlock()
-				}()
-				
-				http.Redirect(w, r, link.OriginalURL, http.StatusFound)
-				return
+			if !redirectShortLink(w, r) {
+				unknownCodes.Inc()
+				renderNotFound(w, r)
 			}
+			return
 		}
 
 		// Показываем форму
-		html := fmt.Sprintf(`<!DOCTYPE html>
-<html>
-<head>
-	<meta charset="utf-8">
-	<title>🔗 Сократитель ссылок</title>
-	<style>
-		body {
-			font-family: Arial, sans-serif;
-			max-width: 500px;
-			margin: 50px auto;
-			padding: 20px;
-		}
-		input {
-			width: 100%%;
-			padding: 10px;
-			margin: 10px 0;
-			font-size: 16px;
-		}
-		button {
-			background: #0078d4;
-			color: white;
-			padding: 12px 24px;
-			border: none;
-			cursor: pointer;
-			font-size: 16px;
-		}
-		button:hover {
-			background: #005a9e;
-		}
-		.result {
-			margin-top: 20px;
-			padding: 15px;
-			background: #e6f3ff;
-			border-radius: 5px;
-		}
-		.menu {
-			margin: 20px 0;
-		}
-		.menu a {
-			margin-right: 15px;
-			color: #0078d4;
-			text-decoration: none;
-		}
-		.menu a:hover {
-			text-decoration: underline;
-		}
-		.info {
-			margin-top: 20px;
-			padding: 15px;
-			background: #f8f9fa;
-			border-radius: 5px;
-			font-size: 14px;
-		}
-		.domain {
-			font-weight: bold;
-			color: #28a745;
-		}
-		.badge {
-			display: inline-block;
-			padding: 3px 8px;
-			border-radius: 10px;
-			font-size: 12px;
-			margin-left: 10px;
-		}
-		.badge-hot {
-			background: #ff6b6b;
-			color: white;
-		}
-		.badge-new {
-			background: #4ecdc4;
-			color: white;
-		}
-	</style>
-</head>
-<body>
-	<div style="max-width: 600px; margin: 0 auto;">
-		<h1>🔗 Сократитель ссылок</h1>
-		
-		<div class="menu">
-			<a href="/">Главная</a>
-			<a href="/my">Мои ссылки</a>
-			<a href="/stats">Статистика</a>
-		</div>
-		
-		<form method="POST" action="/shorten">
-			<input type="url" name="url" placeholder="https://example.com" required>
-			<button type="submit">Сократить</button>
-		</form>
-		
-		<div class="info">
-			<p><strong>Текущий домен:</strong> <span class="domain">%s</span></p>
-			<p>Ссылки сохраняются автоматически в файл <code>%s</code></p>
-		</div>
-`, getCurrentDomain(r), dbFile)
-
-		// Если есть результат от предыдущего запроса
-		if result := r.URL.Query().Get("result"); result != "" {
-			html += `<div class="result">
-				<strong>Короткая ссылка:</strong><br>
-				<a href="` + result + `">` + result + `</a><br>
-				<small>Скопируйте эту ссылку</small>
-			</div>`
-		}
-
-		html += `</div></body></html>`
-		
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		fmt.Fprint(w, html)
+		renderPage(w, r, "index", indexPage{
+			page:           newPage(r, "index"),
+			Domains:        domainChoices(),
+			SelectedDomain: requestDomain(r),
+			CurrentDomain:  getCurrentDomain(r),
+			Storage:        config.Storage,
+			Expiries:       expiryValues(),
+			UTMParams:      utmParams,
+			DeepLinks:      strings.Join(config.DeepLinks.Schemes, ", "),
+			Result:         r.URL.Query().Get("result"),
+		})
 	})
 
+	// Стили и прочая статика (встроенная или из темы)
+	http.Handle("/static/", http.FileServer(http.FS(assetsFS())))
+
 	// Создание короткой ссылки
-	http.HandleFunc("/shorten", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/shorten", requireOwner(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "POST" {
 			http.Redirect(w, r, "/", http.StatusFound)
 			return
 		}
 
-		url := r.FormValue("url")
-		if url == "" {
+		originalURL := r.FormValue("url")
+		if originalURL == "" {
 			http.Redirect(w, r, "/", http.StatusFound)
 			return
 		}
+		originalURL = normalizeURL(originalURL)
 
-		// Добавляем протокол если нет
-		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
-			url = "https://" + url
+		// Метки UTM из конструктора формы
+		utm := make(map[string]string)
+		for _, name := range utmParams {
+			utm[name] = r.FormValue(name)
+		}
+		originalURL, err := applyUTM(originalURL, utm)
+		if err != nil {
+			http.Error(w, localeFrom(r).T("error.bad_url"), http.StatusBadRequest)
+			return
+		}
+
+		// Запасной веб-адрес нужен только диплинку
+		var fallback string
+		if isDeepLink(originalURL) {
+			if fallback, err = normalizeFallback(r.FormValue("fallback")); err != nil {
+				http.Error(w, localeFrom(r).T("error.bad_fallback", errorText(localeFrom(r), err)), http.StatusBadRequest)
+				return
+			}
+		}
+
+		// Домен выбирается в форме, по умолчанию - домен запроса
+		domain := r.FormValue("domain")
+		if !isKnownDomain(domain) {
+			domain = requestDomain(r)
 		}
 
-		// Генерация короткого кода
-		shortCode := generateCode(6)
-		
 		// Получаем IP пользователя
 		ip := getIP(r)
-		
+
 		// Создаем запись
-		link := &Link{
-			OriginalURL: url,
-			ShortCode:   shortCode,
-			CreatedAt:   time.Now(),
-			IP:          ip,
-			Visits:      0,
-		}
-		
-		// Сохраняем в память
-		mutex.Lock()
-		links[shortCode] = link
-		ipLinks[ip] = append(ipLinks[ip], shortCode)
-		mutex.Unlock()
-		
-		// Сохраняем в базу данных
-		saveDatabase()
+		now := time.Now()
+		key := createLink(&Link{
+			OriginalURL:  originalURL,
+			CreatedAt:    now,
+			IP:           ip,
+			Domain:       domain,
+			ExpiresAt:    parseExpiry(r.FormValue("expires"), now),
+			Tags:         parseTags(r.FormValue("tags")),
+			Folder:       normalizeFolder(r.FormValue("folder")),
+			Title:        cleanText(r.FormValue("title"), maxTitleLength),
+			ForwardQuery: r.FormValue("forward_query") != "",
+			Fallback:     fallback,
+		})
 
 		// Показываем результат
-		shortURL := getCurrentDomain(r) + "/" + shortCode
-		http.Redirect(w, r, "/?result="+shortURL, http.StatusFound)
-	})
+		shortURL := shortLinkURL(r, domain, key.Code)
+		http.Redirect(w, r, "/?result="+url.QueryEscape(shortURL), http.StatusFound)
+	}))
 
 	// Личный кабинет
 	http.HandleFunc("/my", func(w http.ResponseWriter, r *http.Request) {
-		ip := getIP(r)
-		
-		mutex.RLock()
-		userCodes := ipLinks[ip]
-		
-		html := fmt.Sprintf(`<!DOCTYPE html>
-<html>
-<head>
-	<meta charset="utf-8">
-	<title>Мои ссылки</title>
-	<style>
-		body {
-			font-family: Arial, sans-serif;
-			max-width: 800px;
-			margin: 0 auto;
-			padding: 20px;
-		}
-		.link {
-			background: #f5f5f5;
-			padding: 15px;
-			margin: 10px 0;
-			border-radius: 5px;
-			border-left: 4px solid #0078d4;
-		}
-		.delete-btn {
-			background: #dc3545;
-			color: white;
-			border: none;
-			padding: 5px 10px;
-			cursor: pointer;
-			margin-top: 10px;
-			border-radius: 3px;
-		}
-		.delete-btn:hover {
-			background: #c82333;
-		}
-		.menu {
-			margin: 20px 0;
-		}
-		.menu a {
-			margin-right: 15px;
-			color: #0078d4;
-			text-decoration: none;
-		}
-		.menu a:hover {
-			text-decoration: underline;
-		}
-		.no-links {
-			padding: 20px;
-			text-align: center;
-			background: #f8f9fa;
-			border-radius: 5px;
+		if !config.Features.Dashboard {
+			http.NotFound(w, r)
+			return
 		}
-		.url-info {
-			font-size: 12px;
-			color: #666;
-			margin: 5px 0;
+		dashboardHandler(w, r)
+	})
+
+	// Статистика одной ссылки
+	http.HandleFunc("/my/stats/", func(w http.ResponseWriter, r *http.Request) {
+		if !config.Features.Dashboard {
+			http.NotFound(w, r)
+			return
 		}
-		.short-url {
-			font-family: monospace;
-			font-size: 16px;
+		linkStatsHandler(w, r)
+	})
+
+	// Массовые действия с тегами и папками
+	http.HandleFunc("/my/bulk", func(w http.ResponseWriter, r *http.Request) {
+		if !config.Features.Dashboard {
+			http.NotFound(w, r)
+			return
 		}
-		.info-box {
-			background: #e8f4ff;
-			padding: 15px;
-			border-radius: 5px;
-			margin: 20px 0;
+		requireOwner(bulkHandler)(w, r)
+	})
+
+	// JSON API кабинета
+	http.HandleFunc("/api/links", func(w http.ResponseWriter, r *http.Request) {
+		if !config.Features.Dashboard {
+			http.NotFound(w, r)
+			return
 		}
-		.visits-count {
-			display: inline-block;
-			background: #28a745;
-			color: white;
-			padding: 2px 8px;
-			border-radius: 10px;
-			font-size: 12px;
-			margin-left: 10px;
+		apiLinksHandler(w, r)
+	})
+	http.HandleFunc("/api/tags", func(w http.ResponseWriter, r *http.Request) {
+		if !config.Features.Dashboard {
+			http.NotFound(w, r)
+			return
 		}
-		.badge {
-			display: inline-block;
-			padding: 3px 8px;
-			border-radius: 10px;
-			font-size: 12px;
-			margin-left: 10px;
+		apiTagsHandler(w, r)
+	})
+
+	// Пауза и возобновление ссылки владельцем
+	http.HandleFunc("/pause/", func(w http.ResponseWriter, r *http.Request) {
+		if !config.Features.Dashboard {
+			http.NotFound(w, r)
+			return
 		}
-		.badge-hot {
-			background: #ff6b6b;
-			color: white;
+		requireOwner(pauseHandler)(w, r)
+	})
+
+	// Название, описание и значок ссылки
+	http.HandleFunc("/edit/", func(w http.ResponseWriter, r *http.Request) {
+		if !config.Features.Dashboard {
+			http.NotFound(w, r)
+			return
 		}
-	</style>
-</head>
-<body>
-	<h1>👤 Мои ссылки</h1>
-	
-	<div class="menu">
-		<a href="/">Главная</a>
-		<a href="/my">Мои ссылки</a>
-		<a href="/stats">Статистика</a>
-	</div>
-	
-	<div class="info-box">
-		<p><strong>Ваш IP:</strong> %s</p>
-		<p><strong>Всего ссылок:</strong> %d</p>
-	</div>
-`, ip, len(userCodes))
-		
-		if len(userCodes) == 0 {
-			html += `<div class="no-links">
-				<p>У вас пока нет созданных ссылок</p>
-				<a href="/">Создать первую ссылку</a>
-			</div>`
-		} else {
-			// Сортируем ссылки пользователя по количеству посещений (убывание)
-			userLinks := make([]LinkStats, 0, len(userCodes))
-			for _, code := range userCodes {
-				if link, exists := links[code]; exists {
-					userLinks = append(userLinks, LinkStats{
-						ShortCode:   code,
-						OriginalURL: link.OriginalURL,
-						Visits:      link.Visits,
-						CreatedAt:   link.CreatedAt,
-					})
-				}
-			}
-			
-			// Сортируем по убыванию количества посещений
-			sort.Slice(userLinks, func(i, j int) bool {
-				return userLinks[i].Visits > userLinks[j].Visits
-			})
-			
-			for _, linkStat := range userLinks {
-				shortURL := getCurrentDomain(r) + "/" + linkStat.ShortCode
-				visitsBadge := ""
-				if linkStat.Visits > 0 {
-					visitsBadge = fmt.Sprintf(`<span class="visits-count">%d переходов</span>`, linkStat.Visits)
-				}
-				
-				html += fmt.Sprintf(`
-				<div class="link">
-					<strong class="short-url"><a href="%s" target="_blank">%s</a>%s</strong>
-					<div class="url-info">
-						<strong>Оригинал:</strong> %s<br>
-						<strong>Создано:</strong> %s
-					</div>
-					<a href="/delete/%s"><button class="delete-btn">Удалить</button></a>
-				</div>`,
-					shortURL, shortURL, visitsBadge,
-					linkStat.OriginalURL,
-					linkStat.CreatedAt.Format("02.01.2006 15:04"),
-					linkStat.ShortCode)
+		requireOwner(editHandler)(w, r)
+	})
+
+	// Вебхуки владельца
+	webhookRoute := func(handler http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !config.Features.Webhooks {
+				http.NotFound(w, r)
+				return
 			}
+			requireOwner(handler)(w, r)
 		}
-		
-		html += `</body></html>`
-		
-		mutex.RUnlock()
-		
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		fmt.Fprint(w, html)
+	}
+	http.HandleFunc("/my/webhooks", webhookRoute(webhooksHandler))
+	http.HandleFunc("/my/webhooks/delete", webhookRoute(deleteWebhookHandler))
+	http.HandleFunc("/my/webhooks/retry", webhookRoute(retryWebhookHandler))
+
+	// Лента уведомлений
+	http.HandleFunc("/my/notifications/clear", func(w http.ResponseWriter, r *http.Request) {
+		if !config.Features.Notifications {
+			http.NotFound(w, r)
+			return
+		}
+		requireOwner(clearFeedHandler)(w, r)
 	})
 
-	// Удаление ссылки
-	http.HandleFunc("/delete/", func(w http.ResponseWriter, r *http.Request) {
+	// Удаление ссылки: только POST из формы кабинета
+	http.HandleFunc("/delete/", requireOwner(func(w http.ResponseWriter, r *http.Request) {
 		code := strings.TrimPrefix(r.URL.Path, "/delete/")
-		if code == "" {
+		if code == "" || r.Method != "POST" {
 			http.Redirect(w, r, "/my", http.StatusFound)
 			return
 		}
-		
+
 		ip := getIP(r)
-		
+		key := linkKey{r.URL.Query().Get("domain"), code}
+
 		mutex.Lock()
-		defer mutex.Unlock()
-		
+
 		// Проверяем, что ссылка существует и принадлежит этому IP
-		if link, exists := links[code]; exists && link.IP == ip {
-			// Удаляем ссылку
-			delete(links, code)
-			
-			// Удаляем из списка ссылок пользователя
-			if codes, ok := ipLinks[ip]; ok {
-				newCodes := []string{}
-				for _, c := range codes {
-					if c != code {
-						newCodes = append(newCodes, c)
-					}
-				}
-				ipLinks[ip] = newCodes
-			}
-			
+		link, exists := links[key]
+		deleted := exists && link.IP == ip
+		var event linkEvent
+		if deleted {
+			event = deleteLink(key, link)
+		}
+
+		mutex.Unlock()
+
+		if deleted {
+			publishEvent(event)
+
 			// Сохраняем изменения
-			saveDatabase()
-			
-			fmt.Printf("🗑️ Удалена ссылка: %s (IP: %s)\n", code, ip)
+			markDirty()
+
+			logEvent(slog.LevelInfo, "link_deleted", "short_code", code, "domain", key.Domain, "ip", ip)
 		}
-		
+
 		// Возвращаем в кабинет
 		http.Redirect(w, r, "/my", http.StatusFound)
-	})
+	}))
 
 	// Статистика
 	http.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
-		mutex.RLock()
-		defer mutex.RUnlock()
-
-		totalLinks := len(links)
-		totalVisits := 0
-		for _, link := range links {
-			totalVisits += link.Visits
-		}
-		
-		html := fmt.Sprintf(`<!DOCTYPE html>
-<html>
-<head>
-	<meta charset="utf-8">
-	<title>Статистика</title>
-	<style>
-		body {
-			font-family: Arial, sans-serif;
-			max-width: 800px;
-			margin: 0 auto;
-			padding: 20px;
-		}
-		.menu {
-			margin: 20px 0;
-		}
-		.menu a {
-			margin-right: 15px;
-			color: #0078d4;
-			text-decoration: none;
-		}
-		.menu a:hover {
-			text-decoration: underline;
-		}
-		.stats-card {
-			background: #f5f5f5;
-			padding: 20px;
-			border-radius: 5px;
-			margin: 20px 0;
-		}
-		.link-item {
-			padding: 10px;
-			margin: 5px 0;
-			background: white;
-			border-radius: 3px;
-			border-left: 3px solid #0078d4;
-		}
-		.stats-grid {
-			display: grid;
-			grid-template-columns: repeat(auto-fit, minmax(200px, 1fr));
-			gap: 20px;
-			margin: 20px 0;
-		}
-		.stat-box {
-			background: #e8f4ff;
-			padding: 15px;
-			border-radius: 5px;
-			text-align: center;
-		}
-		.stat-number {
-			font-size: 32px;
-			font-weight: bold;
-			color: #0078d4;
-		}
-		.top-link {
-			padding: 15px;
-			margin: 10px 0;
-			background: white;
-			border-radius: 5px;
-			border-left: 5px solid #ff6b6b;
-		}
-		.rank {
-			display: inline-block;
-			width: 30px;
-			height: 30px;
-			background: #0078d4;
-			color: white;
-			text-align: center;
-			line-height: 30px;
-			border-radius: 50%%;
-			margin-right: 10px;
-			font-weight: bold;
+		if !config.Features.Stats {
+			http.NotFound(w, r)
+			return
 		}
-		.rank-1 { background: #ffd700; }
-		.rank-2 { background: #c0c0c0; }
-		.rank-3 { background: #cd7f32; }
-		.visits-badge {
-			background: #28a745;
-			color: white;
-			padding: 3px 8px;
-			border-radius: 10px;
-			font-size: 12px;
-			float: right;
+
+		// Фильтр по домену
+		domain := r.URL.Query().Get("domain")
+		if !isKnownDomain(domain) {
+			domain = ""
 		}
-		.badge {
-			display: inline-block;
-			padding: 3px 8px;
-			border-radius: 10px;
-			font-size: 12px;
-			margin-left: 10px;
+
+		data := statsPage{
+			page:    newPage(r, "stats"),
+			Domains: domainChoices(),
+			Domain:  domain,
 		}
-		.badge-hot {
-			background: #ff6b6b;
-			color: white;
+		data.TotalLinks, data.TotalVisits, data.UniqueIPs = leaderboardTotals(domain)
+
+		// Топ-5 ссылок
+		mutex.RLock()
+		topLinks := leaderboardTop(windowAll, domain, 5)
+		data.Tags = tagStats(domain, 20)
+		mutex.RUnlock()
+		for i, linkStat := range topLinks {
+			data.Top = append(data.Top, newLinkCard(r, linkStat, i+1))
 		}
-	</style>
-</head>
-<body>
-	<h1>📊 Статистика</h1>
-	
-	<div class="menu">
-		<a href="/">Главная</a>
-		<a href="/my">Мои ссылки</a>
-		<a href="/stats">Статистика</a>
-	</div>
-	
-	<div class="stats-grid">
-		<div class="stat-box">
-			<div class="stat-number">%d</div>
-			<div>Всего ссылок</div>
-		</div>
-		<div class="stat-box">
-			<div class="stat-number">%d</div>
-			<div>Всего переходов</div>
-		</div>
-		<div class="stat-box">
-			<div class="stat-number">%d</div>
-			<div>Уникальных IP</div>
-		</div>
-	</div>
-	
-	<div class="stats-card">
-		<h3>Топ-5 самых популярных ссылок:</h3>
-`, totalLinks, totalVisits, len(ipLinks))
-		
-		if len(links) == 0 {
-			html += "<p>Ссылок пока нет</p>"
-		} else {
-			// Получаем топ-5 ссылок
-			topLinks := getTopLinks(5)
-			
-			for i, linkStat := range topLinks {
-				rankClass := ""
-				if i == 0 {
-					rankClass = "rank-1"
-				} else if i == 1 {
-					rankClass = "rank-2"
-				} else if i == 2 {
-					rankClass = "rank-3"
-				}
-				
-				shortURL := getCurrentDomain(r) + "/" + linkStat.ShortCode
-				html += fmt.Sprintf(`
-				<div class="top-link">
-					<div>
-						<span class="rank %s">%d</span>
-						<strong><a href="%s">%s</a></strong>
-						<span class="visits-badge">%d переходов</span>
-					</div>
-					<div style="margin-left: 40px; margin-top: 10px; font-size: 14px; color: #666;">
-						<strong>Оригинал:</strong> %s<br>
-						<small>Создано: %s <!-- | IP: %s</small> -->
-					</div>
-				</div>`,
-					rankClass, i+1,
-					shortURL, shortURL, linkStat.Visits,
-					linkStat.OriginalURL,
-					linkStat.CreatedAt.Format("02.01.2006 15:04"),
-					linkStat.IP)
-			}
-			
-			html += `<p style="margin-top: 20px; text-align: center;">
 
-			</p>`
+		// Переходы по несуществующим кодам
+		topMissed, totalMisses := topMisses(domain, 10)
+		data.TotalMisses = totalMisses
+		for _, m := range topMissed {
+			data.Misses = append(data.Misses, missCard{
+				ShortURL: shortLinkURL(r, m.Domain, m.ShortCode),
+				Count:    m.Count,
+				LastSeen: m.LastSeen,
+			})
 		}
-		
-		html += `</div></body></html>`
-		
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		fmt.Fprint(w, html)
+		renderPage(w, r, "stats", data)
 	})
 
 	// Топ ссылок (полная страница)
 	http.HandleFunc("/top", func(w http.ResponseWriter, r *http.Request) {
-		mutex.RLock()
-		defer mutex.RUnlock()
-
-		// Получаем топ-50 ссылок
-		topLinks := getTopLinks(50)
-		
-		html := fmt.Sprintf(`<!DOCTYPE html>
-<html>
-<head>
-	<meta charset="utf-8">
-	<title>Топ ссылок 🔥</title>
-	<style>
-		body {
-			font-family: Arial, sans-serif;
-			max-width: 900px;
-			margin: 0 auto;
-			padding: 20px;
-		}
-		.menu {
-			margin: 20px 0;
-		}
-		.menu a {
-			margin-right: 15px;
-			color: #0078d4;
-			text-decoration: none;
-		}
-		.menu a:hover {
-			text-decoration: underline;
-		}
-		.top-link {
-			padding: 15px;
-			margin: 10px 0;
-			background: white;
-			border-radius: 5px;
-			box-shadow: 0 2px 5px rgba(0,0,0,0.1);
-			transition: transform 0.2s;
-		}
-		.top-link:hover {
-			transform: translateY(-2px);
-			box-shadow: 0 4px 10px rgba(0,0,0,0.15);
-		}
-		.rank {
-			display: inline-block;
-			width: 35px;
-			height: 35px;
-			background: #0078d4;
-			color: white;
-			text-align: center;
-			line-height: 35px;
-			border-radius: 50%%;
-			margin-right: 15px;
-			font-weight: bold;
-			font-size: 16px;
-		}
-		.rank-1 { background: linear-gradient(135deg, #ffd700, #ffaa00); }
-		.rank-2 { background: linear-gradient(135deg, #c0c0c0, #a0a0a0); }
-		.rank-3 { background: linear-gradient(135deg, #cd7f32, #a65c00); }
-		.visits-badge {
-			background: #28a745;
-			color: white;
-			padding: 5px 12px;
-			border-radius: 15px;
-			font-size: 14px;
-			float: right;
-			font-weight: bold;
-		}
-		.url-info {
-			margin-left: 50px;
-			margin-top: 10px;
-		}
-		.short-url {
-			font-family: monospace;
-			font-size: 18px;
-			font-weight: bold;
-		}
-		.original-url {
-			color: #666;
-			font-size: 14px;
-			margin: 5px 0;
-			word-break: break-all;
-		}
-		.meta-info {
-			font-size: 12px;
-			color: #888;
-			margin-top: 8px;
-		}
-		.stats-header {
-			background: linear-gradient(135deg, #ff6b6b, #ff8e53);
-			color: white;
-			padding: 20px;
-			border-radius: 10px;
-			margin: 20px 0;
-			text-align: center;
-		}
-		.tabs {
-			display: flex;
-			margin: 20px 0;
-			border-bottom: 2px solid #ddd;
-		}
-		.tab {
-			padding: 10px 20px;
-			cursor: pointer;
-			border-bottom: 3px solid transparent;
-		}
-		.tab.active {
-			border-bottom-color: #ff6b6b;
-			font-weight: bold;
-			color: #ff6b6b;
+		if !config.Features.Top {
+			http.NotFound(w, r)
+			return
 		}
-		.filter {
-			margin: 20px 0;
-			padding: 15px;
-			background: #f8f9fa;
-			border-radius: 5px;
+
+		// Фильтр по домену
+		domain := r.URL.Query().Get("domain")
+		if !isKnownDomain(domain) {
+			domain = ""
 		}
-		.filter select {
-			padding: 8px;
-			border-radius: 5px;
-			border: 1px solid #ddd;
+
+		// Период рейтинга и лимит из параметров запроса
+		period := parseWindow(r.URL.Query().Get("window"))
+		limit := 50
+		if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+			fmt.Sscanf(limitParam, "%d", &limit)
 		}
-		.empty-state {
-			text-align: center;
-			padding: 40px;
-			color: #666;
+		if limit < 0 {
+			limit = 0
 		}
-		.fire-icon {
-			color: #ff6b6b;
-			font-size: 24px;
-			margin-right: 10px;
+
+		// Рейтинг хранит первые leaderboard_size ссылок; для "Все ссылки"
+		// за всё время приходится сортировать базу целиком
+		var topLinks []LinkStats
+		mutex.RLock()
+		if period == windowAll && (limit == 0 || limit > config.Stats.LeaderboardSize) {
+			topLinks = getTopLinks(limit, domain)
+		} else {
+			topLinks = leaderboardTop(period, domain, limit)
 		}
-		.badge {
-			display: inline-block;
-			padding: 3px 8px;
-			border-radius: 10px;
-			font-size: 12px;
-			margin-left: 10px;
+		mutex.RUnlock()
+
+		data := topPage{
+			page:    newPage(r, "top"),
+			Domains: domainChoices(),
+			Domain:  domain,
+			Window:  period,
+			Caption: localeFrom(r).T("window." + windowName(period) + ".caption"),
+			Tabs:    windowTabs(period, r),
+			Limit:   limit,
+			Limits:  []int{10, 25, 50, 100, 0},
+		}
+		data.TotalLinks, data.TotalVisits, _ = leaderboardTotals(domain)
+		for i, linkStat := range topLinks {
+			data.Links = append(data.Links, newLinkCard(r, linkStat, i+1))
+		}
+		renderPage(w, r, "top", data)
+	})
+
+	// Раздел администратора
+	registerAdminHandlers()
+
+	// Проверки живости и готовности для оркестратора
+	http.HandleFunc("/healthz", healthzHandler)
+	http.HandleFunc("/readyz", readyzHandler)
+
+	// Метрики Prometheus
+	if config.Features.Metrics {
+		http.HandleFunc("/metrics", metricsHandler)
+	}
+
+	startAttrs := []any{
+		"listen", config.Server.ListenAddr,
+		"storage", config.Storage.Backend,
+		"dashboard", config.Features.Dashboard,
+		"stats", config.Features.Stats,
+		"top", config.Features.Top,
+		"metrics", config.Features.Metrics,
+	}
+	if config.Server.BaseURL != "" {
+		startAttrs = append(startAttrs, "public_url", config.Server.BaseURL)
+	}
+	if config.Storage.Backend == "json" {
+		startAttrs = append(startAttrs, "db", config.Storage.Path)
+	}
+	logEvent(slog.LevelInfo, "server_started", startAttrs...)
+	if len(allowedHosts()) == 0 {
+		logEvent(slog.LevelWarn, "host_header_trusted")
+	}
+
+	// Контекст завершения по Ctrl+C или SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Запускаем автосохранение
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		runSaver(ctx)
+	}()
+
+	// Пересчет рейтингов за 24 часа, 7 и 30 дней
+	go func() {
+		defer wg.Done()
+		runLeaderboardRefresh(ctx)
+	}()
+
+	// Загрузка названий и описаний страниц назначения
+	if config.Metadata.Fetch {
+		metaQueue = make(chan linkKey, 256)
+		fetcher := newMetaFetcher(config.Metadata.Timeout, int64(config.Metadata.MaxBytes), config.Metadata.AllowPrivate)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runMetaFetcher(ctx, fetcher)
+		}()
+	}
+
+	// Шина событий ссылок и доставка вебхуков. Шина останавливается
+	// последней, чтобы разобрать события завершившихся запросов и задач.
+	busCtx, stopBus := context.WithCancel(context.Background())
+	defer stopBus()
+	busDone := make(chan struct{})
+	if len(eventHandlers) > 0 {
+		go func() {
+			defer close(busDone)
+			runEventBus(busCtx)
+		}()
+	} else {
+		close(busDone)
+	}
+	if config.Features.Notifications {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runAlerts(ctx)
+		}()
+		if config.Notify.SMTPAddr != "" {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				runMailer(ctx)
+			}()
 		}
-		.badge-hot {
-			background: #ff6b6b;
-			color: white;
+	}
+	if config.Features.Webhooks {
+		client := newOutboundClient(config.Webhooks.Timeout, config.Webhooks.AllowPrivate)
+		client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runWebhooks(ctx, client)
+		}()
+	}
+
+	// Запускаем сервер
+	server := &http.Server{
+		Addr:    config.Server.ListenAddr,
+		Handler: accessLog(withLocale(checkHost(rateLimit(withCSRF(http.DefaultServeMux))))),
+	}
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		fatal("server_error", err)
+	case <-ctx.Done():
+	}
+	stop()
+
+	// Дожидаемся завершения текущих запросов
+	logEvent(slog.LevelInfo, "stopping")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.Server.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logEvent(slog.LevelWarn, "shutdown_timeout", "timeout", config.Server.ShutdownTimeout, "error", err)
+	}
+
+	// Останавливаем фоновые задачи и шину событий, затем сохраняем
+	// базу в последний раз
+	wg.Wait()
+	stopBus()
+	<-busDone
+	if err := flushDatabase(); err != nil {
+		logEvent(slog.LevelError, "final_save_failed")
+		os.Exit(1)
+	}
+	logEvent(slog.LevelInfo, "stopped")
+}
+
+// Перенаправление по короткой ссылке. Возвращает false, если кода нет.
+// Берет только блокировку на чтение: счетчик переходов атомарный.
+func redirectShortLink(w http.ResponseWriter, r *http.Request) bool {
+	start := time.Now()
+	key := linkKey{requestDomain(r), strings.TrimPrefix(r.URL.Path, "/")}
+	mutex.RLock()
+	link, exists := links[key]
+	var status, target string
+	var preview linkPreview
+	var rules []redirectRule
+	var fallback, owner string
+	hasPreview, forward, hasVariants := false, false, false
+	if exists {
+		status, target, fallback = link.state(time.Now()), link.OriginalURL, link.Fallback
+		owner = link.IP
+		preview, hasPreview = previewOf(link)
+		forward, rules = link.ForwardQuery, link.Rules
+		hasVariants = len(link.Variants) > 0
+	}
+	mutex.RUnlock()
+
+	if !exists {
+		return false
+	}
+	requestInfoFrom(r).ShortCode = key.Code
+
+	// Приостановленная, отключенная или истекшая ссылка - страница состояния
+	if status != statusActive {
+		renderStatusPage(w, r, status)
+		return true
+	}
+
+	// Боту превью - карточка вместо перенаправления; переходом это не считается
+	if hasPreview {
+		w.Header().Add("Vary", "User-Agent")
+		if isPreviewBot(r.UserAgent()) {
+			renderPreview(w, r, key, preview)
+			return true
 		}
-	</style>
-	<script>
-		function filterTop(limit) {
-			window.location.href = '/top?limit=' + limit;
+	}
+
+	// Увеличиваем счетчик посещений; рейтинг обновится в фоне
+	visits := link.Visits.Inc()
+	link.LastVisit.Store(time.Now())
+	recordVisit(key, link)
+	checkVisitThreshold(key, owner, target, int(visits))
+
+	// Сохранит фоновый процесс
+	markDirty()
+
+	// Правила заменяются целиком при изменении, срез можно читать без блокировки
+	matched := false
+	if len(rules) > 0 {
+		var ruleTarget string
+		if ruleTarget, matched = matchRules(rules, newVisitor(r, start)); matched {
+			target = ruleTarget
 		}
-		
-		// Автоматически обновляем страницу каждые 30 секунд
-		setTimeout(function() {
-			location.reload();
-		}, 30000);
-	</script>
-</head>
-<body>
-	<h1><span class="fire-icon">🔥</span> Топ ссылок</h1>
-	
-	<div class="menu">
-		<a href="/">Главная</a>
-		<a href="/my">Мои ссылки</a>
-		<a href="/stats">Статистика</a>
-		<a href="/top">Топ ссылок <span class="badge badge-hot">🔥</span></a>
-	</div>
-	
-	<div class="stats-header">
-		<h2 style="margin: 0; color: white;">Самые популярные ссылки</h2>
-		<p style="margin: 10px 0 0 0; opacity: 0.9;">Рейтинг основан на количестве переходов</p>
-	</div>
-	
-	<div class="filter">
-		<label for="limit">Показать топ:</label>
-		<select id="limit" onchange="filterTop(this.value)">
-			<option value="10" %s>10 ссылок</option>
-			<option value="25" %s>25 ссылок</option>
-			<option value="50" %s>50 ссылок</option>
-			<option value="100" %s>100 ссылок</option>
-			<option value="0" %s>Все ссылки</option>
-		</select>
-		<span style="margin-left: 20px; color: #666; font-size: 14px;">
-			Страница обновится автоматически через 30 секунд
-		</span>
-	</div>
-`, 
-		getSelectedAttr("10", r),
-		getSelectedAttr("25", r),
-		getSelectedAttr("50", r),
-		getSelectedAttr("100", r),
-		getSelectedAttr("0", r))
-		
-		if len(topLinks) == 0 {
-			html += `<div class="empty-state">
-				<h3>Пока нет данных</h3>
-				<p>Создайте первые ссылки, чтобы появился рейтинг</p>
-				<a href="/">Создать ссылку</a>
-			</div>`
-		} else {
-			// Определяем лимит из параметра запроса
-			limit := 50
-			if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
-				fmt.Sscanf(limitParam, "%d", &limit)
-				if limit <= 0 || limit > len(topLinks) {
-					limit = len(topLinks)
-				}
-			}
-			
-			// Показываем только нужное количество
-			if limit < len(topLinks) {
-				topLinks = topLinks[:limit]
-			}
-			
-			totalLinksCount := len(links)
-			totalVisitsCount := 0
-			for _, link := range links {
-				totalVisitsCount += link.Visits
-			}
-			
-			for i, linkStat := range topLinks {
-				rankClass := ""
-				if i == 0 {
-					rankClass = "rank-1"
-				} else if i == 1 {
-					rankClass = "rank-2"
-				} else if i == 2 {
-					rankClass = "rank-3"
-				}
-				
-				shortURL := getCurrentDomain(r) + "/" + linkStat.ShortCode
-				
-				// Определяем иконку активности
-				activityIcon := "📈"
-				if linkStat.Visits >= 100 {
-					activityIcon = "🔥"
-				} else if linkStat.Visits >= 50 {
-					activityIcon = "🚀"
-				} else if linkStat.Visits >= 10 {
-					activityIcon = "⚡"
-				}
-				
-				html += fmt.Sprintf(`
-				<div class="top-link">
-					<div>
-						<span class="rank %s">%d</span>
-						<span class="short-url"><a href="%s">%s</a></span>
-						<span class="visits-badge">%s %d переходов</span>
-					</div>
-					<div class="url-info">
-						<div class="original-url">%s</div>
-						<div class="meta-info">
-							Создано: %s <!| -- IP: %s -->
-						</div>
-					</div>
-				</div>`,
-					rankClass, i+1,
-					shortURL, shortURL,
-					activityIcon, linkStat.Visits,
-					linkStat.OriginalURL,
-					linkStat.CreatedAt.Format("02.01.2006 15:04"),
-					linkStat.IP)
+	}
+
+	// A/B-тест: вариант и его счетчики под блокировкой, чтобы правка
+	// вариантов не потеряла переходы
+	if !matched && hasVariants {
+		mutex.RLock()
+		if variants := link.Variants; len(variants) > 0 {
+			v, isNew := pickVariant(w, r, key.Code, variants)
+			v.Visits.Inc()
+			if isNew {
+				v.Visitors.Inc()
 			}
-			
-			html += fmt.Sprintf(`
-			<div style="margin-top: 30px; padding: 15px; background: #f8f9fa; border-radius: 5px; text-align: center;">
-				<p>Показано <strong>%d</strong> из <strong>%d</strong> ссылок</p>
-				<p>Всего переходов по всем ссылкам: <strong>%d</strong></p>
-			</div>`, len(topLinks), totalLinksCount, totalVisitsCount)
+			target = v.URL
 		}
-		
-		html += `</body></html>`
-		
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		fmt.Fprint(w, html)
-	})
+		mutex.RUnlock()
+	}
+	if forward {
+		target = forwardQuery(target, r.URL.RawQuery)
+		if fallback != "" {
+			fallback = forwardQuery(fallback, r.URL.RawQuery)
+		}
+	}
 
-	fmt.Println("========================================")
-	fmt.Println("🚀 Сократитель ссылок запущен!")
-	fmt.Println("📡 Порт: 8974")
-	fmt.Println("👤 Кабинет: /my")
-	fmt.Println("📊 Статистика: /stats")
-	fmt.Println("💾 База данных:", dbFile)
-	fmt.Println("========================================")
-	
-	// Запускаем автосохранение каждые 30 секунд
-	go func() {
-		for {
-			time.Sleep(30 * time.Second)
-			mutex.RLock()
-			saveDatabase()
-			mutex.RUnlock()
-			fmt.Println("💾 Автосохранение базы данных...")
+	// Приложение открывает промежуточная страница, без него - веб-адрес.
+	// Схему проверяем при каждом переходе: администратор мог убрать ее
+	// из deeplinks.schemes после создания ссылки.
+	switch {
+	case isDeepLink(target):
+		renderDeepLink(w, r, target, fallback)
+	case isWebURL(target):
+		http.Redirect(w, r, target, http.StatusFound)
+	case fallback != "":
+		http.Redirect(w, r, fallback, http.StatusFound)
+	default:
+		renderStatusPage(w, r, statusUnsupported)
+	}
+	redirects.Inc()
+	redirectDuration.Observe(time.Since(start))
+	return true
+}
+
+// Адрес назначения со схемой: без нее считаем https. Диплинки по схемам
+// из deeplinks.schemes остаются как есть.
+func normalizeURL(rawURL string) string {
+	rawURL = strings.TrimSpace(rawURL)
+	if isDeepLink(rawURL) {
+		return rawURL
+	}
+	if !strings.HasPrefix(rawURL, "http://") && !strings.HasPrefix(rawURL, "https://") {
+		rawURL = "https://" + rawURL
+	}
+	return rawURL
+}
+
+// Сохранение новой ссылки в домене link.Domain с кодом, свободным в этом домене
+func createLink(link *Link) linkKey {
+	mutex.Lock()
+	key := linkKey{link.Domain, generateCode(config.Codes.Length)}
+	for links[key] != nil {
+		key.Code = generateCode(config.Codes.Length)
+	}
+	link.ShortCode = key.Code
+	links[key] = link
+	ipLinks[link.IP] = append(ipLinks[link.IP], key)
+	addToLeaderboard(key, link)
+	indexLink(key, link)
+	indexCode(key)
+	event := linkEventOf(eventLinkCreated, key, link)
+	mutex.Unlock()
+	publishEvent(event)
+	forgetMiss(key)
+	linksCreated.Inc()
+	queueMetaFetch(key)
+
+	// Сохраняем в базу данных
+	markDirty()
+	return key
+}
+
+// Удаление ссылки из всех индексов (вызывается под mutex). Событие
+// об удалении вызывающий публикует после снятия блокировки.
+func deleteLink(key linkKey, link *Link) linkEvent {
+	delete(links, key)
+	removeFromLeaderboard(key, link)
+	unindexLink(key)
+	unindexCode(key)
+	removeOwnerLink(link.IP, key)
+	return linkEventOf(eventLinkDeleted, key, link)
+}
+
+// Удаление ссылки из списка ссылок владельца (вызывается под mutex)
+func removeOwnerLink(ip string, key linkKey) {
+	codes := ipLinks[ip]
+	newCodes := []linkKey{}
+	for _, c := range codes {
+		if c != key {
+			newCodes = append(newCodes, c)
 		}
-	}()
-	
-	// Запускаем сервер
-	err := http.ListenAndServe(":8974", nil)
-	if err != nil {
-		log.Fatal("Ошибка запуска сервера:", err)
+	}
+	if len(newCodes) == 0 {
+		delete(ipLinks, ip)
+	} else {
+		ipLinks[ip] = newCodes
 	}
 }
 
-// Функция для получения выбранного атрибута в select
-func getSelectedAttr(value string, r *http.Request) string {
-	limitParam := r.URL.Query().Get("limit")
-	if limitParam == "" {
-		limitParam = "50" // Значение по умолчанию
+// Имя периода рейтинга для ключей каталога: window.<имя>
+func windowName(period window) string {
+	if period == windowAll {
+		return "all"
 	}
-	
-	if limitParam == value {
-		return "selected"
+	return string(period)
+}
+
+// Вкладки периодов рейтинга с сохранением остальных параметров
+func windowTabs(period window, r *http.Request) []windowTab {
+	l := localeFrom(r)
+	var tabs []windowTab
+	for _, w := range leaderboardWindows {
+		query := r.URL.Query()
+		query.Set("window", string(w))
+		tabs = append(tabs, windowTab{
+			Label:  l.T("window." + windowName(w)),
+			URL:    "/top?" + query.Encode(),
+			Active: w == period,
+		})
 	}
-	return ""
+	return tabs
 }
 
 // Получение текущего домена из запроса
 func getCurrentDomain(r *http.Request) string {
-	// Всегда используем HTTPS для коротких ссылок
-	scheme := "https"
+	// Если публичный адрес задан в конфигурации - используем его
+	if config.Server.BaseURL != "" {
+		return config.Server.BaseURL
+	}
+
+	scheme := requestScheme(r)
 	host := r.Host
-	
+
 	// Если хост пустой (например, в тестах), используем localhost
 	if host == "" {
-		host = "localhost:8974"
+		_, port, _ := net.SplitHostPort(config.Server.ListenAddr)
+		host = net.JoinHostPort("localhost", port)
 		scheme = "http"
 	}
-	
-	// Убираем порт если это стандартный HTTPS порт
-	if strings.HasSuffix(host, ":443") {
+
+	// Убираем порт если он стандартный для схемы
+	if scheme == "https" {
 		host = strings.TrimSuffix(host, ":443")
+	} else {
+		host = strings.TrimSuffix(host, ":80")
 	}
-	
+
 	return scheme + "://" + host
 }
 
-// Получение IP адреса
+// Получение IP адреса. X-Forwarded-For подставляет кто угодно, поэтому
+// ему верим, только если соединение пришло от доверенного прокси.
 func getIP(r *http.Request) string {
-	// Пробуем получить из заголовка X-Forwarded-For (если за прокси)
-	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
-		ips := strings.Split(forwarded, ",")
-		if len(ips) > 0 {
-			return strings.TrimSpace(ips[0])
-		}
-	}
-	
-	// Если нет заголовка, берем RemoteAddr
 	ip, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		// Если не удалось разделить (нет порта), возвращаем как есть
-		return r.RemoteAddr
+		// Если не удалось разделить (нет порта), берем как есть
+		ip = r.RemoteAddr
+	}
+	if !isTrustedProxy(ip) {
+		return ip
+	}
+
+	// Каждый прокси дописывает адрес справа: идем справа налево
+	// и останавливаемся на первом адресе, который не наш прокси
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !isTrustedProxy(hop) {
+			break
+		}
 	}
 	return ip
 }
 
 // Генерация случайного кода
 func generateCode(length int) string {
-	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	letters := config.Codes.Alphabet
 	b := make([]byte, length)
 	for i := range b {
 		b[i] = letters[rand.Intn(len(letters))]
@@ -980,93 +858,149 @@ func generateCode(length int) string {
 	return string(b)
 }
 
+// Запись в файле базы: ссылка, ее переходы по часам и дням,
+// которые живут в рейтингах, а не в Link, и история счетчика для правил уведомлений
+type storedLink struct {
+	*Link
+	Activity     *activityState `json:"activity,omitempty"`
+	AlertHistory visitHistory   `json:"alert_history,omitempty"`
+}
+
 // Загрузка базы данных
-func loadDatabase() {
+func loadDatabase() error {
+	if config.Storage.Backend != "json" {
+		return nil
+	}
+
 	mutex.Lock()
 	defer mutex.Unlock()
-	
+
+	dbFile := config.Storage.Path
 	absPath, _ := filepath.Abs(dbFile)
-	fmt.Printf("📁 Загрузка базы данных: %s\n", absPath)
-	
+	logEvent(slog.LevelInfo, "db_loading", "path", absPath)
+
 	if _, err := os.Stat(dbFile); os.IsNotExist(err) {
-		fmt.Println("📁 База данных не найдена, создаём новую")
-		return
+		logEvent(slog.LevelWarn, "db_not_found", "path", absPath)
+		return nil
 	}
-	
+
 	data, err := os.ReadFile(dbFile)
 	if err != nil {
-		fmt.Printf("❌ Ошибка чтения базы данных: %v\n", err)
-		return
+		logEvent(slog.LevelError, "db_read_error", "path", absPath, "error", err)
+		return err
 	}
-	
-	var loadedLinks []Link
+
+	var loadedLinks []storedLink
 	if err := json.Unmarshal(data, &loadedLinks); err != nil {
-		fmt.Printf("❌ Ошибка парсинга базы данных: %v\n", err)
-		return
+		logEvent(slog.LevelError, "db_parse_error", "path", absPath, "error", err)
+		return err
 	}
-	
+
 	// Восстанавливаем обе мапы
-	links = make(map[string]*Link)
-	ipLinks = make(map[string][]string)
-	
-	for i := range loadedLinks {
-		link := &loadedLinks[i]
-		links[link.ShortCode] = link
-		ipLinks[link.IP] = append(ipLinks[link.IP], link.ShortCode)
+	links = make(map[linkKey]*Link)
+	ipLinks = make(map[string][]linkKey)
+
+	states := make(map[linkKey]*activityState)
+	histories := make(map[linkKey]visitHistory)
+	for _, stored := range loadedLinks {
+		link := stored.Link
+		if link == nil {
+			continue
+		}
+		// Старые записи без домена относим к домену по умолчанию
+		if link.Domain == "" {
+			link.Domain = defaultDomain()
+		}
+		// Раньше отключение хранилось в Status вместо паузы владельца
+		if link.Status == statusDisabled {
+			link.Status, link.Disabled = statusActive, true
+		}
+		key := linkKey{link.Domain, link.ShortCode}
+		links[key] = link
+		ipLinks[link.IP] = append(ipLinks[link.IP], key)
+		if stored.Activity != nil {
+			states[key] = stored.Activity
+		}
+		if len(stored.AlertHistory) > 0 {
+			histories[key] = stored.AlertHistory
+		}
 	}
-	
-	fmt.Printf("✅ Загружено %d ссылок\n", len(loadedLinks))
+	restoreActivity(states)
+	restoreAlertHistory(histories)
+	rebuildLeaderboards()
+	rebuildSearchIndex()
+	rebuildCodeIndex()
+
+	logEvent(slog.LevelInfo, "db_loaded", "links", len(loadedLinks))
+	return nil
 }
 
 // Сохранение базы данных
-func saveDatabase() {
+func saveDatabase() error {
+	if config.Storage.Backend != "json" {
+		return nil
+	}
+	// Файл, который не удалось прочитать, нельзя затирать пустой базой:
+	// пока загрузка не удалась, изменения остаются только в памяти
+	if !dbLoaded.Load() {
+		logEvent(slog.LevelError, "db_save_refused", "path", config.Storage.Path)
+		return errDatabaseNotLoaded
+	}
+
 	mutex.RLock()
 	defer mutex.RUnlock()
-	
-	var allLinks []Link
-	for _, link := range links {
-		allLinks = append(allLinks, *link)
+
+	states := activitySnapshot()
+	histories := alertHistorySnapshot()
+	var allLinks []storedLink
+	for key, link := range links {
+		allLinks = append(allLinks, storedLink{link, states[key], histories[key]})
 	}
-	
+
+	saveMutex.Lock()
+	defer saveMutex.Unlock()
+	start := time.Now()
+
 	data, err := json.MarshalIndent(allLinks, "", "  ")
 	if err != nil {
-		fmt.Printf("❌ Ошибка сериализации: %v\n", err)
-		return
+		logEvent(slog.LevelError, "db_marshal_error", "error", err)
+		saveFailures.Inc()
+		return err
 	}
-	
-	if err := os.WriteFile(dbFile, data, 0644); err != nil {
-		fmt.Printf("❌ Ошибка записи файла: %v\n", err)
-		return
+
+	err = writeFileAtomic(config.Storage.Path, data, 0644)
+	saveDuration.Observe(time.Since(start))
+	if err != nil {
+		saveFailures.Inc()
+		logEvent(slog.LevelError, "db_write_error", "path", config.Storage.Path, "error", err)
+		return err
 	}
+	logEvent(slog.LevelDebug, "db_saved", "links", len(allLinks), "bytes", len(data), "duration", time.Since(start))
+	return nil
 }
 
 // Получение топ N ссылок по посещениям
-func getTopLinks(n int) []LinkStats {
-	var stats []LinkStats
-	
-	for code, link := range links {
-		stats = append(stats, LinkStats{
-			ShortCode:   code,
-			OriginalURL: link.OriginalURL,
-			Visits:      link.Visits,
-			CreatedAt:   link.CreatedAt,
-			IP:          link.IP,
-		})
+// (пустой domain - по всем доменам)
+func getTopLinks(n int, domain string) []LinkStats {
+	var entries []boardEntry
+	for key, link := range links {
+		if domain != "" && key.Domain != domain {
+			continue
+		}
+		entries = append(entries, boardEntry{key, link, link.Visits.Load()})
 	}
-	
-	// Сортируем по убыванию количества посещений
-	sort.Slice(stats, func(i, j int) bool {
-		if stats[i].Visits == stats[j].Visits {
-			// Если посещения равны, сортируем по дате создания (новые первыми)
-			return stats[i].CreatedAt.After(stats[j].CreatedAt)
-		}
-		return stats[i].Visits > stats[j].Visits
-	})
-	
+
+	// Сортируем в том же порядке, что и рейтинги
+	sort.Slice(entries, func(i, j int) bool { return ranksAbove(entries[i], entries[j]) })
+
 	// Возвращаем только N первых
-	if n > 0 && n < len(stats) {
-		return stats[:n]
+	if n > 0 && n < len(entries) {
+		entries = entries[:n]
+	}
+
+	stats := make([]LinkStats, len(entries))
+	for i, e := range entries {
+		stats[i] = e.stats()
 	}
-	
 	return stats
-}
\ No newline at end of file
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+// Название, описание и значок страницы назначения. Их задает владелец,
+// а если включен metadata.fetch - фоновый загрузчик читает <title> и теги
+// OpenGraph со страницы сразу после создания ссылки.
+
+const (
+	maxTitleLength       = 200
+	maxDescriptionLength = 500
+	maxImageURLLength    = 2048
+	maxMetaRedirects     = 5
+)
+
+type pageMeta struct {
+	Title       string
+	Description string
+	Favicon     string
+}
+
+var errPrivateAddress = errors.New("адрес во внутренней сети")
+
+// Загрузчик метаданных страниц
+type metaFetcher struct {
+	client   *http.Client
+	maxBytes int64
+}
+
+func newMetaFetcher(timeout time.Duration, maxBytes int64, allowPrivate bool) *metaFetcher {
+	return &metaFetcher{client: newOutboundClient(timeout, allowPrivate), maxBytes: maxBytes}
+}
+
+// HTTP-клиент для запросов на адреса пользователей: без прокси, с общим
+// ограничением времени и без доступа во внутреннюю сеть
+func newOutboundClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = denyPrivateAddress
+	}
+	transport := &http.Transport{
+		// Без прокси: иначе проверялся бы адрес прокси, а не страницы
+		Proxy:                  nil,
+		DialContext:            dialer.DialContext,
+		TLSHandshakeTimeout:    timeout,
+		ResponseHeaderTimeout:  timeout,
+		MaxResponseHeaderBytes: 64 << 10,
+		DisableKeepAlives:      true,
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxMetaRedirects {
+				return errors.New("слишком много перенаправлений")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("перенаправление на схему %q", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
+
+// Проверка адреса в момент соединения, уже после разрешения имени:
+// ни DNS-запись на внутренний адрес, ни перенаправление ее не обходят
+func denyPrivateAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("%s: %w", host, errPrivateAddress)
+	}
+	return nil
+}
+
+// Диапазоны, которые не покрываются методами net.IP
+var reservedNets = parseCIDRs(
+	"0.0.0.0/8",     // "эта" сеть
+	"100.64.0.0/10", // CGNAT
+	"192.0.0.0/24",  // служебные адреса IETF
+	"198.18.0.0/15", // тестирование производительности
+	"240.0.0.0/4",   // зарезервировано
+	"64:ff9b::/96",  // NAT64: за ним может быть любой IPv4
+)
+
+func parseCIDRs(list ...string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, s := range list {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, n := range reservedNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// Загрузка страницы и чтение метаданных. Читается не больше maxBytes:
+// заголовок страницы почти всегда в начале документа.
+func (f *metaFetcher) fetch(ctx context.Context, rawURL string) (pageMeta, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return pageMeta{}, err
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return pageMeta{}, fmt.Errorf("схема %q не поддерживается", req.URL.Scheme)
+	}
+	req.Header.Set("User-Agent", "LinkShorter/1.0 (+metadata)")
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return pageMeta{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return pageMeta{}, fmt.Errorf("ответ %s", resp.Status)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return pageMeta{}, fmt.Errorf("тип %q не HTML", mediaType)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, f.maxBytes))
+	if err != nil {
+		return pageMeta{}, err
+	}
+	return parsePageMeta(string(body), resp.Request.URL), nil
+}
+
+var (
+	titleRe = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	tagRe   = regexp.MustCompile(`(?is)<(meta|link)\b([^>]*)>`)
+	attrRe  = regexp.MustCompile(`(?s)([a-zA-Z_:.-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+)
+
+// Метаданные из HTML: og:title и og:description важнее <title> и description,
+// значок - первый <link rel="icon">. Разбирается только <head>.
+func parsePageMeta(doc string, base *url.URL) pageMeta {
+	if end := strings.Index(strings.ToLower(doc), "</head>"); end >= 0 {
+		doc = doc[:end]
+	}
+
+	var meta pageMeta
+	var ogTitle, ogDescription string
+	if m := titleRe.FindStringSubmatch(doc); m != nil {
+		meta.Title = m[1]
+	}
+	for _, tag := range tagRe.FindAllStringSubmatch(doc, -1) {
+		attrs := parseAttrs(tag[2])
+		if strings.EqualFold(tag[1], "link") {
+			if meta.Favicon == "" && isIconRel(attrs["rel"]) {
+				meta.Favicon = resolveImageURL(attrs["href"], base)
+			}
+			continue
+		}
+		name := strings.ToLower(attrs["property"])
+		if name == "" {
+			name = strings.ToLower(attrs["name"])
+		}
+		switch name {
+		case "og:title":
+			ogTitle = attrs["content"]
+		case "og:description":
+			ogDescription = attrs["content"]
+		case "description":
+			if meta.Description == "" {
+				meta.Description = attrs["content"]
+			}
+		}
+	}
+	if cleanText(ogTitle, maxTitleLength) != "" {
+		meta.Title = ogTitle
+	}
+	if cleanText(ogDescription, maxDescriptionLength) != "" {
+		meta.Description = ogDescription
+	}
+
+	meta.Title = cleanText(meta.Title, maxTitleLength)
+	meta.Description = cleanText(meta.Description, maxDescriptionLength)
+	return meta
+}
+
+func parseAttrs(s string) map[string]string {
+	attrs := make(map[string]string)
+	for _, m := range attrRe.FindAllStringSubmatch(s, -1) {
+		name := strings.ToLower(m[1])
+		if _, ok := attrs[name]; !ok {
+			attrs[name] = html.UnescapeString(m[2] + m[3] + m[4])
+		}
+	}
+	return attrs
+}
+
+func isIconRel(rel string) bool {
+	for _, r := range strings.Fields(strings.ToLower(rel)) {
+		if r == "icon" {
+			return true
+		}
+	}
+	return false
+}
+
+// Адрес картинки относительно страницы; допустимы только http и https
+func resolveImageURL(href string, base *url.URL) string {
+	href = strings.TrimSpace(href)
+	if href == "" || len(href) > maxImageURLLength {
+		return ""
+	}
+	u, err := url.Parse(href)
+	if err != nil {
+		return ""
+	}
+	if base != nil {
+		u = base.ResolveReference(u)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return ""
+	}
+	return u.String()
+}
+
+// Текст в одну строку без HTML-сущностей и лишних пробелов, не длиннее limit символов
+func cleanText(s string, limit int) string {
+	s = strings.ToValidUTF8(html.UnescapeString(s), "")
+	s = strings.Join(strings.Fields(s), " ")
+	if utf8.RuneCountInString(s) > limit {
+		s = strings.TrimSpace(string([]rune(s)[:limit-1])) + "…"
+	}
+	return s
+}
+
+// Очередь ссылок на загрузку метаданных; nil - загрузка выключена
+var metaQueue chan linkKey
+
+func queueMetaFetch(key linkKey) {
+	if metaQueue == nil {
+		return
+	}
+	select {
+	case metaQueue <- key:
+	default:
+		logEvent(slog.LevelWarn, "metadata_queue_full", "short_code", key.Code, "domain", key.Domain)
+	}
+}
+
+// Фоновая загрузка метаданных до отмены контекста
+func runMetaFetcher(ctx context.Context, fetcher *metaFetcher) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case key := <-metaQueue:
+			fetchLinkMeta(ctx, fetcher, key)
+		}
+	}
+}
+
+// Загрузка метаданных одной ссылки. Поля, которые владелец уже заполнил,
+// не перезаписываются.
+func fetchLinkMeta(ctx context.Context, fetcher *metaFetcher, key linkKey) {
+	mutex.RLock()
+	link, exists := links[key]
+	var target string
+	if exists {
+		target = link.webURL()
+	}
+	mutex.RUnlock()
+	if !exists || target == "" {
+		return
+	}
+
+	meta, err := fetcher.fetch(ctx, target)
+	if err != nil {
+		logEvent(slog.LevelDebug, "metadata_fetch_failed", "short_code", key.Code, "domain", key.Domain, "error", err)
+		return
+	}
+
+	mutex.Lock()
+	changed := false
+	// Ссылку могли удалить, пока шла загрузка
+	if links[key] == link {
+		changed = fillEmpty(&link.Title, meta.Title)
+		changed = fillEmpty(&link.Description, meta.Description) || changed
+		changed = fillEmpty(&link.Favicon, meta.Favicon) || changed
+		if changed {
+			indexLink(key, link)
+		}
+	}
+	mutex.Unlock()
+
+	if changed {
+		markDirty()
+		logEvent(slog.LevelDebug, "metadata_fetched", "short_code", key.Code, "domain", key.Domain)
+	}
+}
+
+func fillEmpty(field *string, value string) bool {
+	if *field != "" || value == "" {
+		return false
+	}
+	*field = value
+	return true
+}
+
+// Изменение названия, описания, значка, карточки превью, передачи
+// параметров, правил перенаправления, вариантов A/B-теста, запасного
+// адреса диплинка и правил уведомлений владельцем (POST /edit/<code>?domain=).
+// Меняются только поля, присланные формой: остальные остаются как были.
+func editHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/my", http.StatusFound)
+		return
+	}
+	key := linkKey{r.URL.Query().Get("domain"), strings.TrimPrefix(r.URL.Path, "/edit/")}
+	ip := getIP(r)
+	r.ParseForm()
+	form := r.PostForm
+	has := func(name string) bool {
+		_, ok := form[name]
+		return ok
+	}
+
+	var rules []redirectRule
+	var err error
+	if has("rules") {
+		if rules, err = parseRules(form.Get("rules")); err != nil {
+			http.Error(w, localeFrom(r).T("error.bad_rules", errorText(localeFrom(r), err)), http.StatusBadRequest)
+			return
+		}
+	}
+	var fallback string
+	if has("fallback") {
+		if fallback, err = normalizeFallback(form.Get("fallback")); err != nil {
+			http.Error(w, localeFrom(r).T("error.bad_fallback", errorText(localeFrom(r), err)), http.StatusBadRequest)
+			return
+		}
+	}
+
+	mutex.Lock()
+	link, exists := links[key]
+	changed := exists && link.IP == ip
+	if changed {
+		// Варианты и уведомления разбираются под блокировкой (счетчики
+		// переносятся со старых), но ссылка меняется только после проверки всех полей
+		variants := link.Variants
+		if has("variants") {
+			if variants, err = parseVariants(form.Get("variants"), link.Variants); err != nil {
+				mutex.Unlock()
+				http.Error(w, localeFrom(r).T("error.bad_variants", errorText(localeFrom(r), err)), http.StatusBadRequest)
+				return
+			}
+		}
+		alerts := link.Alerts
+		if config.Features.Notifications && has("alerts") {
+			if alerts, err = parseAlerts(form.Get("alerts"), link.Alerts, link.IP); err != nil {
+				mutex.Unlock()
+				http.Error(w, localeFrom(r).T("error.bad_alerts", errorText(localeFrom(r), err)), http.StatusBadRequest)
+				return
+			}
+		}
+		link.Variants, link.Alerts = variants, alerts
+		if has("fallback") && isDeepLink(link.OriginalURL) {
+			link.Fallback = fallback
+		}
+		if has("rules") {
+			link.Rules = rules
+		}
+		setText := func(field *string, name string, limit int) {
+			if has(name) {
+				*field = cleanText(form.Get(name), limit)
+			}
+		}
+		setImage := func(field *string, name string) {
+			if has(name) {
+				*field = resolveImageURL(form.Get(name), nil)
+			}
+		}
+		setText(&link.Title, "title", maxTitleLength)
+		setText(&link.Description, "description", maxDescriptionLength)
+		setImage(&link.Favicon, "favicon")
+		setText(&link.OGTitle, "og_title", maxTitleLength)
+		setText(&link.OGDescription, "og_description", maxDescriptionLength)
+		setImage(&link.OGImage, "og_image")
+		// Флажок: перед ним в форме стоит скрытое поле forward_query=0,
+		// поэтому снятый флажок тоже присылает поле
+		if has("forward_query") {
+			link.ForwardQuery = slices.Contains(form["forward_query"], "1")
+		}
+		indexLink(key, link)
+	}
+	mutex.Unlock()
+
+	if changed {
+		markDirty()
+		logEvent(slog.LevelInfo, "link_edited", "short_code", key.Code, "domain", key.Domain, "ip", ip)
+	}
+	http.Redirect(w, r, "/my", http.StatusFound)
+}
+
+// Адрес формы изменения в кабинете
+func editURL(domain, code string) string {
+	return "/edit/" + code + "?domain=" + url.QueryEscape(domain)
+}
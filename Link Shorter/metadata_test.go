@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPage = `<!DOCTYPE html>
+<html><head>
+<meta charset="utf-8">
+<title>  Обычный
+  заголовок </title>
+<meta property="og:title" content="Заголовок &amp; OpenGraph">
+<meta name="description" content='Описание страницы'>
+<link rel="shortcut icon" href="/img/icon.png">
+</head><body><title>не этот</title></body></html>`
+
+func TestMetaFetcherReadsPage(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/page", http.StatusMovedPermanently)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(testPage))
+	}))
+	defer srv.Close()
+
+	f := newMetaFetcher(time.Second, 64<<10, true)
+	meta, err := f.fetch(context.Background(), srv.URL+"/old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := pageMeta{
+		Title:       "Заголовок & OpenGraph",
+		Description: "Описание страницы",
+		Favicon:     srv.URL + "/img/icon.png",
+	}
+	if meta != want {
+		t.Fatalf("получено %+v, ожидалось %+v", meta, want)
+	}
+}
+
+func TestMetaFetcherRejectsPrivateAddress(t *testing.T) {
+	requested := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+	}))
+	defer srv.Close()
+
+	f := newMetaFetcher(time.Second, 64<<10, false)
+	_, err := f.fetch(context.Background(), srv.URL)
+	if !errors.Is(err, errPrivateAddress) {
+		t.Fatalf("ошибка = %v, ожидался отказ для внутреннего адреса", err)
+	}
+	if requested {
+		t.Fatal("запрос не должен доходить до сервера")
+	}
+}
+
+func TestMetaFetcherLimits(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			time.Sleep(500 * time.Millisecond)
+		case "/json":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"title": "нет"}`))
+		case "/large":
+			// Заголовок после лимита чтения не виден
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html><head>" + strings.Repeat(" ", 4096) + "<title>далеко</title></head></html>"))
+		case "/missing":
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	f := newMetaFetcher(100*time.Millisecond, 1024, true)
+	for _, path := range []string{"/slow", "/json", "/missing"} {
+		if _, err := f.fetch(context.Background(), srv.URL+path); err == nil {
+			t.Errorf("%s: ожидалась ошибка", path)
+		}
+	}
+	meta, err := f.fetch(context.Background(), srv.URL+"/large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Title != "" {
+		t.Fatalf("прочитано больше лимита: %q", meta.Title)
+	}
+	if _, err := f.fetch(context.Background(), "file:///etc/passwd"); err == nil {
+		t.Fatal("схема file должна отклоняться")
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	for addr, public := range map[string]bool{
+		"93.184.216.34":   true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"::1":             false,
+		"fd00::1":         false,
+		"fe80::1":         false,
+		"::ffff:10.0.0.1": false,
+	} {
+		if got := isPublicIP(net.ParseIP(addr)); got != public {
+			t.Errorf("isPublicIP(%s) = %v, ожидалось %v", addr, got, public)
+		}
+	}
+}
+
+func TestFetchLinkMetaKeepsOwnerFields(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(testPage))
+	}))
+	defer srv.Close()
+
+	seedLinks(t, 0)
+	searchDocs = make(map[linkKey]string)
+	searchGrams = make(map[string]map[linkKey]struct{})
+	key := linkKey{"", "meta"}
+	links[key] = &Link{OriginalURL: srv.URL, ShortCode: key.Code, Title: "Свое название"}
+
+	fetchLinkMeta(context.Background(), newMetaFetcher(time.Second, 64<<10, true), key)
+
+	link := links[key]
+	if link.Title != "Свое название" || link.Description != "Описание страницы" {
+		t.Fatalf("название %q, описание %q", link.Title, link.Description)
+	}
+	if q := newSearchQuery("свое"); !q.match(key) {
+		t.Fatal("название не попало в поисковый индекс")
+	}
+}
+
+// Ошибка в любом поле формы не меняет ссылку частично
+func TestEditHandlerValidatesBeforeChanging(t *testing.T) {
+	resetState()
+	config.Storage.Backend = "memory"
+	config.Features.Notifications = true
+	if err := loadLocales(); err != nil {
+		t.Fatal(err)
+	}
+	key := linkKey{"", "edit1"}
+	link := &Link{ShortCode: key.Code, OriginalURL: "https://example.com", IP: "192.0.2.1", Title: "Старое"}
+	links[key] = link
+
+	form := url.Values{
+		"title":    {"Новое"},
+		"variants": {"50 https://example.com/a\n50 https://example.com/b"},
+		"alerts":   {"threshold много -> feed"},
+	}
+	r := httptest.NewRequest(http.MethodPost, editURL(key.Domain, key.Code), strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = "192.0.2.1:5000"
+	w := httptest.NewRecorder()
+	editHandler(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("код %d, ожидался 400", w.Code)
+	}
+	if link.Title != "Старое" || link.Variants != nil || link.Alerts != nil {
+		t.Errorf("ссылка изменена частично: %q, %d вариантов, %d правил", link.Title, len(link.Variants), len(link.Alerts))
+	}
+}
+
+// Форма меняет только присланные поля
+func TestEditHandlerUpdatesPresentFields(t *testing.T) {
+	resetState()
+	config.Storage.Backend = "memory"
+	key := linkKey{"", "edit2"}
+	link := &Link{
+		ShortCode:   key.Code,
+		OriginalURL: "https://example.com",
+		IP:          "192.0.2.1",
+		Title:       "Название",
+		Description: "Описание",
+		Favicon:     "https://example.com/icon.png",
+	}
+	links[key] = link
+
+	edit := func(form url.Values) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodPost, editURL(key.Domain, key.Code), strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.RemoteAddr = "192.0.2.1:5000"
+		w := httptest.NewRecorder()
+		editHandler(w, r)
+		if w.Code != http.StatusFound {
+			t.Fatalf("код %d: %s", w.Code, w.Body)
+		}
+	}
+
+	edit(url.Values{"title": {"  Новое  название "}})
+	if link.Title != "Новое название" || link.Description != "Описание" || link.Favicon != "https://example.com/icon.png" {
+		t.Errorf("после изменения названия: %q, %q, %q", link.Title, link.Description, link.Favicon)
+	}
+
+	// Снятый флажок присылает только скрытое поле
+	edit(url.Values{"forward_query": {"0"}})
+	if link.ForwardQuery || link.Title != "Новое название" {
+		t.Errorf("снятый флажок: forward_query %v, название %q", link.ForwardQuery, link.Title)
+	}
+	edit(url.Values{"forward_query": {"0", "1"}})
+	if !link.ForwardQuery {
+		t.Error("отмеченный флажок не включил forward_query")
+	}
+
+	// Пустое присланное поле очищает значение
+	edit(url.Values{"description": {""}, "favicon": {""}})
+	if link.Description != "" || link.Favicon != "" || link.Title != "Новое название" {
+		t.Errorf("после очистки: %q, %q, %q", link.Description, link.Favicon, link.Title)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Метрики в текстовом формате Prometheus. Счетчики атомарные,
+// поэтому учет не берет блокировок на горячем пути редиректа.
+
+type metricCounter struct {
+	n atomic.Uint64
+}
+
+func (c *metricCounter) Inc() {
+	c.n.Add(1)
+}
+
+func (c *metricCounter) Load() uint64 {
+	return c.n.Load()
+}
+
+// Гистограмма с фиксированными границами корзин (в секундах)
+type metricHistogram struct {
+	bounds  []float64
+	buckets []atomic.Uint64 // последняя корзина - +Inf
+	sumBits atomic.Uint64   // float64 сумма в битовом представлении
+}
+
+func newHistogram(bounds ...float64) *metricHistogram {
+	return &metricHistogram{
+		bounds:  bounds,
+		buckets: make([]atomic.Uint64, len(bounds)+1),
+	}
+}
+
+func (h *metricHistogram) Observe(d time.Duration) {
+	v := d.Seconds()
+	i := 0
+	for i < len(h.bounds) && v > h.bounds[i] {
+		i++
+	}
+	h.buckets[i].Add(1)
+	for {
+		old := h.sumBits.Load()
+		sum := math.Float64frombits(old) + v
+		if h.sumBits.CompareAndSwap(old, math.Float64bits(sum)) {
+			return
+		}
+	}
+}
+
+var (
+	redirectDuration  = newHistogram(0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.05)
+	saveDuration      = newHistogram(0.001, 0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 5)
+	redirects         metricCounter
+	linksCreated      metricCounter
+	unknownCodes      metricCounter
+	saveFailures      metricCounter
+	rateLimitRejected metricCounter
+	eventsDropped     metricCounter
+	webhooksDelivered metricCounter
+	webhooksFailed    metricCounter
+)
+
+// Обработчик /metrics
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	writeCounter(w, "linkshorter_redirects_total", "Переходы по коротким ссылкам.", redirects.Load())
+	writeHistogram(w, "linkshorter_redirect_duration_seconds", "Время обработки перехода по короткой ссылке.", redirectDuration)
+	writeCounter(w, "linkshorter_links_created_total", "Созданные ссылки.", linksCreated.Load())
+	writeCounter(w, "linkshorter_unknown_code_total", "Запросы несуществующих коротких кодов (404).", unknownCodes.Load())
+	writeHistogram(w, "linkshorter_db_save_duration_seconds", "Время сохранения базы данных.", saveDuration)
+	writeCounter(w, "linkshorter_db_save_failures_total", "Неудачные сохранения базы данных.", saveFailures.Load())
+	writeCounter(w, "linkshorter_rate_limited_total", "Запросы, отклоненные ограничением частоты.", rateLimitRejected.Load())
+	writeCounter(w, "linkshorter_events_dropped_total", "События ссылок, потерянные из-за переполнения очереди.", eventsDropped.Load())
+	writeCounter(w, "linkshorter_webhooks_delivered_total", "Доставленные вебхуки.", webhooksDelivered.Load())
+	writeCounter(w, "linkshorter_webhook_failures_total", "Неудачные попытки доставки вебхуков.", webhooksFailed.Load())
+
+	mutex.RLock()
+	count := len(links)
+	mutex.RUnlock()
+	writeGauge(w, "linkshorter_links", "Ссылки в памяти.", float64(count))
+
+	if config.Storage.Backend == "json" {
+		if info, err := os.Stat(config.Storage.Path); err == nil {
+			writeGauge(w, "linkshorter_db_file_size_bytes", "Размер файла базы данных.", float64(info.Size()))
+		}
+	}
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeCounter(w io.Writer, name, help string, value uint64) {
+	writeHeader(w, name, help, "counter")
+	fmt.Fprintf(w, "%s %d\n", name, value)
+}
+
+func writeGauge(w io.Writer, name, help string, value float64) {
+	writeHeader(w, name, help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(value))
+}
+
+func writeHistogram(w io.Writer, name, help string, h *metricHistogram) {
+	writeHeader(w, name, help, "histogram")
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.buckets[i].Load()
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, formatFloat(bound), cumulative)
+	}
+	cumulative += h.buckets[len(h.bounds)].Load()
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, cumulative)
+	fmt.Fprintf(w, "%s_sum %s\n", name, formatFloat(math.Float64frombits(h.sumBits.Load())))
+	fmt.Fprintf(w, "%s_count %d\n", name, cumulative)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
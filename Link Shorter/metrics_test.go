@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Корзины гистограммы накопительные: каждая включает все меньшие
+func TestHistogramCumulativeBuckets(t *testing.T) {
+	h := newHistogram(0.001, 0.01, 0.1)
+	for _, d := range []time.Duration{
+		500 * time.Microsecond,
+		time.Millisecond, // граница попадает в свою корзину (le)
+		5 * time.Millisecond,
+		50 * time.Millisecond,
+		2 * time.Second,
+	} {
+		h.Observe(d)
+	}
+
+	var out strings.Builder
+	writeHistogram(&out, "test_duration_seconds", "Тест.", h)
+	for _, want := range []string{
+		`test_duration_seconds_bucket{le="0.001"} 2`,
+		`test_duration_seconds_bucket{le="0.01"} 3`,
+		`test_duration_seconds_bucket{le="0.1"} 4`,
+		`test_duration_seconds_bucket{le="+Inf"} 5`,
+		`test_duration_seconds_sum 2.0565`,
+		`test_duration_seconds_count 5`,
+	} {
+		if !strings.Contains(out.String(), want+"\n") {
+			t.Errorf("нет строки %q в\n%s", want, out.String())
+		}
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	seedLinks(t, 3)
+	w := httptest.NewRecorder()
+	metricsHandler(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type %q", w.Header().Get("Content-Type"))
+	}
+	for _, want := range []string{
+		"# TYPE linkshorter_redirects_total counter\n",
+		"# TYPE linkshorter_redirect_duration_seconds histogram\n",
+		"linkshorter_links 3\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("нет %q в ответе /metrics", want)
+		}
+	}
+}
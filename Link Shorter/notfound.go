@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Переходы по несуществующим кодам: по ним владельцы находят
+// опечатки в напечатанных ссылках и удаленные ссылки, которые еще ходят по сети
+var (
+	missMu sync.Mutex
+	misses = make(map[linkKey]*missEntry)
+)
+
+// Сколько разных несуществующих кодов помнить; реже всего запрошенные вытесняются
+const maxMisses = 1000
+
+// Индекс кодов для подсказок (под mutex): код лежит в двух корзинах -
+// по первым двум и по последним двум символам. Опечатка в одном символе
+// оставляет нетронутым хотя бы один край, поэтому такие коды находятся
+// всегда, а с двумя опечатками - если они не задели оба края. Подсказка
+// перебирает две корзины вместо всей базы.
+var codeIndex = make(map[codeAffix]map[string]struct{})
+
+type codeAffix struct {
+	domain string
+	suffix bool
+	affix  string
+}
+
+// Сколько кодов из корзин сравнивать, и как часто один IP получает подсказки
+const (
+	maxSuggestCandidates = 2000
+	suggestPerMinute     = 30
+	suggestBurst         = 10
+)
+
+var suggestLimiter = newRateLimiter()
+
+func codeAffixes(key linkKey) [2]codeAffix {
+	head, tail := key.Code, key.Code
+	if len(head) > 2 {
+		head, tail = head[:2], tail[len(tail)-2:]
+	}
+	return [2]codeAffix{{key.Domain, false, head}, {key.Domain, true, tail}}
+}
+
+// Добавление и удаление кода (вызывается под mutex)
+func indexCode(key linkKey) {
+	for _, a := range codeAffixes(key) {
+		codes := codeIndex[a]
+		if codes == nil {
+			codes = make(map[string]struct{})
+			codeIndex[a] = codes
+		}
+		codes[key.Code] = struct{}{}
+	}
+}
+
+func unindexCode(key linkKey) {
+	for _, a := range codeAffixes(key) {
+		if codes := codeIndex[a]; codes != nil {
+			delete(codes, key.Code)
+			if len(codes) == 0 {
+				delete(codeIndex, a)
+			}
+		}
+	}
+}
+
+// Полное построение индекса (вызывается под mutex после загрузки базы)
+func rebuildCodeIndex() {
+	codeIndex = make(map[codeAffix]map[string]struct{})
+	for key := range links {
+		indexCode(key)
+	}
+}
+
+type missEntry struct {
+	count    int
+	lastSeen time.Time
+}
+
+// Несуществующий код для страницы статистики
+type missStats struct {
+	ShortCode string
+	Domain    string
+	Count     int
+	LastSeen  time.Time
+}
+
+func recordMiss(key linkKey) {
+	missMu.Lock()
+	defer missMu.Unlock()
+
+	m := misses[key]
+	if m == nil {
+		if len(misses) >= maxMisses {
+			evictMiss()
+		}
+		m = &missEntry{}
+		misses[key] = m
+	}
+	m.count++
+	m.lastSeen = time.Now()
+	missesFile.markDirty()
+}
+
+// Вытеснение самого редкого (при равенстве - самого давнего) кода
+func evictMiss() {
+	var victim linkKey
+	var worst *missEntry
+	for key, m := range misses {
+		if worst == nil || m.count < worst.count || m.count == worst.count && m.lastSeen.Before(worst.lastSeen) {
+			victim, worst = key, m
+		}
+	}
+	delete(misses, victim)
+}
+
+// Код создан - больше не промах
+func forgetMiss(key linkKey) {
+	missMu.Lock()
+	if _, ok := misses[key]; ok {
+		delete(misses, key)
+		missesFile.markDirty()
+	}
+	missMu.Unlock()
+}
+
+// Промах в файле stats.misses_path
+type storedMiss struct {
+	Domain   string    `json:"domain,omitempty"`
+	Code     string    `json:"code"`
+	Count    int       `json:"count"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Загрузка промахов при старте
+func loadMisses() error {
+	if config.Storage.Backend != "json" {
+		return nil
+	}
+	data, err := os.ReadFile(config.Stats.MissesPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var list []storedMiss
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+
+	missMu.Lock()
+	defer missMu.Unlock()
+	misses = make(map[linkKey]*missEntry, len(list))
+	for _, m := range list {
+		if len(misses) < maxMisses && isShortCode(m.Code) && m.Count > 0 {
+			misses[linkKey{m.Domain, m.Code}] = &missEntry{m.Count, m.LastSeen}
+		}
+	}
+	return nil
+}
+
+// Сохранение промахов фоновым процессом вместе с базой
+func saveMisses() error {
+	if config.Storage.Backend != "json" {
+		return nil
+	}
+	missMu.Lock()
+	list := make([]storedMiss, 0, len(misses))
+	for key, m := range misses {
+		list = append(list, storedMiss{key.Domain, key.Code, m.count, m.lastSeen})
+	}
+	missMu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Count > list[j].Count })
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err == nil {
+		os.MkdirAll(filepath.Dir(config.Stats.MissesPath), 0755)
+		err = writeFileAtomic(config.Stats.MissesPath, data, 0644)
+	}
+	if err != nil {
+		logEvent(slog.LevelError, "misses_write_error", "path", config.Stats.MissesPath, "error", err)
+	}
+	return err
+}
+
+// Самые частые несуществующие коды и общее число промахов (пустой domain - по всем доменам)
+func topMisses(domain string, n int) ([]missStats, int) {
+	missMu.Lock()
+	defer missMu.Unlock()
+
+	var list []missStats
+	total := 0
+	for key, m := range misses {
+		if domain != "" && key.Domain != domain {
+			continue
+		}
+		total += m.count
+		list = append(list, missStats{key.Code, key.Domain, m.count, m.lastSeen})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].LastSeen.After(list[j].LastSeen)
+	})
+	if len(list) > n {
+		list = list[:n]
+	}
+	return list, total
+}
+
+// Похожие существующие коды того же домена: опечатка в одном-двух символах
+func suggestCodes(key linkKey, n int) []string {
+	maxDistance := 1
+	if len(key.Code) > 4 {
+		maxDistance = 2
+	}
+
+	type candidate struct {
+		code     string
+		distance int
+		visits   int
+	}
+	var candidates []candidate
+
+	mutex.RLock()
+	seen := make(map[string]bool)
+	for _, a := range codeAffixes(key) {
+		for code := range codeIndex[a] {
+			if seen[code] || len(seen) >= maxSuggestCandidates {
+				continue
+			}
+			seen[code] = true
+			if d := editDistance(key.Code, code, maxDistance); d <= maxDistance {
+				if link := links[linkKey{key.Domain, code}]; link != nil {
+					candidates = append(candidates, candidate{code, d, link.Visits.Load()})
+				}
+			}
+		}
+	}
+	mutex.RUnlock()
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		if candidates[i].visits != candidates[j].visits {
+			return candidates[i].visits > candidates[j].visits
+		}
+		return candidates[i].code < candidates[j].code
+	})
+	var result []string
+	for i := 0; i < len(candidates) && i < n; i++ {
+		result = append(result, candidates[i].code)
+	}
+	return result
+}
+
+// Расстояние Левенштейна с учетом регистра. Если расстояние заведомо
+// больше limit, возвращается limit+1 без полного подсчета.
+func editDistance(a, b string, limit int) int {
+	if abs(len(a)-len(b)) > limit {
+		return limit + 1
+	}
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin > limit {
+			return limit + 1
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Страница 404 для несуществующего кода
+func renderNotFound(w http.ResponseWriter, r *http.Request) {
+	code := strings.TrimPrefix(r.URL.Path, "/")
+	data := notFoundPage{
+		page: newPage(r, "notfound"),
+		Code: code,
+	}
+
+	// Подсказки и учет только для путей, похожих на код.
+	// Подсказки ограничены по частоте: перебор кодов дороже самой страницы.
+	if isShortCode(code) {
+		key := linkKey{requestDomain(r), code}
+		recordMiss(key)
+		if suggestLimiter.allow(getIP(r), time.Now(), suggestPerMinute, suggestBurst) {
+			for _, c := range suggestCodes(key, 5) {
+				data.Suggestions = append(data.Suggestions, shortLinkURL(r, key.Domain, c))
+			}
+		}
+	}
+	renderPageStatus(w, r, http.StatusNotFound, "notfound", data)
+}
+
+func isShortCode(s string) bool {
+	if s == "" || len(s) > 64 {
+		return false
+	}
+	for _, ch := range s {
+		if !isCodeChar(ch) {
+			return false
+		}
+	}
+	return true
+}
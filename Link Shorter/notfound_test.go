@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEditDistance(t *testing.T) {
+	for _, tc := range []struct {
+		a, b        string
+		limit, want int
+	}{
+		{"abcdef", "abcdef", 2, 0},
+		{"abcdef", "abcdeg", 2, 1},
+		{"abcdef", "bcdef", 2, 1},
+		{"abcdef", "abcxdef", 2, 1},
+		{"abcdef", "abdcef", 2, 2},
+		{"abcdef", "AbcdeF", 2, 2}, // регистр учитывается
+		{"abcdef", "uvwxyz", 2, 3}, // дальше limit - limit+1
+		{"abcdef", "abc", 2, 3},
+		{"", "ab", 2, 2},
+		{"kitten", "sitting", 5, 3},
+	} {
+		if got := editDistance(tc.a, tc.b, tc.limit); got != tc.want {
+			t.Errorf("editDistance(%q, %q, %d) = %d, ожидалось %d", tc.a, tc.b, tc.limit, got, tc.want)
+		}
+	}
+}
+
+func TestSuggestCodes(t *testing.T) {
+	seedLinks(t, 0)
+	config.Storage.Backend = "memory"
+	add := func(domain, code string, visits int) {
+		key := linkKey{domain, code}
+		links[key] = &Link{OriginalURL: "https://example.com/" + code, ShortCode: code, Domain: domain}
+		links[key].Visits.Store(visits)
+		indexCode(key)
+	}
+	add("", "abcdef", 1)
+	add("", "abcdeg", 5)
+	add("", "xbcdef", 0)
+	add("", "abxxef", 0)
+	add("", "qwerty", 0)
+	add("x.co", "abcdez", 0)
+
+	for code, want := range map[string][]string{
+		"abcdex": {"abcdeg", "abcdef"}, // больше переходов - выше
+		"abxxeg": {"abxxef", "abcdeg"}, // ближе - выше
+		// Опечатка в начале ищется по концу кода; abcdeg отличается
+		// и первым, и последним символом, поэтому в корзины не попадает
+		"zbcdef": {"abcdef", "xbcdef"},
+		"qwerta": {"qwerty"},
+		"zzzzzz": nil,
+	} {
+		if got := suggestCodes(linkKey{"", code}, 5); !reflect.DeepEqual(got, want) {
+			t.Errorf("suggestCodes(%q) = %q, ожидалось %q", code, got, want)
+		}
+	}
+	if got := suggestCodes(linkKey{"", "abcdex"}, 1); !reflect.DeepEqual(got, []string{"abcdeg"}) {
+		t.Errorf("ограничение числа подсказок: %q", got)
+	}
+
+	// Удаленная ссылка из подсказок пропадает, код другого домена не предлагается
+	key := linkKey{"", "abcdeg"}
+	mutex.Lock()
+	delete(links, key)
+	unindexCode(key)
+	mutex.Unlock()
+	if got := suggestCodes(linkKey{"", "abcdez"}, 5); !reflect.DeepEqual(got, []string{"abcdef"}) {
+		t.Errorf("после удаления: %q", got)
+	}
+}
+
+func TestNotFoundSuggestionsRateLimited(t *testing.T) {
+	seedLinks(t, 1)
+	config.Storage.Backend = "memory"
+	if err := loadLocales(); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+
+	suggested := 0
+	for i := 0; i < suggestBurst+5; i++ {
+		w := httptest.NewRecorder()
+		renderNotFound(w, httptest.NewRequest(http.MethodGet, "/c00001", nil))
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("код %d", w.Code)
+		}
+		if strings.Contains(w.Body.String(), "/c00000") {
+			suggested++
+		}
+	}
+	if suggested != suggestBurst {
+		t.Errorf("подсказок показано %d раз, ожидалось %d", suggested, suggestBurst)
+	}
+	if list, total := topMisses("", 10); total != suggestBurst+5 || len(list) != 1 {
+		t.Errorf("промахи учитываются и без подсказок: %+v, всего %d", list, total)
+	}
+}
+
+func TestMissesPersisted(t *testing.T) {
+	resetState()
+	config.Stats.MissesPath = filepath.Join(t.TempDir(), "misses.json")
+	recordMiss(linkKey{"", "gone"})
+	recordMiss(linkKey{"", "gone"})
+	recordMiss(linkKey{"x.co", "typo"})
+	if err := missesFile.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	missMu.Lock()
+	misses = make(map[linkKey]*missEntry)
+	missMu.Unlock()
+	if err := loadMisses(); err != nil {
+		t.Fatal(err)
+	}
+	list, total := topMisses("", 10)
+	if total != 3 || len(list) != 2 || list[0].ShortCode != "gone" || list[0].Count != 2 || list[1].Domain != "x.co" {
+		t.Errorf("после загрузки %+v, всего %d", list, total)
+	}
+
+	// Созданный код перестает быть промахом, и это тоже сохраняется
+	forgetMiss(linkKey{"", "gone"})
+	if err := missesFile.flush(); err != nil {
+		t.Fatal(err)
+	}
+	loadMisses()
+	if _, total := topMisses("", 10); total != 1 {
+		t.Errorf("после forgetMiss всего %d", total)
+	}
+}
@@ -0,0 +1,690 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Уведомления о переходах: владелец задает ссылке правила (порог переходов,
+// всплеск за окно, пропажа переходов). Фоновая проверка раз в минуту
+// сравнивает их со счетчиками и отправляет уведомления в каналы правила:
+// ленту в /my, вебхук (событие link.alert) или письмо через локальный SMTP.
+
+const (
+	maxAlerts         = 10
+	maxAlertMinutes   = 24 * 60
+	maxFeedPerOwner   = 50
+	alertInterval     = time.Minute // шаг проверки и истории переходов
+	smtpTimeout       = 30 * time.Second
+	mailQueueSize     = 256
+	alertFeedOnMyPage = 10
+)
+
+const (
+	alertThreshold = "threshold" // переходов всего не меньше Visits
+	alertRate      = "rate"      // за последние Minutes минут не меньше Visits переходов
+	alertDrop      = "drop"      // Minutes минут без переходов, а до этого они были
+)
+
+// Правило уведомления. Срабатывает, когда условие становится выполненным,
+// и снова - только после того, как условие перестанет выполняться.
+type alertRule struct {
+	Kind     string   `json:"kind"`
+	Visits   int      `json:"visits,omitempty"`
+	Minutes  int      `json:"minutes,omitempty"`
+	Channels []string `json:"channels"`        // feed, webhook, email:адрес
+	Fired    bool     `json:"fired,omitempty"` // меняется под mutex.Lock
+}
+
+// Сработавшее правило
+type notification struct {
+	ID      string    `json:"id"`
+	Owner   string    `json:"owner"`
+	Domain  string    `json:"domain,omitempty"`
+	Code    string    `json:"code"`
+	URL     string    `json:"url"`
+	Kind    string    `json:"kind"`
+	Visits  int       `json:"visits"`            // порог из правила
+	Minutes int       `json:"minutes,omitempty"` // окно из правила
+	Count   int       `json:"count"`             // переходов всего или за окно
+	Time    time.Time `json:"time"`
+}
+
+// Канал доставки уведомлений. target - часть после двоеточия (email:адрес).
+type notifyChannel struct {
+	name    string
+	enabled func() bool
+	send    func(n notification, target string) error
+}
+
+var notifyChannels = []notifyChannel{
+	{"feed", func() bool { return true }, sendToFeed},
+	{"webhook", func() bool { return config.Features.Webhooks }, sendToWebhook},
+	{"email", func() bool { return config.Notify.SMTPAddr != "" && len(config.Notify.EmailAllow) > 0 }, queueEmail},
+}
+
+func findNotifyChannel(name string) *notifyChannel {
+	for i := range notifyChannels {
+		if notifyChannels[i].name == name {
+			return &notifyChannels[i]
+		}
+	}
+	return nil
+}
+
+// Адрес из списка notify.email_allow: сам адрес или его домен
+func emailAllowed(addr string) bool {
+	_, domain, _ := strings.Cut(addr, "@")
+	for _, allow := range config.Notify.EmailAllow {
+		if strings.EqualFold(allow, addr) || strings.EqualFold(allow, "@"+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// Есть ли у владельца вебхук на событие link.alert: без него уведомления
+// канала webhook никуда не уйдут
+func hasAlertWebhook(owner string) bool {
+	webhookMu.Lock()
+	defer webhookMu.Unlock()
+	for _, h := range webhooks.Hooks {
+		if h.Owner == owner && hasTag(h.Events, eventLinkAlert) {
+			return true
+		}
+	}
+	return false
+}
+
+// Проверка и приведение правила владельца owner к каноническому виду
+func (a *alertRule) normalize(owner string) error {
+	switch a.Kind {
+	case alertThreshold:
+		a.Minutes = 0
+	case alertRate, alertDrop:
+		if a.Minutes < 1 || a.Minutes > maxAlertMinutes {
+			return inputErr("input.bad_alert_window")
+		}
+		if a.Kind == alertDrop {
+			a.Visits = 0
+		}
+	default:
+		return inputErr("input.unknown_alert", a.Kind)
+	}
+	if a.Kind != alertDrop && a.Visits < 1 {
+		return inputErr("input.visits_not_positive")
+	}
+	if len(a.Channels) == 0 {
+		return inputErr("input.no_channel")
+	}
+	for i, ch := range a.Channels {
+		name, target, _ := strings.Cut(strings.TrimSpace(ch), ":")
+		name = strings.ToLower(name)
+		channel := findNotifyChannel(name)
+		switch {
+		case channel == nil:
+			return inputErr("input.unknown_channel", name)
+		case !channel.enabled():
+			return inputErr("input.channel_disabled", name)
+		case name == "email":
+			addr, err := mail.ParseAddress(target)
+			if err != nil {
+				return inputErr("input.bad_email", target)
+			}
+			if !emailAllowed(addr.Address) {
+				return inputErr("input.email_not_allowed", addr.Address)
+			}
+			target = addr.Address
+		case target != "":
+			return inputErr("input.channel_no_address", name)
+		case name == "webhook" && !hasAlertWebhook(owner):
+			return inputErr("input.no_alert_subscription", eventLinkAlert)
+		}
+		a.Channels[i] = name
+		if target != "" {
+			a.Channels[i] += ":" + target
+		}
+	}
+	return nil
+}
+
+func normalizeAlerts(alerts []*alertRule, owner string) error {
+	if len(alerts) > maxAlerts {
+		return inputErr("input.too_many_rules", maxAlerts)
+	}
+	for i, a := range alerts {
+		if a == nil {
+			return inputErr("input.rule", i+1, inputErr("input.empty_rule"))
+		}
+		a.Fired = false
+		if err := a.normalize(owner); err != nil {
+			return inputErr("input.rule", i+1, err)
+		}
+	}
+	return nil
+}
+
+// Правила в текстовом виде для формы кабинета, по одному на строку:
+//
+//	threshold 1000 -> feed, email:ops@example.com
+//	rate 500/10m -> webhook
+//	drop 30m -> feed
+//
+// Правило, оставшееся без изменений, сохраняет состояние: повторно
+// оно не сработает.
+func parseAlerts(text string, old []*alertRule, owner string) ([]*alertRule, error) {
+	var alerts []*alertRule
+	for lineNo, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		cond, channels, ok := strings.Cut(line, "->")
+		fields := strings.Fields(cond)
+		if !ok || len(fields) != 2 {
+			return nil, inputErr("input.line", lineNo+1, inputErr("input.alert_format"))
+		}
+		a := &alertRule{Kind: strings.ToLower(fields[0]), Channels: strings.Split(channels, ",")}
+		var err error
+		switch a.Kind {
+		case alertThreshold:
+			a.Visits, err = strconv.Atoi(fields[1])
+		case alertRate:
+			visits, window, _ := strings.Cut(fields[1], "/")
+			if a.Visits, err = strconv.Atoi(visits); err == nil {
+				a.Minutes, err = parseAlertWindow(window)
+			}
+		case alertDrop:
+			a.Minutes, err = parseAlertWindow(fields[1])
+		}
+		if err != nil {
+			return nil, inputErr("input.line", lineNo+1, inputErr("input.bad_alert_value", fields[1]))
+		}
+		if err := a.normalize(owner); err != nil {
+			return nil, inputErr("input.line", lineNo+1, err)
+		}
+		for _, o := range old {
+			if o.format() == a.format() {
+				a.Fired = o.Fired
+				break
+			}
+		}
+		alerts = append(alerts, a)
+	}
+	if len(alerts) > maxAlerts {
+		return nil, inputErr("input.too_many_rules", maxAlerts)
+	}
+	return alerts, nil
+}
+
+// Окно в минутах из 10m, 1h30m и т.п.
+func parseAlertWindow(s string) (int, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil || d%time.Minute != 0 {
+		return 0, inputErr("input.alert_window_unit", s)
+	}
+	return int(d / time.Minute), nil
+}
+
+func formatAlertWindow(minutes int) string {
+	if minutes%60 == 0 {
+		return strconv.Itoa(minutes/60) + "h"
+	}
+	return strconv.Itoa(minutes) + "m"
+}
+
+func (a *alertRule) format() string {
+	var cond string
+	switch a.Kind {
+	case alertThreshold:
+		cond = strconv.Itoa(a.Visits)
+	case alertRate:
+		cond = strconv.Itoa(a.Visits) + "/" + formatAlertWindow(a.Minutes)
+	case alertDrop:
+		cond = formatAlertWindow(a.Minutes)
+	}
+	return a.Kind + " " + cond + " -> " + strings.Join(a.Channels, ", ")
+}
+
+// Копия правил для ответа API (вызывается под mutex.RLock)
+func copyAlerts(alerts []*alertRule) []alertRule {
+	var list []alertRule
+	for _, a := range alerts {
+		list = append(list, *a)
+	}
+	return list
+}
+
+func formatAlerts(alerts []*alertRule) string {
+	var lines []string
+	for _, a := range alerts {
+		lines = append(lines, a.format())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// История счетчика переходов ссылки с шагом alertInterval
+type visitHistory []int
+
+// Переходы за n последних шагов; ok = false, пока истории не хватает
+func (h visitHistory) last(n int) (int, bool) {
+	if len(h) <= n {
+		return 0, false
+	}
+	return h[len(h)-1] - h[len(h)-1-n], true
+}
+
+// Переходы за n шагов, закончившиеся skip шагов назад
+func (h visitHistory) before(skip, n int) (int, bool) {
+	return h[:len(h)-skip].last(n)
+}
+
+// Выполнено ли условие; known = false, если для ответа мало истории
+func (a *alertRule) check(visits int, h visitHistory) (count int, met, known bool) {
+	switch a.Kind {
+	case alertThreshold:
+		return visits, visits >= a.Visits, true
+	case alertRate:
+		count, known = h.last(a.Minutes)
+		return count, known && count >= a.Visits, known
+	case alertDrop:
+		count, known = h.last(a.Minutes)
+		if !known || count > 0 {
+			return count, false, known
+		}
+		// Ссылка без переходов и раньше - не пропажа
+		earlier, known := h.before(a.Minutes, a.Minutes)
+		return count, known && earlier > 0, known
+	}
+	return 0, false, false
+}
+
+// Сколько шагов истории нужно правилам ссылки
+func historyLength(alerts []*alertRule) int {
+	n := 0
+	for _, a := range alerts {
+		switch a.Kind {
+		case alertRate:
+			n = max(n, a.Minutes+1)
+		case alertDrop:
+			n = max(n, 2*a.Minutes+1)
+		}
+	}
+	return n
+}
+
+// Истории ссылок с правилами. Меняет их горутина проверки, а читает
+// еще и сохранение базы, чтобы окна правил пережили перезапуск.
+var (
+	alertMu      sync.Mutex
+	alertHistory = make(map[linkKey]visitHistory)
+)
+
+// Копия историй для сохранения базы
+func alertHistorySnapshot() map[linkKey]visitHistory {
+	alertMu.Lock()
+	defer alertMu.Unlock()
+	snapshot := make(map[linkKey]visitHistory, len(alertHistory))
+	for key, h := range alertHistory {
+		snapshot[key] = append(visitHistory(nil), h...)
+	}
+	return snapshot
+}
+
+// Истории из базы при загрузке
+func restoreAlertHistory(histories map[linkKey]visitHistory) {
+	alertMu.Lock()
+	alertHistory = histories
+	alertMu.Unlock()
+}
+
+// Проверка всех правил (раз в alertInterval)
+func checkAlerts(now time.Time) {
+	type linkAlerts struct {
+		key    linkKey
+		owner  string
+		url    string
+		visits int
+		alerts []*alertRule
+		fired  []bool
+	}
+	var list []linkAlerts
+	mutex.RLock()
+	for key, link := range links {
+		if len(link.Alerts) == 0 {
+			continue
+		}
+		la := linkAlerts{key: key, owner: link.IP, url: link.OriginalURL, visits: link.Visits.Load(), alerts: link.Alerts}
+		for _, a := range link.Alerts {
+			la.fired = append(la.fired, a.Fired)
+		}
+		list = append(list, la)
+	}
+	mutex.RUnlock()
+
+	type change struct {
+		rule  *alertRule
+		fired bool
+	}
+	type pending struct {
+		n        notification
+		channels []string
+	}
+	var changes []change
+	var sent []pending
+	seen := make(map[linkKey]bool)
+	alertMu.Lock()
+	for _, la := range list {
+		seen[la.key] = true
+		history := alertHistory[la.key]
+		if keep := historyLength(la.alerts); keep > 0 {
+			history = append(history, la.visits)
+			if len(history) > keep {
+				history = append(visitHistory(nil), history[len(history)-keep:]...)
+			}
+			alertHistory[la.key] = history
+		} else {
+			delete(alertHistory, la.key)
+		}
+
+		for i, a := range la.alerts {
+			count, met, known := a.check(la.visits, history)
+			switch {
+			case !known || met == la.fired[i]:
+				continue
+			case met:
+				n := notification{
+					ID:      randomToken()[:16],
+					Owner:   la.owner,
+					Domain:  la.key.Domain,
+					Code:    la.key.Code,
+					URL:     la.url,
+					Kind:    a.Kind,
+					Visits:  a.Visits,
+					Minutes: a.Minutes,
+					Count:   count,
+					Time:    now,
+				}
+				sent = append(sent, pending{n, a.Channels})
+			}
+			changes = append(changes, change{a, met})
+		}
+	}
+	for key := range alertHistory {
+		if !seen[key] {
+			delete(alertHistory, key)
+		}
+	}
+	alertMu.Unlock()
+
+	if len(changes) > 0 {
+		mutex.Lock()
+		for _, c := range changes {
+			c.rule.Fired = c.fired
+		}
+		mutex.Unlock()
+		markDirty()
+	}
+	for _, p := range sent {
+		logEvent(slog.LevelInfo, "alert_fired", "short_code", p.n.Code, "domain", p.n.Domain, "kind", p.n.Kind, "count", p.n.Count)
+		deliverNotification(p.n, p.channels)
+	}
+}
+
+// Отправка во все каналы правила; ошибки канала не мешают остальным
+func deliverNotification(n notification, channels []string) {
+	for _, ch := range channels {
+		name, target, _ := strings.Cut(ch, ":")
+		channel := findNotifyChannel(name)
+		if channel == nil || !channel.enabled() {
+			continue
+		}
+		if err := channel.send(n, target); err != nil {
+			logEvent(slog.LevelWarn, "alert_send_failed", "channel", name, "short_code", n.Code, "error", err)
+		}
+	}
+}
+
+// Текст уведомления
+func (n notification) message(l *locale) string {
+	link := n.Code
+	if n.Domain != "" {
+		link = n.Domain + "/" + n.Code
+	}
+	switch n.Kind {
+	case alertRate:
+		return l.T("notify.rate", link, n.Count, formatAlertWindow(n.Minutes), n.Visits)
+	case alertDrop:
+		return l.T("notify.drop", link, formatAlertWindow(n.Minutes))
+	}
+	return l.T("notify.threshold", link, n.Count, n.Visits)
+}
+
+// Лента уведомлений владельцев
+var (
+	feedMu sync.Mutex
+	feed   []notification // старые в начале
+)
+
+func loadFeed() error {
+	if config.Storage.Backend != "json" {
+		return nil
+	}
+	data, err := os.ReadFile(config.Notify.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state struct {
+		Feed []notification `json:"feed"`
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	feedMu.Lock()
+	feed = state.Feed
+	feedMu.Unlock()
+	return nil
+}
+
+// Сохранение ленты (вызывается под feedMu); уведомления редкие,
+// поэтому файл пишется сразу
+func saveFeed() {
+	if config.Storage.Backend != "json" {
+		return
+	}
+	data, err := json.MarshalIndent(map[string][]notification{"feed": feed}, "", "  ")
+	if err == nil {
+		os.MkdirAll(filepath.Dir(config.Notify.Path), 0755)
+		err = writeFileAtomic(config.Notify.Path, data, 0600)
+	}
+	if err != nil {
+		logEvent(slog.LevelError, "notify_state_write_error", "path", config.Notify.Path, "error", err)
+	}
+}
+
+func sendToFeed(n notification, _ string) error {
+	feedMu.Lock()
+	defer feedMu.Unlock()
+	feed = append(feed, n)
+	count := 0
+	for i := len(feed) - 1; i >= 0; i-- {
+		if feed[i].Owner != n.Owner {
+			continue
+		}
+		if count++; count > maxFeedPerOwner {
+			feed = append(feed[:i], feed[i+1:]...)
+		}
+	}
+	saveFeed()
+	return nil
+}
+
+func sendToWebhook(n notification, _ string) error {
+	publishEvent(linkEvent{
+		Type:   eventLinkAlert,
+		Key:    linkKey{n.Domain, n.Code},
+		Owner:  n.Owner,
+		URL:    n.URL,
+		Visits: n.Count,
+		Time:   n.Time,
+		Alert:  &n,
+	})
+	return nil
+}
+
+// Письма отправляет отдельная горутина: медленный SMTP-релей не должен
+// задерживать проверку правил. Если очередь заполнена, письмо теряется.
+type mailJob struct {
+	n  notification
+	to string
+}
+
+var (
+	mailQueue        = make(chan mailJob, mailQueueSize)
+	errMailQueueFull = errors.New("очередь писем переполнена, письмо не отправлено")
+)
+
+func queueEmail(n notification, to string) error {
+	select {
+	case mailQueue <- mailJob{n, to}:
+		return nil
+	default:
+		return errMailQueueFull
+	}
+}
+
+// Отправка писем из очереди до остановки сервера
+func runMailer(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			if len(mailQueue) > 0 {
+				logEvent(slog.LevelWarn, "mail_unsent", "count", len(mailQueue))
+			}
+			return
+		case job := <-mailQueue:
+			if err := sendEmail(job.n, job.to); err != nil {
+				logEvent(slog.LevelWarn, "alert_send_failed", "channel", "email", "short_code", job.n.Code, "error", err)
+			}
+		}
+	}
+}
+
+// Письмо через SMTP-релей без авторизации и шифрования (notify.smtp_addr),
+// обычно локальный
+func sendEmail(n notification, to string) error {
+	// Список могли сократить после сохранения правила
+	if !emailAllowed(to) {
+		return fmt.Errorf("адрес %s больше не разрешен (notify.email_allow)", to)
+	}
+	l := locales[config.I18n.DefaultLanguage]
+	subject := l.T("notify.subject", n.Code)
+	body := n.message(l) + "\r\n\r\n" + n.URL + "\r\n"
+	msg := "From: " + config.Notify.SMTPFrom + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n" +
+		"Date: " + n.Time.Format(time.RFC1123Z) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Transfer-Encoding: 8bit\r\n\r\n" + body
+
+	conn, err := net.DialTimeout("tcp", config.Notify.SMTPAddr, smtpTimeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+	host, _, _ := net.SplitHostPort(config.Notify.SMTPAddr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if err := c.Mail(config.Notify.SMTPFrom); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// Уведомление в ленте кабинета
+type feedItem struct {
+	Time    time.Time
+	Message string
+	Kind    string
+}
+
+// Последние уведомления владельца, новые сверху
+func ownerFeed(r *http.Request, owner string, limit int) []feedItem {
+	l := localeFrom(r)
+	var items []feedItem
+	feedMu.Lock()
+	for i := len(feed) - 1; i >= 0 && len(items) < limit; i-- {
+		if n := feed[i]; n.Owner == owner {
+			items = append(items, feedItem{Time: n.Time, Message: n.message(l), Kind: n.Kind})
+		}
+	}
+	feedMu.Unlock()
+	return items
+}
+
+// Очистка ленты владельца (POST /my/notifications/clear)
+func clearFeedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		ip := getIP(r)
+		feedMu.Lock()
+		kept := feed[:0]
+		for _, n := range feed {
+			if n.Owner != ip {
+				kept = append(kept, n)
+			}
+		}
+		feed = kept
+		saveFeed()
+		feedMu.Unlock()
+	}
+	http.Redirect(w, r, "/my", http.StatusFound)
+}
+
+// Проверка правил до остановки сервера
+func runAlerts(ctx context.Context) {
+	ticker := time.NewTicker(alertInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			checkAlerts(now)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAlerts(t *testing.T) {
+	resetState()
+	config.Features.Webhooks = true
+	webhooks.Hooks = []*webhook{{ID: "h1", Owner: "192.0.2.1", URL: "https://example.com/hook", Events: []string{eventLinkAlert}}}
+	config.Notify.SMTPAddr = "127.0.0.1:25"
+	config.Notify.EmailAllow = []string{"@example.com", "boss@corp.example"}
+	text := "threshold 1000 -> feed, email:owner@example.com\nrate 500/10m -> webhook\n\ndrop 2h -> feed"
+	alerts, err := parseAlerts(text, []*alertRule{{Kind: alertThreshold, Visits: 1000, Channels: []string{"feed", "email:owner@example.com"}, Fired: true}}, "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(alerts) != 3 || alerts[1].Visits != 500 || alerts[1].Minutes != 10 || alerts[2].Minutes != 120 {
+		t.Fatalf("разобрано %+v", alerts)
+	}
+	if !alerts[0].Fired {
+		t.Error("состояние неизмененного правила потеряно")
+	}
+	want := "threshold 1000 -> feed, email:owner@example.com\nrate 500/10m -> webhook\ndrop 2h -> feed"
+	if got := formatAlerts(alerts); got != want {
+		t.Errorf("formatAlerts = %q, ожидалось %q", got, want)
+	}
+
+	for _, bad := range []string{
+		"threshold 0 -> feed",
+		"threshold 10",
+		"rate 10 -> feed",
+		"rate 10/90s -> feed",
+		"drop 48h -> feed",
+		"spike 10 -> feed",
+		"threshold 10 -> sms",
+		"threshold 10 -> email:not-an-address",
+		"threshold 10 -> email:victim@elsewhere.example",
+		"threshold 10 -> email:other@corp.example",
+	} {
+		if _, err := parseAlerts(bad, nil, "192.0.2.1"); err == nil {
+			t.Errorf("parseAlerts(%q): ожидалась ошибка", bad)
+		}
+	}
+	if _, err := parseAlerts("threshold 10 -> email:BOSS@corp.example", nil, "192.0.2.1"); err != nil {
+		t.Errorf("адрес из notify.email_allow не принят: %v", err)
+	}
+
+	// Канал webhook - только при подписке владельца на link.alert
+	if _, err := parseAlerts("threshold 10 -> webhook", nil, "192.0.2.2"); err == nil {
+		t.Error("канал webhook принят без подписки на link.alert")
+	}
+	webhooks.Hooks[0].Events = []string{eventLinkCreated}
+	if _, err := parseAlerts("threshold 10 -> webhook", nil, "192.0.2.1"); err == nil {
+		t.Error("канал webhook принят с подпиской на другое событие")
+	}
+
+	// Без списка адресов канал email выключен
+	config.Notify.EmailAllow = nil
+	if _, err := parseAlerts("threshold 10 -> email:owner@example.com", nil, "192.0.2.1"); err == nil {
+		t.Error("канал email работает без notify.email_allow")
+	}
+}
+
+func TestAlertCheck(t *testing.T) {
+	rate := &alertRule{Kind: alertRate, Visits: 5, Minutes: 2}
+	drop := &alertRule{Kind: alertDrop, Minutes: 2}
+	for _, tc := range []struct {
+		rule       *alertRule
+		history    visitHistory
+		met, known bool
+	}{
+		{rate, visitHistory{0, 10}, false, false},
+		{rate, visitHistory{0, 2, 4}, false, true},
+		{rate, visitHistory{0, 2, 6}, true, true},
+		{drop, visitHistory{0, 5, 5, 5}, false, false},
+		{drop, visitHistory{0, 3, 5, 5, 5}, true, true},
+		{drop, visitHistory{5, 5, 5, 5, 5}, false, true},
+		{drop, visitHistory{0, 3, 5, 5, 6}, false, true},
+	} {
+		_, met, known := tc.rule.check(tc.history[len(tc.history)-1], tc.history)
+		if met != tc.met || known != tc.known {
+			t.Errorf("%s по %v: выполнено %v, известно %v", tc.rule.format(), tc.history, met, known)
+		}
+	}
+}
+
+func TestCheckAlertsFeed(t *testing.T) {
+	seedLinks(t, 1)
+	config.Storage.Backend = "memory"
+	if err := loadLocales(); err != nil {
+		t.Fatal(err)
+	}
+	feed, alertHistory = nil, make(map[linkKey]visitHistory)
+	link := links[linkKey{"", "c00000"}]
+	link.IP = "1.2.3.4"
+	link.Alerts = []*alertRule{{Kind: alertThreshold, Visits: 3, Channels: []string{"feed"}}}
+
+	now := time.Now()
+	for visits := 1; visits <= 5; visits++ {
+		link.Visits.Store(visits)
+		checkAlerts(now.Add(time.Duration(visits) * alertInterval))
+	}
+	if len(feed) != 1 || feed[0].Owner != "1.2.3.4" || feed[0].Count != 3 || !link.Alerts[0].Fired {
+		t.Fatalf("лента %+v", feed)
+	}
+	msg := feed[0].message(locales["en"])
+	if !strings.Contains(msg, "c00000") || !strings.Contains(msg, "threshold 3") {
+		t.Errorf("текст уведомления %q", msg)
+	}
+}
+
+// История для правил скорости и спада переживает перезапуск
+func TestAlertHistoryPersisted(t *testing.T) {
+	seedLinks(t, 1)
+	config.Storage.Path = filepath.Join(t.TempDir(), "links.json")
+	dbLoaded.Store(true)
+	link := links[linkKey{"", "c00000"}]
+	link.Alerts = []*alertRule{{Kind: alertDrop, Minutes: 120, Channels: []string{"feed"}}}
+
+	now := time.Now()
+	for visits := 1; visits <= 3; visits++ {
+		link.Visits.Store(visits)
+		checkAlerts(now.Add(time.Duration(visits) * alertInterval))
+	}
+	want := alertHistorySnapshot()[linkKey{"", "c00000"}]
+	if len(want) != 3 {
+		t.Fatalf("история до сохранения: %v", want)
+	}
+	if err := saveDatabase(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := config.Storage.Path
+	resetState()
+	config.Storage.Path = path
+	if err := loadDatabase(); err != nil {
+		t.Fatal(err)
+	}
+	if got := alertHistorySnapshot()[linkKey{defaultDomain(), "c00000"}]; !reflect.DeepEqual(got, want) {
+		t.Errorf("история после загрузки: %v, ожидалось %v", got, want)
+	}
+}
+
+// Письма уходят из очереди в фоне; лишние при полной очереди теряются
+func TestMailQueue(t *testing.T) {
+	resetState()
+	if err := loadLocales(); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan string, 1)
+	go fakeSMTP(ln, received)
+
+	config.Notify.SMTPAddr = ln.Addr().String()
+	config.Notify.EmailAllow = []string{"@example.com"}
+	n := notification{Code: "abc", URL: "https://example.com", Kind: alertThreshold, Visits: 10, Count: 10, Time: time.Now()}
+
+	for i := 0; i < mailQueueSize; i++ {
+		if err := queueEmail(n, "owner@example.com"); err != nil {
+			t.Fatalf("письмо %d: %v", i+1, err)
+		}
+	}
+	if err := queueEmail(n, "owner@example.com"); err != errMailQueueFull {
+		t.Errorf("переполненная очередь: %v", err)
+	}
+	for len(mailQueue) > 1 {
+		<-mailQueue
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runMailer(ctx)
+		close(done)
+	}()
+	select {
+	case rcpt := <-received:
+		if rcpt != "owner@example.com" {
+			t.Errorf("письмо для %q", rcpt)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("письмо не отправлено")
+	}
+	cancel()
+	<-done
+}
+
+// Минимальный SMTP-сервер на одно письмо: сообщает получателя
+func fakeSMTP(ln net.Listener, received chan<- string) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	fmt.Fprint(conn, "220 test\r\n")
+	var rcpt string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			rcpt = strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<>")
+			fmt.Fprint(conn, "250 ok\r\n")
+		case cmd == "DATA":
+			fmt.Fprint(conn, "354 go\r\n")
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+			}
+			fmt.Fprint(conn, "250 ok\r\n")
+			received <- rcpt
+		case cmd == "QUIT":
+			fmt.Fprint(conn, "221 bye\r\n")
+			return
+		default:
+			fmt.Fprint(conn, "250 ok\r\n")
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Превью ссылок в мессенджерах и соцсетях. Боты, разворачивающие ссылку
+// в карточку, получают страницу с тегами OpenGraph вместо перенаправления;
+// браузеры по-прежнему получают 302.
+
+// Подстроки User-Agent ботов превью. Поисковые роботы сюда не входят:
+// им нужно обычное перенаправление на страницу назначения.
+var previewBots = []string{
+	"facebookexternalhit",
+	"facebot",
+	"twitterbot",
+	"slackbot",
+	"telegrambot",
+	"whatsapp",
+	"discordbot",
+	"linkedinbot",
+	"skypeuripreview",
+	"vkshare",
+	"pinterest",
+	"redditbot",
+	"mattermost",
+	"embedly",
+	"iframely",
+	"viber",
+}
+
+func isPreviewBot(userAgent string) bool {
+	ua := strings.ToLower(userAgent)
+	for _, bot := range previewBots {
+		if strings.Contains(ua, bot) {
+			return true
+		}
+	}
+	return false
+}
+
+// Содержимое карточки: поля OpenGraph владельца, а без них - название
+// и описание ссылки
+type linkPreview struct {
+	Title       string
+	Description string
+	Image       string
+	Target      string
+}
+
+// Карточка ссылки (вызывается под mutex.RLock); ok = false, если показать нечего
+// и боту лучше пройти по перенаправлению к самой странице
+func previewOf(link *Link) (linkPreview, bool) {
+	p := linkPreview{
+		Title:       firstNonEmpty(link.OGTitle, link.Title),
+		Description: firstNonEmpty(link.OGDescription, link.Description),
+		Image:       link.OGImage,
+		Target:      link.webURL(),
+	}
+	return p, p.Target != "" && (link.OGTitle != "" || link.OGDescription != "" || link.OGImage != "")
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func renderPreview(w http.ResponseWriter, r *http.Request, key linkKey, p linkPreview) {
+	data := previewPage{
+		page:        newPage(r, "preview"),
+		URL:         shortLinkURL(r, key.Domain, key.Code),
+		Description: p.Description,
+		Image:       p.Image,
+		Target:      p.Target,
+	}
+	if p.Title != "" {
+		data.Title = p.Title
+		data.Heading = p.Title
+	}
+	renderPage(w, r, "preview", data)
+}
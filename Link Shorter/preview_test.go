@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIsPreviewBot(t *testing.T) {
+	for ua, expected := range map[string]bool{
+		"facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)": true,
+		"TelegramBot (like TwitterBot)":                                             true,
+		"Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)":                true,
+		"WhatsApp/2.23.20.0": true,
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/120.0": false,
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)":  false,
+		"": false,
+	} {
+		if got := isPreviewBot(ua); got != expected {
+			t.Errorf("isPreviewBot(%q) = %v, ожидалось %v", ua, got, expected)
+		}
+	}
+}
+
+// Без полей OpenGraph карточки нет; заполненное поле дополняется
+// названием и описанием ссылки
+func TestPreviewOfFallback(t *testing.T) {
+	link := &Link{OriginalURL: "https://example.com", Title: "Название", Description: "Описание"}
+	if _, ok := previewOf(link); ok {
+		t.Error("карточка без полей OpenGraph")
+	}
+
+	link.OGImage = "https://example.com/card.png"
+	p, ok := previewOf(link)
+	if !ok || p.Title != "Название" || p.Description != "Описание" || p.Image != link.OGImage || p.Target != link.OriginalURL {
+		t.Errorf("карточка с картинкой: %+v, %v", p, ok)
+	}
+
+	link.OGTitle, link.OGDescription = "Заголовок карточки", "Текст карточки"
+	if p, _ := previewOf(link); p.Title != "Заголовок карточки" || p.Description != "Текст карточки" {
+		t.Errorf("поля OpenGraph не заменили название и описание: %+v", p)
+	}
+}
+
+// Бот превью получает карточку без учета перехода, браузер - перенаправление
+func TestPreviewForBots(t *testing.T) {
+	code := seedLinks(t, 1)[0]
+	if err := loadLocales(); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	link := links[linkKey{"", code}]
+	link.OGTitle = "Заголовок карточки"
+
+	get := func(ua string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/"+code, nil)
+		r.Header.Set("User-Agent", ua)
+		w := httptest.NewRecorder()
+		redirectShortLink(w, r)
+		return w
+	}
+
+	w := get("TelegramBot (like TwitterBot)")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `<meta property="og:title" content="Заголовок карточки">`) {
+		t.Errorf("бот: код %d, карточки нет в ответе", w.Code)
+	}
+	if got := link.Visits.Load(); got != 0 {
+		t.Errorf("показ карточки засчитан как переход: %d", got)
+	}
+
+	w = get("Mozilla/5.0 (X11; Linux x86_64) Firefox/120.0")
+	if w.Code != http.StatusFound || w.Header().Get("Location") != link.OriginalURL {
+		t.Errorf("браузер: код %d, Location %q", w.Code, w.Header().Get("Location"))
+	}
+	if !strings.Contains(w.Header().Get("Vary"), "User-Agent") {
+		t.Errorf("Vary %q: ответ зависит от User-Agent", w.Header().Get("Vary"))
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"container/list"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ограничение частоты запросов с одного IP (token bucket):
+// запас burst запросов пополняется со скоростью requests_per_minute.
+var requestLimiter = newRateLimiter()
+
+// Корзины по ключам (IP). Ключей не больше maxBuckets: новый вытесняет
+// тот, к которому дольше всех не обращались, за O(1).
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*list.Element // ключ -> элемент lru
+	lru     *list.List               // *tokenBucket, недавние в начале
+}
+
+type tokenBucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// Сколько IP хранить одновременно
+const maxBuckets = 10000
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*list.Element), lru: list.New()}
+}
+
+// Можно ли обработать запрос с этого IP
+func allowRequest(ip string, now time.Time) bool {
+	return requestLimiter.allow(ip, now, config.Limits.RequestsPerMinute, config.Limits.Burst)
+}
+
+// Списание одного запроса из корзины ключа
+func (l *rateLimiter) allow(key string, now time.Time, perMinute, burstSize int) bool {
+	rate := float64(perMinute) / 60
+	burst := float64(burstSize)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var b *tokenBucket
+	if e := l.buckets[key]; e != nil {
+		l.lru.MoveToFront(e)
+		b = e.Value.(*tokenBucket)
+	} else {
+		if l.lru.Len() >= maxBuckets {
+			oldest := l.lru.Back()
+			l.lru.Remove(oldest)
+			delete(l.buckets, oldest.Value.(*tokenBucket).key)
+		}
+		b = &tokenBucket{key: key, tokens: burst, last: now}
+		l.buckets[key] = l.lru.PushFront(b)
+	}
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Middleware ограничения частоты. Статика не ограничивается:
+// страница тянет стили вместе с собой.
+func rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.Limits.RequestsPerMinute == 0 || strings.HasPrefix(r.URL.Path, "/static/") || isProbePath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !allowRequest(getIP(r), time.Now()) {
+			rateLimitRejected.Inc()
+			retry := 60 / config.Limits.RequestsPerMinute
+			w.Header().Set("Retry-After", strconv.Itoa(max(retry, 1)))
+			http.Error(w, localeFrom(r).T("error.rate_limited"), http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGetIP(t *testing.T) {
+	config = defaultConfig()
+	config.Server.TrustedProxies = []string{"10.0.0.1", "192.168.0.0/16"}
+
+	for _, tc := range []struct {
+		remote, forwarded, want string
+	}{
+		{"203.0.113.7:5000", "", "203.0.113.7"},
+		{"203.0.113.7:5000", "1.2.3.4", "203.0.113.7"}, // клиент сам подставил заголовок
+		{"10.0.0.1:5000", "", "10.0.0.1"},
+		{"10.0.0.1:5000", "203.0.113.7", "203.0.113.7"},
+		{"10.0.0.1:5000", "1.2.3.4, 203.0.113.7", "203.0.113.7"}, // левые адреса подставлены клиентом
+		{"10.0.0.1:5000", "203.0.113.7, 192.168.1.5", "203.0.113.7"},
+		{"10.0.0.1:5000", "192.168.1.5, 192.168.1.6", "192.168.1.5"},
+		{"10.0.0.1:5000", "garbage, 203.0.113.7", "203.0.113.7"},
+		{"10.0.0.1:5000", "203.0.113.7, garbage", "10.0.0.1"},
+		{"[2001:db8::1]:5000", "1.2.3.4", "2001:db8::1"},
+		{"pipe", "1.2.3.4", "pipe"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tc.remote
+		if tc.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tc.forwarded)
+		}
+		if got := getIP(r); got != tc.want {
+			t.Errorf("getIP(%s, XFF %q) = %q, ожидалось %q", tc.remote, tc.forwarded, got, tc.want)
+		}
+	}
+
+	// Несколько заголовков X-Forwarded-For склеиваются по порядку
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:5000"
+	r.Header.Add("X-Forwarded-For", "1.2.3.4")
+	r.Header.Add("X-Forwarded-For", "203.0.113.7")
+	if got := getIP(r); got != "203.0.113.7" {
+		t.Errorf("getIP с двумя заголовками = %q", got)
+	}
+}
+
+// Подмена X-Forwarded-For не дает обойти ограничение частоты
+func TestRateLimitIgnoresSpoofedForwardedFor(t *testing.T) {
+	resetState()
+	config.Limits.RequestsPerMinute = 60
+	config.Limits.Burst = 2
+	if err := loadLocales(); err != nil {
+		t.Fatal(err)
+	}
+	handler := rateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	codes := []int{}
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/my", nil)
+		r.RemoteAddr = "203.0.113.7:5000"
+		r.Header.Set("X-Forwarded-For", fmt.Sprintf("198.51.100.%d", i))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		codes = append(codes, w.Code)
+	}
+	if codes[2] != http.StatusTooManyRequests {
+		t.Errorf("коды ответов %v: третий запрос должен быть отклонен", codes)
+	}
+}
+
+func TestRateLimiterEvictsLeastRecentlyUsed(t *testing.T) {
+	l := newRateLimiter()
+	now := time.Now()
+	for i := 0; i < maxBuckets; i++ {
+		l.allow(fmt.Sprint(i), now, 60, 1)
+	}
+	// Ключ 0 снова использован, вытеснен будет ключ 1
+	if l.allow("0", now, 60, 1) {
+		t.Fatal("запас ключа 0 должен быть исчерпан")
+	}
+	if !l.allow("new", now, 60, 1) {
+		t.Fatal("новый ключ должен получить полный запас")
+	}
+	if len(l.buckets) != maxBuckets || l.lru.Len() != maxBuckets {
+		t.Fatalf("корзин %d, в очереди %d, ожидалось %d", len(l.buckets), l.lru.Len(), maxBuckets)
+	}
+	if l.buckets["1"] != nil || l.buckets["0"] == nil {
+		t.Error("вытеснен не самый давний ключ")
+	}
+	// Вытесненный ключ начинает с полного запаса (вытесняя ключ 2), остальные - нет
+	if !l.allow("1", now, 60, 1) || l.allow("3", now, 60, 1) {
+		t.Error("состояние корзин после вытеснения")
+	}
+}
+
+func TestRateLimiterRefill(t *testing.T) {
+	l := newRateLimiter()
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if !l.allow("ip", now, 60, 3) {
+			t.Fatalf("запрос %d в пределах запаса отклонен", i+1)
+		}
+	}
+	if l.allow("ip", now, 60, 3) {
+		t.Fatal("запрос сверх запаса принят")
+	}
+	if !l.allow("ip", now.Add(time.Second), 60, 3) || l.allow("ip", now.Add(time.Second), 60, 3) {
+		t.Error("за секунду при 60 в минуту должен пополниться один запрос")
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// Сброс всего состояния пакета: конфигурация по умолчанию, пустая база
+// и все, что строится поверх нее. Новое состояние добавляется сюда,
+// чтобы тесты не зависели от порядка запуска.
+func resetState() {
+	config = defaultConfig()
+	links = make(map[linkKey]*Link)
+	ipLinks = make(map[string][]linkKey)
+
+	leaderMu.Lock()
+	boards = make(map[boardID]*leaderboard)
+	activity = make(map[linkKey]*linkActivity)
+	totals = make(map[string]*domainTotals)
+	for len(visitQueue) > 0 {
+		<-visitQueue
+	}
+	leaderMu.Unlock()
+	searchDocs = make(map[linkKey]string)
+	searchGrams = make(map[string]map[linkKey]struct{})
+
+	missMu.Lock()
+	misses = make(map[linkKey]*missEntry)
+	missMu.Unlock()
+	for _, f := range stateFiles {
+		f.savedGen.Store(f.dirtyGen.Load())
+	}
+	suggestLimiter = newRateLimiter()
+	requestLimiter = newRateLimiter()
+	adminMu.Lock()
+	bans = make(map[string]*ban)
+	sessions = make(map[string]*adminSession)
+	loginFailures = make(map[string]*loginFailure)
+	auditLog = nil
+	adminMu.Unlock()
+
+	webhookMu.Lock()
+	webhooks = webhookState{}
+	webhookMu.Unlock()
+	visitThresholds.Store(nil)
+	feedMu.Lock()
+	feed = nil
+	feedMu.Unlock()
+	alertHistory = make(map[linkKey]visitHistory)
+	for len(eventQueue) > 0 {
+		<-eventQueue
+	}
+	for len(mailQueue) > 0 {
+		<-mailQueue
+	}
+
+	dbLoaded.Store(false)
+	saveStateMu.Lock()
+	lastSaveErr = nil
+	saveStateMu.Unlock()
+}
+
+// Заполняет базу n ссылками и возвращает их коды
+func seedLinks(tb testing.TB, n int) []string {
+	tb.Helper()
+	resetState()
+
+	codes := make([]string, n)
+	for i := range codes {
+		code := fmt.Sprintf("c%05d", i)
+		links[linkKey{"", code}] = &Link{OriginalURL: "https://example.com/" + code, ShortCode: code}
+		codes[i] = code
+	}
+	rebuildLeaderboards()
+	rebuildSearchIndex()
+	rebuildCodeIndex()
+	return codes
+}
+
+func TestRedirectCountsEveryVisit(t *testing.T) {
+	codes := seedLinks(t, 1)
+	const workers, perWorker = 16, 500
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perWorker; j++ {
+				w := httptest.NewRecorder()
+				if !redirectShortLink(w, httptest.NewRequest(http.MethodGet, "/"+codes[0], nil)) {
+					t.Error("ссылка не найдена")
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := links[linkKey{"", codes[0]}].Visits.Load(); got != workers*perWorker {
+		t.Fatalf("переходов = %d, ожидалось %d", got, workers*perWorker)
+	}
+}
+
+func TestRedirectUnknownCode(t *testing.T) {
+	seedLinks(t, 1)
+	w := httptest.NewRecorder()
+	if redirectShortLink(w, httptest.NewRequest(http.MethodGet, "/nope", nil)) {
+		t.Fatal("неизвестный код не должен перенаправлять")
+	}
+}
+
+// Фоновый учет переходов в рейтингах, как на работающем сервере
+func startLeaderboard(tb testing.TB) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runLeaderboardRefresh(ctx)
+	}()
+	tb.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+// Параллельные переходы по разным ссылкам
+func BenchmarkRedirectParallel(b *testing.B) {
+	codes := seedLinks(b, 1000)
+	startLeaderboard(b)
+	var next atomic.Uint64
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			code := codes[next.Add(1)%uint64(len(codes))]
+			redirectShortLink(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/"+code, nil))
+		}
+	})
+}
+
+// Параллельные переходы по одной популярной ссылке - худший случай для счетчика
+func BenchmarkRedirectParallelHotLink(b *testing.B) {
+	codes := seedLinks(b, 1)
+	startLeaderboard(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			redirectShortLink(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/"+codes[0], nil))
+		}
+	})
+}
+
+// Переходы во время построения страницы топа, которая держит блокировку на чтение
+func BenchmarkRedirectParallelWithTop(b *testing.B) {
+	codes := seedLinks(b, 1000)
+	startLeaderboard(b)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				mutex.RLock()
+				leaderboardTop(windowAll, "", 50)
+				mutex.RUnlock()
+			}
+		}
+	}()
+
+	var next atomic.Uint64
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			code := codes[next.Add(1)%uint64(len(codes))]
+			redirectShortLink(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/"+code, nil))
+		}
+	})
+	b.StopTimer()
+	close(stop)
+	<-done
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Шаблоны, статика и переводы встроены в бинарник. Любой файл можно подменить,
+// положив файл с тем же путем в каталог темы (ui.theme_dir).
+//
+//go:embed templates static locales
+var embeddedAssets embed.FS
+
+// Файловая система с приоритетом каталога темы
+type themeFS struct {
+	theme fs.FS
+	base  fs.FS
+}
+
+func (t themeFS) Open(name string) (fs.File, error) {
+	if t.theme != nil {
+		if f, err := t.theme.Open(name); err == nil {
+			return f, nil
+		}
+	}
+	return t.base.Open(name)
+}
+
+func assetsFS() fs.FS {
+	fsys := themeFS{base: embeddedAssets}
+	if config.UI.ThemeDir != "" {
+		fsys.theme = os.DirFS(config.UI.ThemeDir)
+	}
+	return fsys
+}
+
+// Общие части: макет и частичные шаблоны
+var layoutFiles = []string{
+	"templates/layout.html",
+	"templates/partials/menu.html",
+	"templates/partials/link_card.html",
+	"templates/partials/admin_nav.html",
+}
+
+var templateFuncs = template.FuncMap{
+	"rankClass": func(rank int) string {
+		if rank >= 1 && rank <= 3 {
+			return fmt.Sprintf("rank-%d", rank)
+		}
+		return ""
+	},
+}
+
+// Разобранные шаблоны страниц: имя -> макет + страница
+var pages map[string]*template.Template
+
+// Загрузка шаблонов при старте
+func loadTemplates() error {
+	fsys := assetsFS()
+	pages = make(map[string]*template.Template)
+	for _, name := range []string{"index", "my", "stats", "top", "notfound", "status", "preview", "link_stats", "deeplink", "webhooks", "admin", "admin_bans", "admin_audit", "admin_login"} {
+		files := append(append([]string(nil), layoutFiles...), "templates/"+name+".html")
+		t, err := template.New("layout.html").Funcs(templateFuncs).ParseFS(fsys, files...)
+		if err != nil {
+			return fmt.Errorf("шаблон %s: %w", name, err)
+		}
+		pages[name] = t
+	}
+	return nil
+}
+
+// Отрисовка страницы. Сначала в буфер, чтобы ошибка шаблона
+// не оставила клиенту половину страницы.
+func renderPage(w http.ResponseWriter, r *http.Request, name string, data any) {
+	renderPageStatus(w, r, http.StatusOK, name, data)
+}
+
+func renderPageStatus(w http.ResponseWriter, r *http.Request, status int, name string, data any) {
+	var buf bytes.Buffer
+	if err := pages[name].Execute(&buf, data); err != nil {
+		logEvent(slog.LevelError, "template_render_error", "template", name, "error", err)
+		http.Error(w, localeFrom(r).T("error.render"), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
+
+// Общие данные всех страниц
+type page struct {
+	L         *locale
+	Title     string
+	Heading   string
+	Features  FeaturesConfig
+	Languages []languageLink
+	CSRF      string // токен для форм владельца
+}
+
+// Заголовки страницы берутся из каталога по ключам title.<name> и heading.<name>
+func newPage(r *http.Request, name string) page {
+	l := localeFrom(r)
+	heading := l.T("heading." + name)
+	if _, ok := l.Messages["heading."+name]; !ok {
+		heading = l.T("title." + name)
+	}
+	return page{
+		L:         l,
+		Title:     l.T("title." + name),
+		Heading:   heading,
+		Features:  config.Features,
+		Languages: languageLinks(r),
+		CSRF:      csrfToken(r),
+	}
+}
+
+type indexPage struct {
+	page
+	Domains        []string
+	SelectedDomain string
+	CurrentDomain  string
+	Storage        StorageConfig
+	Expiries       []string
+	UTMParams      []string
+	DeepLinks      string // разрешенные схемы приложений через запятую
+	Result         string
+}
+
+type myPage struct {
+	page
+	IP         string
+	Total      int // все ссылки владельца, без учета фильтра
+	Found      int // подходят под фильтр и поиск, на всех страницах
+	Filter     linkFilter
+	Filtered   bool // задан тег, папка или поиск
+	Sorts      []string
+	Tags       []tagCount
+	Folders    []tagCount
+	Back       string // вид кабинета для возврата после массовых действий
+	Links      []linkCard
+	Pagination *pagination // nil - все ссылки на одной странице
+
+	Notifications []feedItem // последние уведомления, новые сверху
+}
+
+type pagination struct {
+	Page    int
+	Pages   int
+	PrevURL string
+	NextURL string
+}
+
+type statsPage struct {
+	page
+	Domains     []string
+	Domain      string
+	TotalLinks  int
+	TotalVisits int
+	UniqueIPs   int
+	TotalMisses int
+	Top         []linkCard
+	Tags        []tagCount
+	Misses      []missCard
+}
+
+// Страница неактивной ссылки
+type statusPage struct {
+	page
+	Status string // paused, disabled или expired
+	Text   string
+}
+
+type linkStatsPage struct {
+	page
+	Link     linkCard
+	Variants []variantStats
+	HasRules bool // правила срабатывают раньше вариантов
+}
+
+type deepLinkPage struct {
+	page
+	App      template.URL // адрес по схеме приложения
+	Fallback string       // пусто - уйти некуда, остается кнопка приложения
+	Delay    int64        // мс до перехода на Fallback
+}
+
+type webhooksPage struct {
+	page
+	Hooks  []webhookView
+	Dead   []webhookDelivery // новые сверху
+	Events []string
+	// Только что созданная подписка: ее секрет показывается один раз
+	NewID     string
+	NewSecret string
+}
+
+type previewPage struct {
+	page
+	URL         string // короткая ссылка
+	Description string
+	Image       string
+	Target      string
+}
+
+type notFoundPage struct {
+	page
+	Code        string
+	Suggestions []string
+}
+
+// Общие данные страниц администратора
+type adminPage struct {
+	page
+	Admin   string // логин вошедшего администратора
+	CSRF    string
+	Section string
+}
+
+type adminLinksPage struct {
+	adminPage
+	Filter  adminFilter
+	Domains []string
+	Rows    []adminLinkRow
+	Total   int
+	Back    string // параметры поиска для возврата после действия
+}
+
+type adminBansPage struct {
+	adminPage
+	Bans []ban
+}
+
+type adminAuditPage struct {
+	adminPage
+	Entries []auditEntry
+}
+
+type adminLoginPage struct {
+	page
+	User   string
+	Failed bool
+	Locked int // минут до конца блокировки входа
+}
+
+// Несуществующий код на странице статистики
+type missCard struct {
+	ShortURL string
+	Count    int
+	LastSeen time.Time
+}
+
+type topPage struct {
+	page
+	Domains     []string
+	Domain      string
+	Window      window
+	Caption     string
+	Tabs        []windowTab
+	Limit       int
+	Limits      []int
+	Links       []linkCard
+	TotalLinks  int
+	TotalVisits int
+}
+
+// Вкладка периода рейтинга
+type windowTab struct {
+	Label  string
+	URL    string
+	Active bool
+}
+
+// Данные для частичного шаблона карточки ссылки
+type linkCard struct {
+	L             *locale
+	Rank          int // место в рейтинге, 0 - без номера
+	ShortURL      string
+	OriginalURL   string
+	Title         string
+	Description   string
+	Favicon       string
+	Visits        int
+	CreatedAt     time.Time
+	LastVisit     time.Time
+	ExpiresAt     *time.Time
+	Status        string // active, paused, disabled или expired
+	Tags          []string
+	Folder        string
+	Key           string // значение флажка массовых действий; пусто - без флажка
+	Icon          string
+	EditURL       string // форма названия и описания; пусто - без формы
+	OGTitle       string
+	OGDescription string
+	OGImage       string
+	ForwardQuery  bool
+	Rules         string // правила перенаправления в текстовом виде
+	Variants      string // варианты A/B-теста в текстовом виде
+	StatsURL      string
+	DeepLink      bool // OriginalURL по схеме приложения
+	Fallback      string
+	Alerts        string // правила уведомлений в текстовом виде
+	AlertsOn      bool   // поле правил в форме изменения
+	DeleteURL     string
+	CSRF          string // токен для форм карточки
+	PauseURL      string // кнопка паузы; пусто, если ссылку нельзя приостановить
+}
+
+func newLinkCard(r *http.Request, s LinkStats, rank int) linkCard {
+	return linkCard{
+		L:           localeFrom(r),
+		Rank:        rank,
+		ShortURL:    shortLinkURL(r, s.Domain, s.ShortCode),
+		OriginalURL: s.OriginalURL,
+		Title:       s.Title,
+		Description: s.Description,
+		Favicon:     s.Favicon,
+		Visits:      s.Visits,
+		CreatedAt:   s.CreatedAt,
+		LastVisit:   s.LastVisit,
+		ExpiresAt:   s.ExpiresAt,
+		Status:      statusName(s.Status),
+		Tags:        s.Tags,
+		Folder:      s.Folder,
+		Icon:        activityIcon(s.Visits),
+		CSRF:        csrfToken(r),
+		DeepLink:    isDeepLink(s.OriginalURL),
+		Fallback:    s.Fallback,
+	}
+}
+
+// Иконка активности ссылки по количеству переходов
+func activityIcon(visits int) string {
+	switch {
+	case visits >= 100:
+		return "🔥"
+	case visits >= 50:
+		return "🚀"
+	case visits >= 10:
+		return "⚡"
+	}
+	return "📈"
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Правила перенаправления: одна ссылка ведет на разные адреса в зависимости
+// от платформы, языка, страны посетителя и времени суток. Правила
+// проверяются по порядку, срабатывает первое подходящее; если ни одно
+// не подошло - OriginalURL.
+
+const maxRules = 20
+
+// Платформы, которые различает detectPlatform
+var rulePlatforms = []string{"ios", "android", "windows", "macos", "linux"}
+
+// Правило срабатывает, если выполнены все заданные условия
+type redirectRule struct {
+	Platforms []string `json:"platforms,omitempty"`
+	Languages []string `json:"languages,omitempty"` // основной подтег: ru, en
+	Countries []string `json:"countries,omitempty"` // ISO 3166-1: RU, DE
+	TimeFrom  string   `json:"time_from,omitempty"` // ЧЧ:ММ включительно
+	TimeTo    string   `json:"time_to,omitempty"`   // ЧЧ:ММ не включительно; меньше TimeFrom - через полночь
+	TimeZone  string   `json:"time_zone,omitempty"` // пусто - часовой пояс сервера
+	Target    string   `json:"target"`
+}
+
+// Посетитель короткой ссылки
+type visitor struct {
+	Platform string
+	Language string // самый предпочтительный язык
+	Country  string // пусто, если база стран не задана или адреса в ней нет
+	Now      time.Time
+}
+
+func newVisitor(r *http.Request, now time.Time) visitor {
+	v := visitor{
+		Platform: detectPlatform(r.UserAgent()),
+		Country:  geoIP.country(getIP(r)),
+		Now:      now,
+	}
+	if langs := acceptedLanguages(r.Header.Get("Accept-Language")); len(langs) > 0 {
+		v.Language = langs[0]
+	}
+	return v
+}
+
+// Платформа по User-Agent; порядок проверок важен: в User-Agent Android
+// есть "Linux", а в User-Agent iPhone - "Mac OS X"
+func detectPlatform(ua string) string {
+	ua = strings.ToLower(ua)
+	switch {
+	case strings.Contains(ua, "iphone") || strings.Contains(ua, "ipad") || strings.Contains(ua, "ipod"):
+		return "ios"
+	case strings.Contains(ua, "android"):
+		return "android"
+	case strings.Contains(ua, "windows"):
+		return "windows"
+	case strings.Contains(ua, "macintosh") || strings.Contains(ua, "mac os x"):
+		return "macos"
+	case strings.Contains(ua, "linux") || strings.Contains(ua, "x11"):
+		return "linux"
+	}
+	return ""
+}
+
+// Адрес первого подходящего правила
+func matchRules(rules []redirectRule, v visitor) (string, bool) {
+	for _, rule := range rules {
+		if rule.matches(v) {
+			return rule.Target, true
+		}
+	}
+	return "", false
+}
+
+func (rule redirectRule) matches(v visitor) bool {
+	if len(rule.Platforms) > 0 && !hasTag(rule.Platforms, v.Platform) {
+		return false
+	}
+	if len(rule.Languages) > 0 && !hasTag(rule.Languages, v.Language) {
+		return false
+	}
+	if len(rule.Countries) > 0 && !hasTag(rule.Countries, v.Country) {
+		return false
+	}
+	if rule.TimeFrom != "" {
+		loc, err := loadZone(rule.TimeZone)
+		if err != nil {
+			return false
+		}
+		from, _ := parseClock(rule.TimeFrom)
+		to, _ := parseClock(rule.TimeTo)
+		now := v.Now.In(loc)
+		minute := now.Hour()*60 + now.Minute()
+		if from <= to {
+			return minute >= from && minute < to
+		}
+		return minute >= from || minute < to
+	}
+	return true
+}
+
+// Минуты от полуночи для ЧЧ:ММ
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, inputErr("input.bad_clock", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func formatClock(minutes int) string {
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
+
+// Часовые пояса читаются с диска, поэтому кэшируются
+var zoneCache sync.Map // имя -> *time.Location
+
+func loadZone(name string) (*time.Location, error) {
+	if name == "" {
+		return time.Local, nil
+	}
+	if loc, ok := zoneCache.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	zoneCache.Store(name, loc)
+	return loc, nil
+}
+
+// Проверка и приведение правила к каноническому виду
+func (rule *redirectRule) normalize() error {
+	for i, p := range rule.Platforms {
+		rule.Platforms[i] = strings.ToLower(strings.TrimSpace(p))
+		if !hasTag(rulePlatforms, rule.Platforms[i]) {
+			return inputErr("input.unknown_platform", p, strings.Join(rulePlatforms, ", "))
+		}
+	}
+	for i, l := range rule.Languages {
+		rule.Languages[i], _, _ = strings.Cut(strings.ToLower(strings.TrimSpace(l)), "-")
+		if rule.Languages[i] == "" {
+			return inputErr("input.empty_language")
+		}
+	}
+	for i, c := range rule.Countries {
+		rule.Countries[i] = strings.ToUpper(strings.TrimSpace(c))
+		if !isCountryCode(rule.Countries[i]) {
+			return inputErr("input.bad_country", c)
+		}
+	}
+	if (rule.TimeFrom == "") != (rule.TimeTo == "") {
+		return inputErr("input.time_needs_both")
+	}
+	if rule.TimeFrom != "" {
+		from, err := parseClock(rule.TimeFrom)
+		if err != nil {
+			return err
+		}
+		to, err := parseClock(rule.TimeTo)
+		if err != nil {
+			return err
+		}
+		if from == to {
+			return inputErr("input.empty_interval", rule.TimeFrom, rule.TimeTo)
+		}
+		rule.TimeFrom, rule.TimeTo = formatClock(from), formatClock(to)
+	}
+	if rule.TimeZone != "" {
+		if rule.TimeFrom == "" {
+			return inputErr("input.zone_without_time")
+		}
+		if _, err := loadZone(rule.TimeZone); err != nil {
+			return inputErr("input.unknown_zone", rule.TimeZone)
+		}
+	}
+	if len(rule.Platforms) == 0 && len(rule.Languages) == 0 && len(rule.Countries) == 0 && rule.TimeFrom == "" {
+		return inputErr("input.rule_without_conditions")
+	}
+	target, err := url.Parse(rule.Target)
+	if err != nil || target.Scheme != "http" && target.Scheme != "https" || target.Host == "" {
+		return inputErr("input.bad_target", rule.Target)
+	}
+	return nil
+}
+
+func normalizeRules(rules []redirectRule) error {
+	if len(rules) > maxRules {
+		return inputErr("input.too_many_rules", maxRules)
+	}
+	for i := range rules {
+		if err := rules[i].normalize(); err != nil {
+			return inputErr("input.rule", i+1, err)
+		}
+	}
+	return nil
+}
+
+// Правила в текстовом виде для формы кабинета, по одному на строку:
+//
+//	platform=ios -> https://apps.apple.com/app/id1
+//	country=RU,BY lang=ru -> https://example.ru
+//	time=09:00-18:00 tz=Europe/Moscow -> https://example.com/support
+func parseRules(text string) ([]redirectRule, error) {
+	var rules []redirectRule
+	for lineNo, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		conditions, target, ok := strings.Cut(line, "->")
+		if !ok {
+			return nil, inputErr("input.line", lineNo+1, inputErr("input.no_target"))
+		}
+		rule := redirectRule{Target: strings.TrimSpace(target)}
+		for _, cond := range strings.Fields(conditions) {
+			name, value, _ := strings.Cut(cond, "=")
+			list := strings.Split(value, ",")
+			switch strings.ToLower(name) {
+			case "platform":
+				rule.Platforms = list
+			case "lang":
+				rule.Languages = list
+			case "country":
+				rule.Countries = list
+			case "time":
+				rule.TimeFrom, rule.TimeTo, _ = strings.Cut(value, "-")
+			case "tz":
+				rule.TimeZone = value
+			default:
+				return nil, inputErr("input.line", lineNo+1, inputErr("input.unknown_condition", name))
+			}
+		}
+		if err := rule.normalize(); err != nil {
+			return nil, inputErr("input.line", lineNo+1, err)
+		}
+		rules = append(rules, rule)
+	}
+	if len(rules) > maxRules {
+		return nil, inputErr("input.too_many_rules", maxRules)
+	}
+	return rules, nil
+}
+
+func formatRules(rules []redirectRule) string {
+	var lines []string
+	for _, rule := range rules {
+		var conds []string
+		if len(rule.Platforms) > 0 {
+			conds = append(conds, "platform="+strings.Join(rule.Platforms, ","))
+		}
+		if len(rule.Languages) > 0 {
+			conds = append(conds, "lang="+strings.Join(rule.Languages, ","))
+		}
+		if len(rule.Countries) > 0 {
+			conds = append(conds, "country="+strings.Join(rule.Countries, ","))
+		}
+		if rule.TimeFrom != "" {
+			conds = append(conds, "time="+rule.TimeFrom+"-"+rule.TimeTo)
+		}
+		if rule.TimeZone != "" {
+			conds = append(conds, "tz="+rule.TimeZone)
+		}
+		lines = append(lines, strings.Join(conds, " ")+" -> "+rule.Target)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDetectPlatform(t *testing.T) {
+	for ua, want := range map[string]string{
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15":       "ios",
+		"Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15":                "ios",
+		"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 Chrome/120.0 Mobile":   "android",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/120.0":         "windows",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 Version/17.0": "macos",
+		"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0":    "linux",
+		"curl/8.4.0": "",
+	} {
+		if got := detectPlatform(ua); got != want {
+			t.Errorf("detectPlatform(%q) = %q, ожидалось %q", ua, got, want)
+		}
+	}
+}
+
+func TestAcceptedLanguages(t *testing.T) {
+	got := acceptedLanguages("en-US;q=0.5, de-DE, ru;q=0.9, *;q=0.1, fr;q=0")
+	if strings.Join(got, ",") != "de,ru,en" {
+		t.Fatalf("получено %v", got)
+	}
+}
+
+func TestMatchRules(t *testing.T) {
+	rules, err := parseRules(`
+		# приложение
+		platform=ios -> https://apps.apple.com/app/id1
+		platform=android -> https://play.google.com/store/apps/details?id=x
+		country=ru,by lang=ru -> https://example.ru
+		time=22:00-06:00 tz=UTC -> https://example.com/night
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noon := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	night := time.Date(2024, 5, 1, 23, 30, 0, 0, time.UTC)
+	morning := time.Date(2024, 5, 2, 5, 59, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name string
+		v    visitor
+		want string
+	}{
+		{"ios", visitor{Platform: "ios", Language: "ru", Country: "RU", Now: noon}, "https://apps.apple.com/app/id1"},
+		{"android", visitor{Platform: "android", Now: night}, "https://play.google.com/store/apps/details?id=x"},
+		{"страна и язык", visitor{Platform: "windows", Language: "ru", Country: "BY", Now: noon}, "https://example.ru"},
+		{"только страна", visitor{Platform: "windows", Language: "en", Country: "RU", Now: noon}, ""},
+		{"ночь", visitor{Platform: "linux", Now: night}, "https://example.com/night"},
+		{"ночь после полуночи", visitor{Now: morning}, "https://example.com/night"},
+		{"день", visitor{Now: noon}, ""},
+	} {
+		got, ok := matchRules(rules, tc.v)
+		if ok != (tc.want != "") || got != tc.want {
+			t.Errorf("%s: получено %q, ожидалось %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestTimeRuleZone(t *testing.T) {
+	rules, err := parseRules("time=9:00-18:00 tz=Asia/Tokyo -> https://example.jp")
+	if err != nil {
+		t.Skip("нет базы часовых поясов:", err)
+	}
+	if rules[0].TimeFrom != "09:00" {
+		t.Fatalf("время не приведено к ЧЧ:ММ: %q", rules[0].TimeFrom)
+	}
+	// 01:00 UTC - 10:00 в Токио
+	if _, ok := matchRules(rules, visitor{Now: time.Date(2024, 5, 1, 1, 0, 0, 0, time.UTC)}); !ok {
+		t.Fatal("правило должно сработать по времени Токио")
+	}
+	if _, ok := matchRules(rules, visitor{Now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}); ok {
+		t.Fatal("в 21:00 по Токио правило не должно срабатывать")
+	}
+}
+
+func TestParseRulesErrors(t *testing.T) {
+	for _, text := range []string{
+		"platform=ios https://example.com",
+		"platform=symbian -> https://example.com",
+		"country=RUS -> https://example.com",
+		"time=25:00-26:00 -> https://example.com",
+		"time=10:00-10:00 -> https://example.com",
+		"tz=UTC -> https://example.com",
+		"-> https://example.com",
+		"os=ios -> https://example.com",
+		"platform=ios -> javascript:alert(1)",
+		strings.Repeat("platform=ios -> https://example.com\n", maxRules+1),
+	} {
+		if _, err := parseRules(text); err == nil {
+			t.Errorf("%q: ожидалась ошибка", text)
+		}
+	}
+}
+
+func TestFormatRulesRoundTrip(t *testing.T) {
+	text := "platform=ios,android lang=ru country=RU -> https://example.ru\ntime=22:00-06:00 tz=UTC -> https://example.com/night"
+	rules, err := parseRules(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := formatRules(rules); got != text {
+		t.Fatalf("получено\n%s\nожидалось\n%s", got, text)
+	}
+}
+
+func TestGeoDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "geo.csv")
+	data := "# тест\n1.0.0.0,1.0.0.255,AU\n\"5.3.0.0\",\"5.3.255.255\",\"ru\"\n2a02:6b8::/32,RU\n10.0.0.0/8,ZZ\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := loadGeoDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]string{
+		"1.0.0.1":        "AU",
+		"1.0.1.0":        "",
+		"5.3.128.7":      "RU",
+		"::ffff:5.3.0.1": "RU",
+		"2a02:6b8:1::1":  "RU",
+		"2a02:6b9::1":    "",
+		"10.255.255.255": "ZZ",
+		"0.0.0.1":        "",
+		"not an ip":      "",
+	} {
+		if got := db.country(ip); got != want {
+			t.Errorf("country(%s) = %q, ожидалось %q", ip, got, want)
+		}
+	}
+
+	os.WriteFile(path, []byte("1.0.0.0,1.0.0.255,AU\n1.0.0.128/25,NZ\n"), 0644)
+	if _, err := loadGeoDB(path); err == nil {
+		t.Fatal("пересекающиеся диапазоны должны отклоняться")
+	}
+}
+
+func TestRedirectUsesRules(t *testing.T) {
+	seedLinks(t, 1)
+	key := linkKey{"", "c00000"}
+	links[key].Rules = []redirectRule{{Platforms: []string{"android"}, Target: "https://play.google.com/x"}}
+
+	for ua, want := range map[string]string{
+		"Mozilla/5.0 (Linux; Android 14)": "https://play.google.com/x",
+		"Mozilla/5.0 (Windows NT 10.0)":   "https://example.com/c00000",
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/c00000", nil)
+		r.Header.Set("User-Agent", ua)
+		redirectShortLink(w, r)
+		if got := w.Header().Get("Location"); got != want {
+			t.Errorf("%s: перенаправление на %q, ожидалось %q", ua, got, want)
+		}
+	}
+}
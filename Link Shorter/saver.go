@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// Отслеживание изменений базы: каждое создание, удаление и переход
+// увеличивает поколение, а сохранение запоминает записанное поколение.
+var (
+	dirtyGen atomic.Uint64
+	savedGen atomic.Uint64
+	dirtyCh  = make(chan struct{}, 1)
+)
+
+// Сохранение после неудачной загрузки затерло бы файл пустой базой
+var errDatabaseNotLoaded = errors.New("база данных не загружена, сохранение отключено")
+
+// Файл состояния рядом с базой, который пишет то же фоновое сохранение.
+// Поколения у каждого файла свои: переход по ссылке не переписывает их.
+type stateFile struct {
+	dirtyGen atomic.Uint64
+	savedGen atomic.Uint64
+	save     func() error
+}
+
+var (
+	missesFile   = &stateFile{save: saveMisses}
+	webhooksFile = &stateFile{save: saveWebhookState}
+	stateFiles   = []*stateFile{missesFile, webhooksFile}
+)
+
+// Отметить, что база изменилась и её нужно сохранить
+func markDirty() {
+	dirtyGen.Add(1)
+	wakeSaver()
+}
+
+// Отметить, что файл состояния изменился
+func (f *stateFile) markDirty() {
+	f.dirtyGen.Add(1)
+	wakeSaver()
+}
+
+func wakeSaver() {
+	select {
+	case dirtyCh <- struct{}{}:
+	default:
+	}
+}
+
+// Сохранение базы и файлов состояния, только если с прошлого раза были изменения
+func flushDatabase() error {
+	var errs []error
+	if gen := dirtyGen.Load(); gen != savedGen.Load() {
+		err := saveDatabase()
+		recordSaveResult(err)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			savedGen.Store(gen)
+		}
+	}
+	for _, f := range stateFiles {
+		if err := f.flush(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f *stateFile) flush() error {
+	gen := f.dirtyGen.Load()
+	if gen == f.savedGen.Load() {
+		return nil
+	}
+	if err := f.save(); err != nil {
+		return err
+	}
+	f.savedGen.Store(gen)
+	return nil
+}
+
+// Фоновое сохранение: после изменения ждём паузу save_delay, но не дольше
+// autosave с первого несохранённого изменения. Поток кликов даёт одну запись.
+func runSaver(ctx context.Context) {
+	var debounce, deadline <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-dirtyCh:
+			debounce = time.After(config.Storage.SaveDelay)
+			if deadline == nil {
+				deadline = time.After(config.Storage.Autosave)
+			}
+			continue
+		case <-debounce:
+		case <-deadline:
+		}
+
+		debounce, deadline = nil, nil
+		if err := flushDatabase(); err != nil {
+			// Повторим при следующем изменении или по таймеру
+			deadline = time.After(config.Storage.Autosave)
+		}
+	}
+}
+
+// Запись файла через временный файл в той же папке и переименование:
+// при сбое посреди записи на диске остается прежняя версия целиком
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, perm)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// База в JSON-файле во временной папке, без несохраненных изменений
+func seedSaver(t *testing.T) string {
+	t.Helper()
+	seedLinks(t, 1)
+	dir := t.TempDir()
+	config.Storage.Path = filepath.Join(dir, "links.json")
+	config.Stats.MissesPath = filepath.Join(dir, "misses.json")
+	config.Webhooks.Path = filepath.Join(dir, "webhooks.json")
+	dbLoaded.Store(true)
+	savedGen.Store(dirtyGen.Load())
+	select {
+	case <-dirtyCh:
+	default:
+	}
+	return config.Storage.Path
+}
+
+func savedLinks(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return -1
+	}
+	var list []*Link
+	if err := json.Unmarshal(data, &list); err != nil {
+		t.Fatalf("файл базы поврежден: %v", err)
+	}
+	return len(list)
+}
+
+func TestFlushDatabaseGenerations(t *testing.T) {
+	path := seedSaver(t)
+	if err := flushDatabase(); err != nil || savedLinks(t, path) != -1 {
+		t.Fatal("без изменений база не должна записываться")
+	}
+
+	markDirty()
+	if err := flushDatabase(); err != nil {
+		t.Fatal(err)
+	}
+	if n := savedLinks(t, path); n != 1 {
+		t.Fatalf("сохранено ссылок: %d", n)
+	}
+
+	// Повторный вызов без изменений файл не трогает
+	os.Remove(path)
+	if err := flushDatabase(); err != nil || savedLinks(t, path) != -1 {
+		t.Error("сохраненное поколение записано повторно")
+	}
+
+	// Изменение во время сохранения не теряется: поколение снова грязное
+	markDirty()
+	gen := dirtyGen.Load()
+	markDirty()
+	if err := flushDatabase(); err != nil || savedGen.Load() < gen {
+		t.Errorf("savedGen = %d, ожидалось не меньше %d", savedGen.Load(), gen)
+	}
+}
+
+func TestFlushDatabaseRefusedAfterFailedLoad(t *testing.T) {
+	path := seedSaver(t)
+	os.WriteFile(path, []byte("{broken"), 0644)
+	dbLoaded.Store(false)
+	defer dbLoaded.Store(true)
+
+	markDirty()
+	if err := flushDatabase(); !errors.Is(err, errDatabaseNotLoaded) {
+		t.Fatalf("flushDatabase = %v, ожидался отказ", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "{broken" {
+		t.Errorf("нечитаемая база перезаписана: %q", data)
+	}
+	if dirtyGen.Load() == savedGen.Load() {
+		t.Error("несохраненные изменения помечены сохраненными")
+	}
+}
+
+func TestRunSaverDebounce(t *testing.T) {
+	path := seedSaver(t)
+	config.Storage.SaveDelay = 30 * time.Millisecond
+	config.Storage.Autosave = 150 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runSaver(ctx)
+	}()
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	// Пока изменения идут чаще save_delay, запись откладывается...
+	markDirty()
+	for i := 0; i < 5; i++ {
+		time.Sleep(10 * time.Millisecond)
+		markDirty()
+	}
+	if savedLinks(t, path) != -1 {
+		t.Fatal("база записана до паузы в изменениях")
+	}
+	// ...но не дольше autosave с первого изменения
+	deadline := time.Now().Add(time.Second)
+	for savedLinks(t, path) == -1 {
+		if time.Now().After(deadline) {
+			t.Fatal("база не сохранена после паузы")
+		}
+		markDirty()
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+	for _, text := range []string{"first", "second"} {
+		if err := writeFileAtomic(path, []byte(text), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if data, _ := os.ReadFile(path); string(data) != text {
+			t.Errorf("содержимое %q, ожидалось %q", data, text)
+		}
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("в папке остались временные файлы: %d записей", len(entries))
+	}
+	if err := writeFileAtomic(filepath.Join(dir, "missing", "state.json"), []byte("x"), 0600); err == nil {
+		t.Error("ожидалась ошибка записи в несуществующую папку")
+	}
+}
+
+// Очередь вебхуков пишется фоновым сохранением: несколько событий - одна запись
+func TestWebhookStateBatched(t *testing.T) {
+	seedSaver(t)
+	webhooks.Hooks = []*webhook{{ID: "h1", Owner: "192.0.2.1", URL: "https://example.com/hook", Secret: "s", Events: []string{eventLinkCreated}}}
+	for i := 0; i < 3; i++ {
+		queueWebhookEvent(linkEvent{Type: eventLinkCreated, Key: linkKey{"", "c00000"}, Owner: "192.0.2.1", Time: time.Now()})
+	}
+	if _, err := os.Stat(config.Webhooks.Path); !os.IsNotExist(err) {
+		t.Fatalf("файл вебхуков записан сразу: %v", err)
+	}
+	if webhooksFile.dirtyGen.Load() == webhooksFile.savedGen.Load() {
+		t.Fatal("изменения вебхуков не отмечены")
+	}
+
+	if err := flushDatabase(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(config.Webhooks.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state webhookState
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Hooks) != 1 || len(state.Queue) != 3 {
+		t.Errorf("сохранено подписок %d, доставок %d", len(state.Hooks), len(state.Queue))
+	}
+}
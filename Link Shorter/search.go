@@ -0,0 +1,140 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// Полнотекстовый поиск по коду, названию и адресу назначения.
+// Индекс триграмм: для каждого слова запроса берутся ссылки, содержащие
+// все его триграммы, затем совпадение проверяется по тексту ссылки.
+// Индекс меняется вместе с links и защищен той же блокировкой mutex.
+var (
+	searchDocs  = make(map[linkKey]string)              // ссылка -> текст в нижнем регистре
+	searchGrams = make(map[string]map[linkKey]struct{}) // триграмма -> ссылки
+)
+
+// Текст ссылки для поиска
+func searchDocument(key linkKey, link *Link) string {
+	return strings.ToLower(key.Code + "\n" + link.Title + "\n" + link.OriginalURL)
+}
+
+func trigrams(text string) []string {
+	seen := make(map[string]bool)
+	var grams []string
+	for i := 0; i+3 <= len(text); i++ {
+		g := text[i : i+3]
+		if !seen[g] {
+			seen[g] = true
+			grams = append(grams, g)
+		}
+	}
+	return grams
+}
+
+// Добавление или обновление ссылки в индексе (вызывается под mutex)
+func indexLink(key linkKey, link *Link) {
+	doc := searchDocument(key, link)
+	if old, ok := searchDocs[key]; ok {
+		if old == doc {
+			return
+		}
+		unindexLink(key)
+	}
+	searchDocs[key] = doc
+	for _, g := range trigrams(doc) {
+		set := searchGrams[g]
+		if set == nil {
+			set = make(map[linkKey]struct{})
+			searchGrams[g] = set
+		}
+		set[key] = struct{}{}
+	}
+}
+
+// Удаление ссылки из индекса (вызывается под mutex)
+func unindexLink(key linkKey) {
+	doc, ok := searchDocs[key]
+	if !ok {
+		return
+	}
+	delete(searchDocs, key)
+	for _, g := range trigrams(doc) {
+		if set := searchGrams[g]; set != nil {
+			delete(set, key)
+			if len(set) == 0 {
+				delete(searchGrams, g)
+			}
+		}
+	}
+}
+
+// Построение индекса с нуля (вызывается под mutex после загрузки базы)
+func rebuildSearchIndex() {
+	searchDocs = make(map[linkKey]string, len(links))
+	searchGrams = make(map[string]map[linkKey]struct{})
+	for key, link := range links {
+		indexLink(key, link)
+	}
+}
+
+// Поисковый запрос: каждое слово должно встречаться в тексте ссылки.
+// Пустой запрос подходит под всё. Используется под mutex.RLock.
+type searchQuery struct {
+	words      []string
+	candidates map[linkKey]struct{} // nil - ограничения по индексу нет
+}
+
+func newSearchQuery(query string) *searchQuery {
+	q := &searchQuery{words: strings.Fields(strings.ToLower(query))}
+
+	// Пересекаем списки триграмм, начиная с самого короткого
+	var sets []map[linkKey]struct{}
+	for _, word := range q.words {
+		for _, g := range trigrams(word) {
+			sets = append(sets, searchGrams[g])
+		}
+	}
+	if len(sets) == 0 {
+		return q
+	}
+	sort.Slice(sets, func(i, j int) bool { return len(sets[i]) < len(sets[j]) })
+	q.candidates = make(map[linkKey]struct{}, len(sets[0]))
+	for key := range sets[0] {
+		q.candidates[key] = struct{}{}
+	}
+	for _, set := range sets[1:] {
+		if len(q.candidates) == 0 {
+			break
+		}
+		for key := range q.candidates {
+			if _, ok := set[key]; !ok {
+				delete(q.candidates, key)
+			}
+		}
+	}
+	return q
+}
+
+func (q *searchQuery) empty() bool {
+	return len(q.words) == 0
+}
+
+// Подходит ли ссылка под запрос
+func (q *searchQuery) match(key linkKey) bool {
+	if q.empty() {
+		return true
+	}
+	if q.candidates != nil {
+		if _, ok := q.candidates[key]; !ok {
+			return false
+		}
+	}
+	doc := searchDocs[key]
+	for _, word := range q.words {
+		if !strings.Contains(doc, word) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// Коды ссылок, найденных запросом
+func searchCodes(query string) []string {
+	q := newSearchQuery(query)
+	var codes []string
+	for key := range links {
+		if q.match(key) {
+			codes = append(codes, key.Code)
+		}
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// Индекс после изменений совпадает с построенным заново
+func checkSearchIndex(t *testing.T) {
+	t.Helper()
+	docs, grams := searchDocs, searchGrams
+	rebuildSearchIndex()
+	if !reflect.DeepEqual(docs, searchDocs) || !reflect.DeepEqual(grams, searchGrams) {
+		t.Errorf("индекс разошелся с links: %d документов, %d триграмм; заново %d и %d",
+			len(docs), len(grams), len(searchDocs), len(searchGrams))
+	}
+}
+
+func TestTrigrams(t *testing.T) {
+	if got := trigrams("abcab"); !reflect.DeepEqual(got, []string{"abc", "bca", "cab"}) {
+		t.Errorf("trigrams = %q", got)
+	}
+	if got := trigrams("ab"); got != nil {
+		t.Errorf("trigrams короткой строки = %q", got)
+	}
+}
+
+func TestSearchIndexBuild(t *testing.T) {
+	resetState()
+	for code, link := range map[string]*Link{
+		"go01": {OriginalURL: "https://go.dev/doc/Документация"},
+		"rs02": {OriginalURL: "https://www.rust-lang.org/learn"},
+		"py03": {OriginalURL: "https://docs.python.org/3/"},
+	} {
+		links[linkKey{"", code}] = link
+	}
+	rebuildSearchIndex()
+
+	for query, want := range map[string][]string{
+		"":             {"go01", "py03", "rs02"},
+		"doc":          {"go01", "py03"},
+		"DOCS":         {"py03"},
+		"документация": {"go01"},
+		"rust learn":   {"rs02"},
+		"rust python":  nil,
+		"rs0":          {"rs02"},
+		"go":           {"go01"},
+		"https":        {"go01", "py03", "rs02"},
+		"несуществующее слово": nil,
+	} {
+		if got := searchCodes(query); !reflect.DeepEqual(got, want) {
+			t.Errorf("поиск %q: %q, ожидалось %q", query, got, want)
+		}
+	}
+}
+
+// Индекс следует за созданием, изменением и удалением ссылок
+func TestSearchIndexUpdates(t *testing.T) {
+	resetState()
+	add := func(code, url string) linkKey {
+		key := linkKey{"", code}
+		links[key] = &Link{OriginalURL: url, ShortCode: code}
+		indexLink(key, links[key])
+		return key
+	}
+	key := add("k1", "https://example.com/kittens")
+	other := add("p2", "https://example.com/puppies")
+	if got := searchCodes("kittens"); !reflect.DeepEqual(got, []string{key.Code}) {
+		t.Errorf("после создания: %q", got)
+	}
+	if got := searchCodes(key.Code); !reflect.DeepEqual(got, []string{key.Code}) {
+		t.Errorf("поиск по коду: %q", got)
+	}
+	checkSearchIndex(t)
+
+	links[key].OriginalURL = "https://example.com/котята"
+	indexLink(key, links[key])
+	if got := searchCodes("котята"); !reflect.DeepEqual(got, []string{key.Code}) {
+		t.Errorf("после изменения адреса: %q", got)
+	}
+	if got := searchCodes("kittens"); got != nil {
+		t.Errorf("старый адрес остался в индексе: %q", got)
+	}
+	checkSearchIndex(t)
+
+	deleteLink(key, links[key])
+	if got := searchCodes("котята"); got != nil {
+		t.Errorf("после удаления найдено %q", got)
+	}
+	if got := searchCodes("example"); !reflect.DeepEqual(got, []string{other.Code}) {
+		t.Errorf("после удаления: %q", got)
+	}
+	if _, ok := searchDocs[key]; ok {
+		t.Error("удаленная ссылка осталась в индексе")
+	}
+	checkSearchIndex(t)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Состояния ссылки. В Status хранится только пауза владельца, отключение
+// администратором - отдельный флаг Disabled, чтобы включение не снимало
+// паузу. Истечение срока вычисляется по ExpiresAt, чтобы ссылка
+// не зависела от фоновых задач.
+const (
+	statusActive   = ""         // ссылка работает
+	statusPaused   = "paused"   // приостановлена владельцем
+	statusDisabled = "disabled" // отключена администратором
+	statusExpired  = "expired"  // истек срок действия
+
+	// Не хранится: схема диплинка больше не разрешена, а запасного адреса нет
+	statusUnsupported = "unsupported"
+)
+
+// Текущее состояние ссылки (вызывается под mutex). Отключение администратором
+// важнее срока действия, срок действия - важнее паузы.
+func (l *Link) state(now time.Time) string {
+	switch {
+	case l.Disabled:
+		return statusDisabled
+	case l.ExpiresAt != nil && !now.Before(*l.ExpiresAt):
+		return statusExpired
+	default:
+		return l.Status
+	}
+}
+
+// Имя состояния для ключей каталога и параметров: status.<имя>
+func statusName(status string) string {
+	if status == statusActive {
+		return "active"
+	}
+	return status
+}
+
+// Сроки действия на выбор при создании ссылки
+var expiryOptions = []struct {
+	Value    string
+	Duration time.Duration
+}{
+	{"1h", time.Hour},
+	{"1d", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+	{"365d", 365 * 24 * time.Hour},
+}
+
+func expiryValues() []string {
+	var values []string
+	for _, opt := range expiryOptions {
+		values = append(values, opt.Value)
+	}
+	return values
+}
+
+// Срок действия из формы; nil - бессрочно
+func parseExpiry(value string, now time.Time) *time.Time {
+	for _, opt := range expiryOptions {
+		if opt.Value == value {
+			t := now.Add(opt.Duration)
+			return &t
+		}
+	}
+	return nil
+}
+
+// Страница вместо редиректа для неактивной ссылки. Пауза временная (503),
+// отключенная и истекшая ссылки больше не работают (410).
+func renderStatusPage(w http.ResponseWriter, r *http.Request, status string) {
+	code := http.StatusGone
+	if status == statusPaused {
+		code = http.StatusServiceUnavailable
+		w.Header().Set("Retry-After", "3600")
+	}
+	name := statusName(status)
+	l := localeFrom(r)
+	data := statusPage{
+		page:   newPage(r, "status"),
+		Status: name,
+		Text:   l.T("status." + name + ".text"),
+	}
+	data.Title = l.T("status." + name + ".title")
+	data.Heading = data.Title
+	renderPageStatus(w, r, code, "status", data)
+}
+
+// Пауза и возобновление ссылки владельцем (POST /pause/<код>?domain=)
+func pauseHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/my", http.StatusFound)
+		return
+	}
+	key := linkKey{r.URL.Query().Get("domain"), strings.TrimPrefix(r.URL.Path, "/pause/")}
+	ip := getIP(r)
+
+	mutex.Lock()
+	link, exists := links[key]
+	changed := false
+	// Отключенную администратором ссылку владелец включить не может
+	if exists && link.IP == ip && !link.Disabled {
+		if link.Status == statusPaused {
+			link.Status = statusActive
+		} else {
+			link.Status = statusPaused
+		}
+		changed = true
+	}
+	var status string
+	if changed {
+		status = link.Status
+	}
+	mutex.Unlock()
+
+	if changed {
+		markDirty()
+		logEvent(slog.LevelInfo, "link_status_changed", "short_code", key.Code, "domain", key.Domain, "ip", ip, "status", statusName(status))
+	}
+	http.Redirect(w, r, "/my", http.StatusFound)
+}
+
+// Адрес кнопки паузы в кабинете
+func pauseURL(domain, code string) string {
+	return "/pause/" + code + "?domain=" + url.QueryEscape(domain)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Отключение администратором важнее срока, срок - важнее паузы
+func TestLinkStatePrecedence(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Hour)
+
+	for _, tc := range []struct {
+		name     string
+		link     *Link
+		expected string
+	}{
+		{"активна", &Link{}, statusActive},
+		{"срок еще не вышел", &Link{ExpiresAt: &future}, statusActive},
+		{"пауза", &Link{Status: statusPaused}, statusPaused},
+		{"истекла", &Link{ExpiresAt: &past}, statusExpired},
+		{"истекла на паузе", &Link{Status: statusPaused, ExpiresAt: &past}, statusExpired},
+		{"отключена", &Link{Disabled: true}, statusDisabled},
+		{"отключена и истекла", &Link{Disabled: true, ExpiresAt: &past}, statusDisabled},
+		{"отключена на паузе", &Link{Disabled: true, Status: statusPaused, ExpiresAt: &past}, statusDisabled},
+	} {
+		if got := tc.link.state(now); got != tc.expected {
+			t.Errorf("%s: состояние %q, ожидалось %q", tc.name, got, tc.expected)
+		}
+	}
+}
+
+func postPause(code, ip string) {
+	r := httptest.NewRequest(http.MethodPost, pauseURL("", code), nil)
+	r.RemoteAddr = ip + ":5000"
+	pauseHandler(httptest.NewRecorder(), r)
+}
+
+func postAdminAction(t *testing.T, code, action string) {
+	t.Helper()
+	form := url.Values{"code": {code}, "action": {action}}
+	r := httptest.NewRequest(http.MethodPost, "/admin/links", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	adminLinksHandler(w, r, &adminSession{User: "admin"})
+	if w.Code != http.StatusFound {
+		t.Fatalf("%s: код %d", action, w.Code)
+	}
+}
+
+func TestPauseAndAdminDisable(t *testing.T) {
+	code := seedLinks(t, 1)[0]
+	config.Storage.Backend = "memory"
+	link := links[linkKey{"", code}]
+	link.IP = "198.51.100.1"
+
+	// Чужой IP ссылку не трогает, владелец ставит и снимает паузу
+	postPause(code, "203.0.113.7")
+	if link.Status != statusActive {
+		t.Fatalf("пауза с чужого IP: %q", link.Status)
+	}
+	postPause(code, "198.51.100.1")
+	if link.Status != statusPaused {
+		t.Fatalf("пауза владельцем: %q", link.Status)
+	}
+
+	// Отключенную администратором ссылку владелец не возобновит
+	postAdminAction(t, code, "disable")
+	postPause(code, "198.51.100.1")
+	if got := link.state(time.Now()); got != statusDisabled || link.Status != statusPaused {
+		t.Fatalf("владелец изменил отключенную ссылку: состояние %q, пауза %q", got, link.Status)
+	}
+
+	// Включение администратором не снимает паузу владельца
+	postAdminAction(t, code, "enable")
+	if got := link.state(time.Now()); got != statusPaused {
+		t.Errorf("после включения: состояние %q, ожидалась пауза", got)
+	}
+	postPause(code, "198.51.100.1")
+	if got := link.state(time.Now()); got != statusActive {
+		t.Errorf("после возобновления: %q", got)
+	}
+}
+
+// Неактивная ссылка отвечает страницей состояния вместо редиректа
+func TestStatusPages(t *testing.T) {
+	code := seedLinks(t, 1)[0]
+	config.Storage.Backend = "memory"
+	if err := loadLocales(); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	link := links[linkKey{"", code}]
+	past := time.Now().Add(-time.Minute)
+	l := locales[config.I18n.DefaultLanguage]
+
+	for _, tc := range []struct {
+		name       string
+		set        func()
+		code       int
+		retryAfter bool
+	}{
+		{"активна", func() {}, http.StatusFound, false},
+		{"пауза", func() { link.Status = statusPaused }, http.StatusServiceUnavailable, true},
+		{"истекла", func() { link.Status, link.ExpiresAt = statusActive, &past }, http.StatusGone, false},
+		{"отключена", func() { link.ExpiresAt, link.Disabled = nil, true }, http.StatusGone, false},
+	} {
+		tc.set()
+		w := httptest.NewRecorder()
+		redirectShortLink(w, httptest.NewRequest(http.MethodGet, "/"+code, nil))
+		if w.Code != tc.code {
+			t.Errorf("%s: код %d, ожидался %d", tc.name, w.Code, tc.code)
+		}
+		if got := w.Header().Get("Retry-After") != ""; got != tc.retryAfter {
+			t.Errorf("%s: Retry-After %q", tc.name, w.Header().Get("Retry-After"))
+		}
+		title := l.T("status." + statusName(link.state(time.Now())) + ".title")
+		if tc.code != http.StatusFound && !strings.Contains(w.Body.String(), title) {
+			t.Errorf("%s: на странице нет заголовка %q", tc.name, title)
+		}
+	}
+	if got := link.Visits.Load(); got != 1 {
+		t.Errorf("переходов %d: неактивная ссылка не должна их считать", got)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Ограничения тегов и папок
+const (
+	maxTags         = 20
+	maxTagLength    = 32
+	maxFolderLength = 64
+)
+
+// Теги из строки через запятую: нижний регистр, без повторов.
+// Допустимы буквы, цифры, - и _; остальное отбрасывается.
+func parseTags(value string) []string {
+	var tags []string
+	for _, raw := range strings.Split(value, ",") {
+		tag := normalizeTag(raw)
+		if tag == "" || hasTag(tags, tag) {
+			continue
+		}
+		tags = append(tags, tag)
+		if len(tags) == maxTags {
+			break
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+func normalizeTag(raw string) string {
+	tag := strings.ToLower(strings.TrimSpace(raw))
+	tag = strings.Join(strings.Fields(tag), "-")
+	tag = strings.Map(func(ch rune) rune {
+		if unicode.IsLetter(ch) || unicode.IsDigit(ch) || ch == '-' || ch == '_' {
+			return ch
+		}
+		return -1
+	}, tag)
+	if r := []rune(tag); len(r) > maxTagLength {
+		tag = string(r[:maxTagLength])
+	}
+	return tag
+}
+
+// Папка: произвольное название без управляющих символов
+func normalizeFolder(raw string) string {
+	folder := strings.Join(strings.FieldsFunc(raw, func(ch rune) bool {
+		return unicode.IsSpace(ch) || unicode.IsControl(ch)
+	}), " ")
+	if r := []rune(folder); len(r) > maxFolderLength {
+		folder = strings.TrimSpace(string(r[:maxFolderLength]))
+	}
+	return folder
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Объединение тегов с учетом лимита; результат отсортирован
+func addTags(tags, add []string) []string {
+	result := append([]string(nil), tags...)
+	for _, tag := range add {
+		if !hasTag(result, tag) && len(result) < maxTags {
+			result = append(result, tag)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func removeTags(tags, remove []string) []string {
+	var result []string
+	for _, tag := range tags {
+		if !hasTag(remove, tag) {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
+// Ключ ссылки в полях форм: домен/код (в домене и коде нет косой черты)
+func formatLinkKey(key linkKey) string {
+	return key.Domain + "/" + key.Code
+}
+
+func parseLinkKey(value string) linkKey {
+	domain, code, _ := strings.Cut(value, "/")
+	return linkKey{domain, code}
+}
+
+// Тег или папка со счетчиками
+type tagCount struct {
+	Name   string
+	Links  int
+	Visits int
+}
+
+func sortTagCounts(list []tagCount) {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Links != list[j].Links {
+			return list[i].Links > list[j].Links
+		}
+		return list[i].Name < list[j].Name
+	})
+}
+
+// Теги и папки набора ссылок (вызывается под mutex.RLock)
+func collectTags(keys []linkKey) (tags, folders []tagCount) {
+	tagIndex := make(map[string]*tagCount)
+	folderIndex := make(map[string]*tagCount)
+	for _, key := range keys {
+		link, exists := links[key]
+		if !exists {
+			continue
+		}
+		visits := link.Visits.Load()
+		for _, tag := range link.Tags {
+			countTag(tagIndex, tag, visits)
+		}
+		if link.Folder != "" {
+			countTag(folderIndex, link.Folder, visits)
+		}
+	}
+	return tagList(tagIndex), tagList(folderIndex)
+}
+
+// Самые частые теги по всем ссылкам домена (пустой domain - по всем доменам).
+// Вызывается под mutex.RLock.
+func tagStats(domain string, n int) []tagCount {
+	index := make(map[string]*tagCount)
+	for key, link := range links {
+		if domain != "" && key.Domain != domain {
+			continue
+		}
+		visits := link.Visits.Load()
+		for _, tag := range link.Tags {
+			countTag(index, tag, visits)
+		}
+	}
+	list := tagList(index)
+	if len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+func countTag(index map[string]*tagCount, name string, visits int) {
+	c := index[name]
+	if c == nil {
+		c = &tagCount{Name: name}
+		index[name] = c
+	}
+	c.Links++
+	c.Visits += visits
+}
+
+func tagList(index map[string]*tagCount) []tagCount {
+	list := make([]tagCount, 0, len(index))
+	for _, c := range index {
+		list = append(list, *c)
+	}
+	sortTagCounts(list)
+	return list
+}
+
+// Массовые действия в кабинете (POST /my/bulk): добавить или убрать теги,
+// переложить в папку. Меняются только ссылки текущего владельца.
+func bulkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/my", http.StatusFound)
+		return
+	}
+	r.ParseForm()
+	ip := getIP(r)
+	tags := parseTags(r.FormValue("tags"))
+	folder := normalizeFolder(r.FormValue("folder"))
+	action := r.FormValue("action")
+
+	changed := false
+	mutex.Lock()
+	for _, value := range r.Form["link"] {
+		link, exists := links[parseLinkKey(value)]
+		if !exists || link.IP != ip {
+			continue
+		}
+		switch action {
+		case "add_tags":
+			link.Tags = addTags(link.Tags, tags)
+		case "remove_tags":
+			link.Tags = removeTags(link.Tags, tags)
+		case "move":
+			link.Folder = folder
+		default:
+			continue
+		}
+		changed = true
+	}
+	mutex.Unlock()
+
+	if changed {
+		markDirty()
+	}
+
+	// Возвращаемся к тому же виду кабинета
+	back, _ := url.ParseQuery(r.FormValue("back"))
+	http.Redirect(w, r, parseLinkFilter(back).url(), http.StatusFound)
+}
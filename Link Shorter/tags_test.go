@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeTag(t *testing.T) {
+	for raw, want := range map[string]string{
+		"Go":                      "go",
+		"  Веб  Разработка ":      "веб-разработка",
+		"c++":                     "c",
+		"snake_case-и-дефис":      "snake_case-и-дефис",
+		"#тег!":                   "тег",
+		"":                        "",
+		"!!!":                     "",
+		strings.Repeat("я", 40):   strings.Repeat("я", maxTagLength),
+		"2024 отчет\tза\nквартал": "2024-отчет-за-квартал",
+	} {
+		if got := normalizeTag(raw); got != want {
+			t.Errorf("normalizeTag(%q) = %q, ожидалось %q", raw, got, want)
+		}
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	for value, want := range map[string][]string{
+		"":                    nil,
+		" , ,":                nil,
+		"Work, home, WORK":    {"home", "work"},
+		"б, а, в":             {"а", "б", "в"},
+		"новости, !!, #спорт": {"новости", "спорт"},
+	} {
+		if got := parseTags(value); !reflect.DeepEqual(got, want) {
+			t.Errorf("parseTags(%q) = %q, ожидалось %q", value, got, want)
+		}
+	}
+
+	// Не больше maxTags тегов
+	var many []string
+	for i := 0; i < maxTags+5; i++ {
+		many = append(many, fmt.Sprintf("t%02d", i))
+	}
+	if got := parseTags(strings.Join(many, ",")); len(got) != maxTags || got[maxTags-1] != many[maxTags-1] {
+		t.Errorf("parseTags: %d тегов %q, ожидалось первых %d", len(got), got, maxTags)
+	}
+}
+
+func TestNormalizeFolder(t *testing.T) {
+	for raw, want := range map[string]string{
+		"Проекты":                      "Проекты",
+		"  Клиенты   2024 ":            "Клиенты 2024",
+		"a\x00b\tc\nd":                 "a b c d",
+		"Регистр И Знаки: ок!":         "Регистр И Знаки: ок!",
+		"":                             "",
+		strings.Repeat("п", 70):        strings.Repeat("п", maxFolderLength),
+		strings.Repeat("п", 63) + " x": strings.Repeat("п", 63),
+	} {
+		if got := normalizeFolder(raw); got != want {
+			t.Errorf("normalizeFolder(%q) = %q, ожидалось %q", raw, got, want)
+		}
+	}
+}
+
+func TestAddRemoveTags(t *testing.T) {
+	if got := addTags([]string{"b", "a"}, []string{"c", "a"}); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Errorf("addTags = %q", got)
+	}
+	full := make([]string, maxTags)
+	for i := range full {
+		full[i] = fmt.Sprintf("t%02d", i)
+	}
+	if got := addTags(full, []string{"лишний"}); len(got) != maxTags || hasTag(got, "лишний") {
+		t.Errorf("addTags превысил лимит: %q", got)
+	}
+	if got := removeTags([]string{"a", "b", "c"}, []string{"b", "нет"}); !reflect.DeepEqual(got, []string{"a", "c"}) {
+		t.Errorf("removeTags = %q", got)
+	}
+}
+
+func TestLinkFilterMatch(t *testing.T) {
+	link := &Link{Tags: []string{"go", "веб"}, Folder: "Проекты"}
+	for query, want := range map[string]bool{
+		"":                           true,
+		"tag=go":                     true,
+		"tag=GO":                     true,
+		"tag=rust":                   false,
+		"folder=Проекты":             true,
+		"folder=+Проекты+":           true,
+		"folder=Архив":               false,
+		"tag=веб&folder=Проекты":     true,
+		"tag=веб&folder=Архив":       false,
+		"tag=%D0%B2%D0%B5%D0%B1&q=x": true,
+	} {
+		q, _ := url.ParseQuery(query)
+		if got := parseLinkFilter(q).match(link); got != want {
+			t.Errorf("фильтр %q: %v, ожидалось %v", query, got, want)
+		}
+	}
+}
+
+func TestBulkHandler(t *testing.T) {
+	resetState()
+	config.Storage.Backend = "memory"
+	own := map[string]*Link{
+		"a": {ShortCode: "a", IP: "192.0.2.1", Tags: []string{"old"}},
+		"b": {ShortCode: "b", IP: "192.0.2.1", Tags: []string{"old", "x"}},
+	}
+	for code, link := range own {
+		links[linkKey{"", code}] = link
+	}
+	foreign := &Link{ShortCode: "c", IP: "192.0.2.2", Tags: []string{"old"}}
+	links[linkKey{"", "c"}] = foreign
+
+	bulk := func(form url.Values) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodPost, "/my/bulk", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.RemoteAddr = "192.0.2.1:5000"
+		w := httptest.NewRecorder()
+		bulkHandler(w, r)
+		if w.Code != http.StatusFound {
+			t.Fatalf("код %d", w.Code)
+		}
+		return w
+	}
+	all := []string{"/a", "/b", "/c", "/нет"}
+
+	bulk(url.Values{"action": {"add_tags"}, "tags": {"Новый, old"}, "link": all})
+	if !reflect.DeepEqual(own["a"].Tags, []string{"old", "новый"}) || !reflect.DeepEqual(own["b"].Tags, []string{"old", "x", "новый"}) {
+		t.Errorf("add_tags: %q, %q", own["a"].Tags, own["b"].Tags)
+	}
+
+	bulk(url.Values{"action": {"remove_tags"}, "tags": {"old"}, "link": all})
+	if !reflect.DeepEqual(own["a"].Tags, []string{"новый"}) || !reflect.DeepEqual(own["b"].Tags, []string{"x", "новый"}) {
+		t.Errorf("remove_tags: %q, %q", own["a"].Tags, own["b"].Tags)
+	}
+
+	w := bulk(url.Values{"action": {"move"}, "folder": {"  Архив  "}, "link": {"/a"}, "back": {"tag=x&page=2"}})
+	if own["a"].Folder != "Архив" || own["b"].Folder != "" {
+		t.Errorf("move: %q, %q", own["a"].Folder, own["b"].Folder)
+	}
+	if loc := w.Header().Get("Location"); loc != "/my?page=2&tag=x" {
+		t.Errorf("возврат на %q", loc)
+	}
+
+	bulk(url.Values{"action": {"unknown"}, "tags": {"z"}, "folder": {"z"}, "link": all})
+	if own["a"].Folder != "Архив" || hasTag(own["a"].Tags, "z") {
+		t.Errorf("неизвестное действие изменило ссылку: %+v", own["a"])
+	}
+
+	if !reflect.DeepEqual(foreign.Tags, []string{"old"}) || foreign.Folder != "" {
+		t.Errorf("изменена чужая ссылка: %q, %q", foreign.Tags, foreign.Folder)
+	}
+}
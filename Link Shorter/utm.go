@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Метки UTM, которые можно задать при создании ссылки
+var utmParams = []string{"utm_source", "utm_medium", "utm_campaign", "utm_term", "utm_content"}
+
+// Самая длинная строка параметров, которая передается на адрес назначения
+const maxForwardQuery = 2048
+
+// Добавление меток UTM к адресу. Метки с тем же именем, уже стоящие в адресе,
+// заменяются; остальные параметры и якорь остаются как были.
+func applyUTM(rawURL string, utm map[string]string) (string, error) {
+	set := make(map[string]string)
+	for _, name := range utmParams {
+		if v := strings.TrimSpace(utm[name]); v != "" {
+			set[name] = v
+		}
+	}
+	if len(set) == 0 {
+		return rawURL, nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	parts := keepQueryParts(u.RawQuery, func(name string) bool {
+		_, replaced := set[name]
+		return !replaced
+	})
+	for _, name := range utmParams {
+		if v, ok := set[name]; ok {
+			parts = append(parts, name+"="+url.QueryEscape(v))
+		}
+	}
+	u.RawQuery = strings.Join(parts, "&")
+	return u.String(), nil
+}
+
+// Перенос параметров короткой ссылки (/abc?ref=x) на адрес назначения.
+// Параметры, которые уже есть в адресе, не перекрываются: метки владельца
+// важнее присланных посетителем. Значения перекодируются заново.
+func forwardQuery(target, rawQuery string) string {
+	if rawQuery == "" || len(rawQuery) > maxForwardQuery {
+		return target
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+	own, _ := url.ParseQuery(u.RawQuery)
+
+	parts := keepQueryParts(u.RawQuery, func(string) bool { return true })
+	for _, part := range strings.Split(rawQuery, "&") {
+		name, value, _ := strings.Cut(part, "=")
+		name, err1 := url.QueryUnescape(name)
+		value, err2 := url.QueryUnescape(value)
+		if err1 != nil || err2 != nil || name == "" || own.Has(name) {
+			continue
+		}
+		parts = append(parts, url.QueryEscape(name)+"="+url.QueryEscape(value))
+	}
+	u.RawQuery = strings.Join(parts, "&")
+	return u.String()
+}
+
+// Части строки параметров в исходном порядке и записи, чье имя прошло keep
+func keepQueryParts(rawQuery string, keep func(name string) bool) []string {
+	var parts []string
+	for _, part := range strings.Split(rawQuery, "&") {
+		if part == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(part, "=")
+		if n, err := url.QueryUnescape(name); err == nil {
+			name = n
+		}
+		if keep(name) {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApplyUTM(t *testing.T) {
+	for _, tc := range []struct {
+		url  string
+		utm  map[string]string
+		want string
+	}{
+		{"https://example.com/", nil, "https://example.com/"},
+		{"https://example.com/", map[string]string{"utm_source": "  "}, "https://example.com/"},
+		{"https://example.com/", map[string]string{"utm_source": "news", "utm_medium": "email"},
+			"https://example.com/?utm_source=news&utm_medium=email"},
+		// Порядок меток фиксирован, лишние ключи не попадают в адрес
+		{"https://example.com/", map[string]string{"utm_content": "b", "utm_source": "a", "ref": "x"},
+			"https://example.com/?utm_source=a&utm_content=b"},
+		// Метка из адреса заменяется, остальные параметры и якорь остаются
+		{"https://example.com/p?utm_source=old&id=7&UTM_SOURCE=x#top", map[string]string{"utm_source": "новая рассылка"},
+			"https://example.com/p?id=7&UTM_SOURCE=x&utm_source=%D0%BD%D0%BE%D0%B2%D0%B0%D1%8F+%D1%80%D0%B0%D1%81%D1%81%D1%8B%D0%BB%D0%BA%D0%B0#top"},
+		// Закодированное имя в адресе тоже считается тем же параметром
+		{"https://example.com/?utm%5Fsource=old&a=1", map[string]string{"utm_source": "new"},
+			"https://example.com/?a=1&utm_source=new"},
+		{"https://example.com/?a=1&a=2&utm_term=x&utm_term=y", map[string]string{"utm_term": "z"},
+			"https://example.com/?a=1&a=2&utm_term=z"},
+	} {
+		got, err := applyUTM(tc.url, tc.utm)
+		if err != nil {
+			t.Errorf("applyUTM(%q): %v", tc.url, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("applyUTM(%q, %v) = %q, ожидалось %q", tc.url, tc.utm, got, tc.want)
+		}
+	}
+
+	if _, err := applyUTM("https://exa mple.com/%zz", map[string]string{"utm_source": "a"}); err == nil {
+		t.Error("applyUTM: некорректный адрес принят")
+	}
+}
+
+func TestForwardQuery(t *testing.T) {
+	for _, tc := range []struct {
+		target, query, want string
+	}{
+		{"https://example.com/", "", "https://example.com/"},
+		{"https://example.com/", "ref=tw", "https://example.com/?ref=tw"},
+		{"https://example.com/p?id=1#top", "ref=tw&x=2", "https://example.com/p?id=1&ref=tw&x=2#top"},
+		// Параметры владельца важнее присланных посетителем
+		{"https://example.com/?utm_source=news", "utm_source=spam&ref=tw", "https://example.com/?utm_source=news&ref=tw"},
+		{"https://example.com/?utm_source=news", "utm%5Fsource=spam", "https://example.com/?utm_source=news"},
+		// Значения перекодируются, мусор отбрасывается
+		{"https://example.com/", "q=a b&bad=%zz&=x&flag", "https://example.com/?q=a+b&flag="},
+		{"https://example.com/", "q=%3Cscript%3E", "https://example.com/?q=%3Cscript%3E"},
+		{"https://example.com/", "ref=" + strings.Repeat("a", maxForwardQuery), "https://example.com/"},
+		{"://bad", "ref=tw", "://bad"},
+	} {
+		if got := forwardQuery(tc.target, tc.query); got != tc.want {
+			t.Errorf("forwardQuery(%q, %q) = %q, ожидалось %q", tc.target, tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestKeepQueryParts(t *testing.T) {
+	drop := func(name string) bool { return name != "drop" }
+	for query, want := range map[string][]string{
+		"":                      nil,
+		"a=1&&b=2&":             {"a=1", "b=2"},
+		"a=1&drop=2&b=%zz&drop": {"a=1", "b=%zz"},
+		"dr%6Fp=1&keep=1":       {"keep=1"},
+		"%zz=1&drop=1":          {"%zz=1"},
+		"a=1&a=2&drop=x&a=3":    {"a=1", "a=2", "a=3"},
+	} {
+		if got := keepQueryParts(query, drop); !reflect.DeepEqual(got, want) {
+			t.Errorf("keepQueryParts(%q) = %q, ожидалось %q", query, got, want)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A/B-тест: у ссылки несколько адресов назначения с весами. Посетитель
+// получает вариант случайно по весам и запоминает его в cookie, чтобы
+// при повторных переходах попадать на ту же страницу.
+
+const (
+	maxVariants      = 10
+	maxVariantWeight = 1000
+	variantCookieAge = 90 * 24 * time.Hour
+)
+
+// Вариант адреса назначения. ID - буква, которая не меняется при правке
+// списка: по ней cookie посетителя находит свой вариант.
+type linkVariant struct {
+	ID       string  `json:"id"`
+	URL      string  `json:"url"`
+	Weight   int     `json:"weight"`
+	Visits   Counter `json:"visits"`   // переходы на вариант
+	Visitors Counter `json:"visitors"` // посетители, которым вариант выпал впервые
+}
+
+// Вариант для посетителя: из cookie, а если его нет - случайный по весам.
+// isNew - вариант назначен этим переходом. Вызывается под mutex.RLock.
+func pickVariant(w http.ResponseWriter, r *http.Request, code string, variants []*linkVariant) (v *linkVariant, isNew bool) {
+	name := variantCookieName(code)
+	if c, err := r.Cookie(name); err == nil {
+		for _, v := range variants {
+			if v.ID == c.Value {
+				return v, false
+			}
+		}
+	}
+
+	total := 0
+	for _, v := range variants {
+		total += v.Weight
+	}
+	n := rand.Intn(total)
+	for _, v = range variants {
+		if n < v.Weight {
+			break
+		}
+		n -= v.Weight
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    v.ID,
+		Path:     "/" + code,
+		MaxAge:   int(variantCookieAge / time.Second),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return v, true
+}
+
+func variantCookieName(code string) string {
+	return "ab_" + code
+}
+
+// Варианты из формы кабинета, по одному на строку: "вес адрес".
+// Варианты с уже известным адресом сохраняют букву и счетчики, буквы
+// удаленных вариантов новым по возможности не достаются: иначе посетитель
+// с cookie старого варианта молча попал бы на другой адрес.
+// Вызывается под mutex: счетчики меняются только под mutex.RLock,
+// поэтому копия не теряет переходов.
+func parseVariants(text string, old []*linkVariant) ([]*linkVariant, error) {
+	var variants []*linkVariant
+	used := make(map[string]bool)
+	for lineNo, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, inputErr("input.line", lineNo+1, inputErr("input.variant_format"))
+		}
+		weight, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, inputErr("input.line", lineNo+1, inputErr("input.weight_not_number", fields[0]))
+		}
+		v := &linkVariant{URL: fields[1], Weight: weight}
+		if err := v.validate(); err != nil {
+			return nil, inputErr("input.line", lineNo+1, err)
+		}
+		for _, o := range old {
+			if o.URL == v.URL && !used[o.ID] {
+				v.ID = o.ID
+				v.Visits.Store(o.Visits.Load())
+				v.Visitors.Store(o.Visitors.Load())
+				break
+			}
+		}
+		if v.ID != "" {
+			used[v.ID] = true
+		}
+		variants = append(variants, v)
+	}
+	retired := make(map[string]bool)
+	for _, o := range old {
+		if !used[o.ID] {
+			retired[o.ID] = true
+		}
+	}
+	return finishVariants(variants, used, retired)
+}
+
+// Варианты из API
+func newVariants(list []*linkVariant) ([]*linkVariant, error) {
+	for i, v := range list {
+		if v == nil {
+			return nil, inputErr("input.variant", i+1, inputErr("input.empty_variant"))
+		}
+		variant := &linkVariant{URL: v.URL, Weight: v.Weight}
+		if err := variant.validate(); err != nil {
+			return nil, inputErr("input.variant", i+1, err)
+		}
+		list[i] = variant
+	}
+	return finishVariants(list, make(map[string]bool), nil)
+}
+
+// Проверка количества и раздача букв новым вариантам
+func finishVariants(variants []*linkVariant, used, retired map[string]bool) ([]*linkVariant, error) {
+	switch {
+	case len(variants) == 1:
+		return nil, inputErr("input.too_few_variants")
+	case len(variants) > maxVariants:
+		return nil, inputErr("input.too_many_variants", maxVariants)
+	}
+	next := 'A'
+	for _, v := range variants {
+		if v.ID != "" {
+			continue
+		}
+		for used[string(next)] || retired[string(next)] {
+			next++
+			if next > 'Z' {
+				// Свободных букв не осталось - занимаем буквы удаленных
+				next, retired = 'A', nil
+			}
+		}
+		v.ID = string(next)
+		used[v.ID] = true
+	}
+	return variants, nil
+}
+
+func (v *linkVariant) validate() error {
+	if v.Weight < 1 || v.Weight > maxVariantWeight {
+		return inputErr("input.bad_weight", maxVariantWeight)
+	}
+	u, err := url.Parse(v.URL)
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return inputErr("input.bad_target", v.URL)
+	}
+	return nil
+}
+
+func formatVariants(variants []*linkVariant) string {
+	var lines []string
+	for _, v := range variants {
+		lines = append(lines, strconv.Itoa(v.Weight)+" "+v.URL)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Строка таблицы вариантов
+type variantStats struct {
+	ID          string  `json:"id"`
+	URL         string  `json:"url"`
+	Weight      int     `json:"weight"`
+	WeightShare float64 `json:"weight_share"` // доля веса, %
+	Visitors    int     `json:"visitors"`
+	Visits      int     `json:"visits"`
+	VisitShare  float64 `json:"visit_share"` // доля переходов, %
+}
+
+// Статистика вариантов (вызывается под mutex.RLock)
+func collectVariantStats(variants []*linkVariant) []variantStats {
+	totalWeight, totalVisits := 0, 0
+	for _, v := range variants {
+		totalWeight += v.Weight
+		totalVisits += v.Visits.Load()
+	}
+	var list []variantStats
+	for _, v := range variants {
+		s := variantStats{
+			ID:       v.ID,
+			URL:      v.URL,
+			Weight:   v.Weight,
+			Visitors: v.Visitors.Load(),
+			Visits:   v.Visits.Load(),
+		}
+		s.WeightShare = percent(s.Weight, totalWeight)
+		s.VisitShare = percent(s.Visits, totalVisits)
+		list = append(list, s)
+	}
+	return list
+}
+
+func percent(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) * 100 / float64(total)
+}
+
+// Статистика одной ссылки для владельца (GET /my/stats/<code>?domain=)
+func linkStatsHandler(w http.ResponseWriter, r *http.Request) {
+	key := linkKey{r.URL.Query().Get("domain"), strings.TrimPrefix(r.URL.Path, "/my/stats/")}
+	now := time.Now()
+
+	mutex.RLock()
+	link, exists := links[key]
+	if !exists || link.IP != getIP(r) {
+		mutex.RUnlock()
+		http.NotFound(w, r)
+		return
+	}
+	stat := LinkStats{
+		ShortCode:   key.Code,
+		OriginalURL: link.OriginalURL,
+		Visits:      link.Visits.Load(),
+		CreatedAt:   link.CreatedAt,
+		Domain:      key.Domain,
+		Status:      link.state(now),
+		ExpiresAt:   link.ExpiresAt,
+		Tags:        append([]string(nil), link.Tags...),
+		Folder:      link.Folder,
+		LastVisit:   link.LastVisit.Load(),
+		Title:       link.Title,
+		Description: link.Description,
+		Favicon:     link.Favicon,
+		Fallback:    link.Fallback,
+	}
+	data := linkStatsPage{
+		page:     newPage(r, "link_stats"),
+		Variants: collectVariantStats(link.Variants),
+		HasRules: len(link.Rules) > 0,
+	}
+	mutex.RUnlock()
+
+	data.Link = newLinkCard(r, stat, 0)
+	renderPage(w, r, "link_stats", data)
+}
+
+// Адрес статистики ссылки в кабинете
+func linkStatsURL(domain, code string) string {
+	return "/my/stats/" + code + "?domain=" + url.QueryEscape(domain)
+}
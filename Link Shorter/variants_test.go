@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseVariantsKeepsCounters(t *testing.T) {
+	old, err := parseVariants("3 https://a.example.com\n1 https://b.example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old[0].ID != "A" || old[1].ID != "B" {
+		t.Fatalf("буквы %q, %q", old[0].ID, old[1].ID)
+	}
+	old[1].Visits.Store(7)
+	old[1].Visitors.Store(5)
+
+	// B остается, A удален, C новый: букву A новый вариант не получает
+	variants, err := parseVariants("1 https://c.example.com\n2 https://b.example.com", old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if variants[1].ID != "B" || variants[1].Weight != 2 || variants[1].Visits.Load() != 7 || variants[1].Visitors.Load() != 5 {
+		t.Fatalf("вариант B не сохранился: %+v", variants[1])
+	}
+	if variants[0].ID != "C" || variants[0].Visits.Load() != 0 {
+		t.Fatalf("новый вариант: %+v", variants[0])
+	}
+
+	if variants, err := parseVariants("", old); err != nil || variants != nil {
+		t.Fatalf("пустой список должен отключать тест: %v, %v", variants, err)
+	}
+}
+
+func TestParseVariantsErrors(t *testing.T) {
+	for _, text := range []string{
+		"1 https://a.example.com",
+		"1 https://a.example.com\nx https://b.example.com",
+		"1 https://a.example.com\n0 https://b.example.com",
+		"1 https://a.example.com\n1 ftp://b.example.com",
+		"1 https://a.example.com\nhttps://b.example.com",
+		strings.Repeat("1 https://a.example.com\n", maxVariants+1),
+	} {
+		if _, err := parseVariants(text, nil); err == nil {
+			t.Errorf("%q: ожидалась ошибка", text)
+		}
+	}
+}
+
+func TestRedirectStickyVariant(t *testing.T) {
+	seedLinks(t, 1)
+	link := links[linkKey{"", "c00000"}]
+	link.Variants, _ = parseVariants("1 https://a.example.com\n1 https://b.example.com", nil)
+
+	w := httptest.NewRecorder()
+	redirectShortLink(w, httptest.NewRequest(http.MethodGet, "/c00000", nil))
+	first := w.Header().Get("Location")
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != variantCookieName("c00000") {
+		t.Fatalf("нет cookie варианта: %v", cookies)
+	}
+
+	for i := 0; i < 20; i++ {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/c00000", nil)
+		r.AddCookie(cookies[0])
+		redirectShortLink(w, r)
+		if got := w.Header().Get("Location"); got != first {
+			t.Fatalf("переход %d: %q, ожидалось %q", i, got, first)
+		}
+	}
+
+	stats := collectVariantStats(link.Variants)
+	visits, visitors := 0, 0
+	for _, s := range stats {
+		visits += s.Visits
+		visitors += s.Visitors
+	}
+	if visits != 21 || visitors != 1 {
+		t.Fatalf("переходов %d, посетителей %d", visits, visitors)
+	}
+}
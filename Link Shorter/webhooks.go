@@ -0,0 +1,553 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Вебхуки: владелец подписывает свой адрес на события своих ссылок. Сервис
+// отправляет POST с JSON и подписью HMAC-SHA256 тела секретом подписки
+// (заголовок X-Signature-256: sha256=<hex>). Доставки хранятся в очереди
+// на диске и повторяются с растущей паузой; после webhooks.max_attempts
+// неудачных попыток доставка попадает в список недоставленных на /my/webhooks.
+
+const (
+	maxWebhooksPerOwner  = 10
+	maxWebhookThresholds = 10
+	maxDeadPerOwner      = 50
+	maxQueuedDeliveries  = 10000
+	maxWebhookBackoff    = time.Hour
+	webhookBatch         = 20 // доставок за один проход
+)
+
+// Подписка владельца
+type webhook struct {
+	ID         string    `json:"id"`
+	Owner      string    `json:"owner"` // IP, как у ссылок
+	URL        string    `json:"url"`
+	Secret     string    `json:"secret"`
+	Events     []string  `json:"events"`
+	Thresholds []int     `json:"thresholds,omitempty"` // для link.visits
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Одна доставка события на адрес подписки
+type webhookDelivery struct {
+	ID          string    `json:"id"`
+	Webhook     string    `json:"webhook"`
+	Owner       string    `json:"owner"`
+	URL         string    `json:"url"`
+	Event       string    `json:"event"`
+	Body        string    `json:"body"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Тело запроса
+type webhookPayload struct {
+	ID        string    `json:"id"` // совпадает с X-Webhook-Delivery
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Link      struct {
+		Domain string `json:"domain,omitempty"`
+		Code   string `json:"code"`
+		URL    string `json:"url"`
+		Visits int    `json:"visits"`
+	} `json:"link"`
+	Threshold int           `json:"threshold,omitempty"`
+	Alert     *webhookAlert `json:"alert,omitempty"`
+}
+
+// Сработавшее правило уведомления в теле link.alert
+type webhookAlert struct {
+	Kind    string `json:"kind"`              // threshold, rate или drop
+	Visits  int    `json:"visits,omitempty"`  // порог из правила
+	Minutes int    `json:"minutes,omitempty"` // окно из правила
+	Count   int    `json:"count"`             // переходов всего или за окно
+	Message string `json:"message"`
+}
+
+type webhookState struct {
+	Hooks []*webhook         `json:"hooks"`
+	Queue []*webhookDelivery `json:"queue"`
+	Dead  []*webhookDelivery `json:"dead"`
+}
+
+var (
+	webhookMu   sync.Mutex
+	webhooks    webhookState
+	webhookWake = make(chan struct{}, 1)
+)
+
+// Загрузка подписок и очереди при старте
+func loadWebhookState() error {
+	if config.Storage.Backend != "json" {
+		return nil
+	}
+	data, err := os.ReadFile(config.Webhooks.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state webhookState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+
+	webhookMu.Lock()
+	webhooks = state
+	updateWebhookThresholds()
+	webhookMu.Unlock()
+	return nil
+}
+
+// Сохранение подписок и очереди фоновым сохранением (webhooksFile):
+// всплеск событий дает одну запись файла, а при остановке сервера
+// файл записывается в последний раз
+func saveWebhookState() error {
+	if config.Storage.Backend != "json" {
+		return nil
+	}
+	webhookMu.Lock()
+	data, err := json.MarshalIndent(webhooks, "", "  ")
+	webhookMu.Unlock()
+	if err == nil {
+		os.MkdirAll(filepath.Dir(config.Webhooks.Path), 0755)
+		err = writeFileAtomic(config.Webhooks.Path, data, 0600)
+	}
+	if err != nil {
+		logEvent(slog.LevelError, "webhook_state_write_error", "path", config.Webhooks.Path, "error", err)
+	}
+	return err
+}
+
+// Пороги переходов из всех подписок (вызывается под webhookMu)
+func updateWebhookThresholds() {
+	var list []int
+	for _, h := range webhooks.Hooks {
+		if hasTag(h.Events, eventLinkVisits) {
+			list = append(list, h.Thresholds...)
+		}
+	}
+	setVisitThresholds(list)
+}
+
+func (h *webhook) wants(e linkEvent) bool {
+	if e.Owner != h.Owner || !hasTag(h.Events, e.Type) {
+		return false
+	}
+	if e.Type == eventLinkVisits {
+		for _, n := range h.Thresholds {
+			if n == e.Visits {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Подписчик шины событий: доставка в очередь каждой подходящей подписке
+func queueWebhookEvent(e linkEvent) {
+	webhookMu.Lock()
+	defer webhookMu.Unlock()
+
+	queued := false
+	for _, h := range webhooks.Hooks {
+		if !h.wants(e) {
+			continue
+		}
+		if len(webhooks.Queue) >= maxQueuedDeliveries {
+			logEvent(slog.LevelWarn, "webhook_queue_full", "event", e.Type, "url", h.URL)
+			break
+		}
+		d := &webhookDelivery{
+			ID:          randomToken()[:16],
+			Webhook:     h.ID,
+			Owner:       h.Owner,
+			URL:         h.URL,
+			Event:       e.Type,
+			NextAttempt: e.Time,
+			CreatedAt:   e.Time,
+		}
+		payload := webhookPayload{ID: d.ID, Event: e.Type, CreatedAt: e.Time}
+		payload.Link.Domain, payload.Link.Code = e.Key.Domain, e.Key.Code
+		payload.Link.URL, payload.Link.Visits = e.URL, e.Visits
+		if e.Type == eventLinkVisits {
+			payload.Threshold = e.Visits
+		}
+		if n := e.Alert; n != nil {
+			payload.Alert = &webhookAlert{
+				Kind:    n.Kind,
+				Visits:  n.Visits,
+				Minutes: n.Minutes,
+				Count:   n.Count,
+				Message: n.message(locales[config.I18n.DefaultLanguage]),
+			}
+		}
+		body, _ := json.Marshal(payload)
+		d.Body = string(body)
+		webhooks.Queue = append(webhooks.Queue, d)
+		queued = true
+	}
+	if queued {
+		webhooksFile.markDirty()
+		wakeWebhooks()
+	}
+}
+
+func wakeWebhooks() {
+	select {
+	case webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// Подпись тела: hex HMAC-SHA256 секретом подписки
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Пауза перед попыткой attempts+1: retry_delay, 2*retry_delay, 4*... до часа
+func webhookBackoff(attempts int) time.Duration {
+	d := config.Webhooks.RetryDelay
+	for i := 1; i < attempts && d < maxWebhookBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxWebhookBackoff)
+}
+
+// Отправка очереди до остановки сервера
+func runWebhooks(ctx context.Context, client *http.Client) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-webhookWake:
+		case <-timer.C:
+		}
+		deliverWebhooks(ctx, client)
+		timer.Reset(nextWebhookAttempt(time.Now()))
+	}
+}
+
+// Сколько ждать до ближайшей попытки
+func nextWebhookAttempt(now time.Time) time.Duration {
+	webhookMu.Lock()
+	defer webhookMu.Unlock()
+	wait := time.Minute
+	for _, d := range webhooks.Queue {
+		wait = min(wait, d.NextAttempt.Sub(now))
+	}
+	return max(wait, 100*time.Millisecond)
+}
+
+// Отправка доставок, время которых пришло
+func deliverWebhooks(ctx context.Context, client *http.Client) {
+	now := time.Now()
+	webhookMu.Lock()
+	var due []*webhookDelivery
+	secrets := make(map[string]string)
+	for _, d := range webhooks.Queue {
+		if !d.NextAttempt.After(now) && len(due) < webhookBatch {
+			due = append(due, d)
+		}
+	}
+	for _, h := range webhooks.Hooks {
+		secrets[h.ID] = h.Secret
+	}
+	webhookMu.Unlock()
+
+	for _, d := range due {
+		secret, ok := secrets[d.Webhook]
+		if !ok {
+			continue // подписку удалили, доставки убраны вместе с ней
+		}
+		err := sendWebhook(ctx, client, d, secret)
+		if ctx.Err() != nil {
+			return // остановка сервера; попытка повторится после запуска
+		}
+		finishDelivery(d, err)
+	}
+}
+
+func sendWebhook(ctx context.Context, client *http.Client, d *webhookDelivery, secret string) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", d.URL, strings.NewReader(d.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "LinkShorter/1.0 (+webhook)")
+	req.Header.Set("X-Webhook-Event", d.Event)
+	req.Header.Set("X-Webhook-Delivery", d.ID)
+	req.Header.Set("X-Signature-256", signWebhook(secret, []byte(d.Body)))
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("ответ %s", resp.Status)
+	}
+	return nil
+}
+
+// Итог попытки: убрать из очереди, назначить повтор или перенести
+// в недоставленные
+func finishDelivery(d *webhookDelivery, err error) {
+	webhookMu.Lock()
+	defer webhookMu.Unlock()
+	i := indexOfDelivery(webhooks.Queue, d.ID)
+	if i < 0 {
+		return
+	}
+	d.Attempts++
+	switch {
+	case err == nil:
+		webhooks.Queue = append(webhooks.Queue[:i], webhooks.Queue[i+1:]...)
+		webhooksDelivered.Inc()
+	case d.Attempts >= config.Webhooks.MaxAttempts:
+		d.LastError = err.Error()
+		webhooks.Queue = append(webhooks.Queue[:i], webhooks.Queue[i+1:]...)
+		addDeadDelivery(d)
+		webhooksFailed.Inc()
+		logEvent(slog.LevelWarn, "webhook_dead", "url", d.URL, "event", d.Event, "attempts", d.Attempts, "error", err)
+	default:
+		d.LastError = err.Error()
+		d.NextAttempt = time.Now().Add(webhookBackoff(d.Attempts))
+		webhooksFailed.Inc()
+		logEvent(slog.LevelDebug, "webhook_retry", "url", d.URL, "event", d.Event, "attempts", d.Attempts, "next", d.NextAttempt, "error", err)
+	}
+	webhooksFile.markDirty()
+}
+
+// Недоставленная доставка; у владельца хранятся последние maxDeadPerOwner
+// (вызывается под webhookMu)
+func addDeadDelivery(d *webhookDelivery) {
+	webhooks.Dead = append(webhooks.Dead, d)
+	count := 0
+	for i := len(webhooks.Dead) - 1; i >= 0; i-- {
+		if webhooks.Dead[i].Owner != d.Owner {
+			continue
+		}
+		if count++; count > maxDeadPerOwner {
+			webhooks.Dead = append(webhooks.Dead[:i], webhooks.Dead[i+1:]...)
+		}
+	}
+}
+
+// Подписка по ID (вызывается под webhookMu)
+func findWebhook(id string) *webhook {
+	for _, h := range webhooks.Hooks {
+		if h.ID == id {
+			return h
+		}
+	}
+	return nil
+}
+
+func indexOfDelivery(list []*webhookDelivery, id string) int {
+	for i, d := range list {
+		if d.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// Адрес подписки и пороги переходов из формы
+func parseWebhookForm(r *http.Request) (*webhook, error) {
+	u, err := url.Parse(strings.TrimSpace(r.FormValue("url")))
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, inputErr("input.bad_webhook_url")
+	}
+	h := &webhook{URL: u.String()}
+	for _, e := range r.Form["events"] {
+		if hasTag(linkEventTypes, e) && !hasTag(h.Events, e) {
+			h.Events = append(h.Events, e)
+		}
+	}
+	if len(h.Events) == 0 {
+		return nil, inputErr("input.no_events")
+	}
+	for _, field := range strings.FieldsFunc(r.FormValue("thresholds"), func(ch rune) bool { return ch == ',' || ch == ' ' }) {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 1 {
+			return nil, inputErr("input.bad_threshold", field)
+		}
+		h.Thresholds = append(h.Thresholds, n)
+	}
+	sort.Ints(h.Thresholds)
+	switch {
+	case len(h.Thresholds) > maxWebhookThresholds:
+		return nil, inputErr("input.too_many_thresholds", maxWebhookThresholds)
+	case hasTag(h.Events, eventLinkVisits) && len(h.Thresholds) == 0:
+		return nil, inputErr("input.thresholds_required", eventLinkVisits)
+	}
+	return h, nil
+}
+
+// Подписка для страницы: очередь и недоставленные владельца
+type webhookView struct {
+	webhook
+	Queued int
+}
+
+// Конец секрета, чтобы владелец отличал подписки; целиком секрет
+// показывается только при создании
+func (h webhook) SecretHint() string {
+	if len(h.Secret) < 4 {
+		return "…"
+	}
+	return "…" + h.Secret[len(h.Secret)-4:]
+}
+
+// Вебхуки владельца (GET /my/webhooks) и новая подписка (POST)
+func webhooksHandler(w http.ResponseWriter, r *http.Request) {
+	ip := getIP(r)
+	if r.Method == "POST" {
+		h, err := parseWebhookForm(r)
+		if err != nil {
+			http.Error(w, localeFrom(r).T("error.bad_webhook", errorText(localeFrom(r), err)), http.StatusBadRequest)
+			return
+		}
+		h.ID, h.Owner, h.Secret, h.CreatedAt = randomToken()[:12], ip, randomToken(), time.Now()
+
+		webhookMu.Lock()
+		count := 0
+		for _, other := range webhooks.Hooks {
+			if other.Owner == ip {
+				count++
+			}
+		}
+		if count >= maxWebhooksPerOwner {
+			webhookMu.Unlock()
+			http.Error(w, localeFrom(r).T("error.bad_webhook", localeFrom(r).T("input.too_many_webhooks", maxWebhooksPerOwner)), http.StatusBadRequest)
+			return
+		}
+		webhooks.Hooks = append(webhooks.Hooks, h)
+		updateWebhookThresholds()
+		webhooksFile.markDirty()
+		webhookMu.Unlock()
+
+		logEvent(slog.LevelInfo, "webhook_created", "url", h.URL, "events", strings.Join(h.Events, ","), "ip", ip)
+
+		// Секрет виден только в ответе на создание, дальше - лишь его конец
+		data := ownerWebhooks(r, ip)
+		data.NewID, data.NewSecret = h.ID, h.Secret
+		w.Header().Set("Cache-Control", "no-store")
+		renderPageStatus(w, r, http.StatusCreated, "webhooks", data)
+		return
+	}
+
+	renderPage(w, r, "webhooks", ownerWebhooks(r, ip))
+}
+
+// Страница подписок владельца
+func ownerWebhooks(r *http.Request, ip string) webhooksPage {
+	data := webhooksPage{page: newPage(r, "webhooks"), Events: linkEventTypes}
+	webhookMu.Lock()
+	for _, h := range webhooks.Hooks {
+		if h.Owner != ip {
+			continue
+		}
+		view := webhookView{webhook: *h}
+		for _, d := range webhooks.Queue {
+			if d.Webhook == h.ID {
+				view.Queued++
+			}
+		}
+		data.Hooks = append(data.Hooks, view)
+	}
+	for i := len(webhooks.Dead) - 1; i >= 0; i-- {
+		if d := webhooks.Dead[i]; d.Owner == ip {
+			data.Dead = append(data.Dead, *d)
+		}
+	}
+	webhookMu.Unlock()
+	return data
+}
+
+// Удаление подписки вместе с ее очередью (POST /my/webhooks/delete?id=)
+func deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/my/webhooks", http.StatusFound)
+		return
+	}
+	id, ip := r.URL.Query().Get("id"), getIP(r)
+
+	webhookMu.Lock()
+	deleted := false
+	for i, h := range webhooks.Hooks {
+		if h.ID == id && h.Owner == ip {
+			webhooks.Hooks = append(webhooks.Hooks[:i], webhooks.Hooks[i+1:]...)
+			deleted = true
+			break
+		}
+	}
+	if deleted {
+		queue := webhooks.Queue[:0]
+		for _, d := range webhooks.Queue {
+			if d.Webhook != id {
+				queue = append(queue, d)
+			}
+		}
+		webhooks.Queue = queue
+		updateWebhookThresholds()
+		webhooksFile.markDirty()
+	}
+	webhookMu.Unlock()
+
+	if deleted {
+		logEvent(slog.LevelInfo, "webhook_deleted", "id", id, "ip", ip)
+	}
+	http.Redirect(w, r, "/my/webhooks", http.StatusFound)
+}
+
+// Повтор недоставленной доставки (POST /my/webhooks/retry?id=)
+func retryWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/my/webhooks", http.StatusFound)
+		return
+	}
+	id, ip := r.URL.Query().Get("id"), getIP(r)
+
+	webhookMu.Lock()
+	i := indexOfDelivery(webhooks.Dead, id)
+	if i >= 0 && webhooks.Dead[i].Owner == ip && findWebhook(webhooks.Dead[i].Webhook) != nil {
+		d := webhooks.Dead[i]
+		webhooks.Dead = append(webhooks.Dead[:i], webhooks.Dead[i+1:]...)
+		d.Attempts, d.NextAttempt = 0, time.Now()
+		webhooks.Queue = append(webhooks.Queue, d)
+		webhooksFile.markDirty()
+		wakeWebhooks()
+	}
+	webhookMu.Unlock()
+
+	http.Redirect(w, r, "/my/webhooks", http.StatusFound)
+}
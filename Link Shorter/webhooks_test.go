@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookBackoff(t *testing.T) {
+	config = defaultConfig()
+	config.Webhooks.RetryDelay = 10 * time.Second
+	for attempts, want := range map[int]time.Duration{
+		1:  10 * time.Second,
+		2:  20 * time.Second,
+		4:  80 * time.Second,
+		30: maxWebhookBackoff,
+	} {
+		if got := webhookBackoff(attempts); got != want {
+			t.Errorf("webhookBackoff(%d) = %s, ожидалось %s", attempts, got, want)
+		}
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	config = defaultConfig()
+	config.Storage.Backend = "memory"
+	config.Webhooks.MaxAttempts = 2
+
+	var calls atomic.Int32
+	var last webhookPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write(body)
+		if r.Header.Get("X-Signature-256") != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			t.Error("неверная подпись")
+		}
+		json.Unmarshal(body, &last)
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		calls.Add(1)
+	}))
+	defer server.Close()
+
+	webhooks = webhookState{Hooks: []*webhook{
+		{ID: "ok", Owner: "1.2.3.4", URL: server.URL + "/ok", Secret: "secret", Events: []string{eventLinkVisits}, Thresholds: []int{10}},
+		{ID: "fail", Owner: "1.2.3.4", URL: server.URL + "/fail", Secret: "secret", Events: []string{eventLinkExpired}},
+		{ID: "other", Owner: "5.6.7.8", URL: server.URL + "/ok", Secret: "secret", Events: []string{eventLinkVisits}, Thresholds: []int{10}},
+	}}
+	key := linkKey{"", "abc"}
+	queueWebhookEvent(linkEvent{Type: eventLinkVisits, Key: key, Owner: "1.2.3.4", Visits: 9, Time: time.Now()})
+	queueWebhookEvent(linkEvent{Type: eventLinkVisits, Key: key, Owner: "1.2.3.4", Visits: 10, Time: time.Now()})
+	queueWebhookEvent(linkEvent{Type: eventLinkExpired, Key: key, Owner: "1.2.3.4", Time: time.Now()})
+	if len(webhooks.Queue) != 2 {
+		t.Fatalf("в очереди %d доставок, ожидалось 2", len(webhooks.Queue))
+	}
+
+	client := server.Client()
+	deliverWebhooks(context.Background(), client)
+	if calls.Load() != 2 || last.Event != eventLinkExpired || last.Link.Code != "abc" {
+		t.Fatalf("вызовов %d, последнее тело %+v", calls.Load(), last)
+	}
+	if len(webhooks.Queue) != 1 || webhooks.Queue[0].Attempts != 1 || webhooks.Queue[0].LastError == "" {
+		t.Fatalf("неудачная доставка должна остаться в очереди: %+v", webhooks.Queue)
+	}
+
+	// Вторая неудача - в недоставленные
+	webhooks.Queue[0].NextAttempt = time.Now()
+	deliverWebhooks(context.Background(), client)
+	if len(webhooks.Queue) != 0 || len(webhooks.Dead) != 1 || webhooks.Dead[0].Webhook != "fail" {
+		t.Fatalf("очередь %+v, недоставленные %+v", webhooks.Queue, webhooks.Dead)
+	}
+}
+
+// Секрет подписки виден только в ответе на ее создание
+func TestWebhookSecretShownOnce(t *testing.T) {
+	resetState()
+	config.Storage.Backend = "memory"
+	config.Features.Webhooks = true
+	if err := loadLocales(); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+
+	form := url.Values{"url": {"https://example.com/hook"}, "events": {eventLinkCreated}}
+	r := httptest.NewRequest(http.MethodPost, "/my/webhooks", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = "192.0.2.1:5000"
+	w := httptest.NewRecorder()
+	webhooksHandler(w, r)
+	if w.Code != http.StatusCreated || len(webhooks.Hooks) != 1 {
+		t.Fatalf("код %d, подписок %d", w.Code, len(webhooks.Hooks))
+	}
+	secret := webhooks.Hooks[0].Secret
+	if !strings.Contains(w.Body.String(), secret) {
+		t.Error("в ответе на создание нет секрета")
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/my/webhooks", nil)
+	r.RemoteAddr = "192.0.2.1:5000"
+	w = httptest.NewRecorder()
+	webhooksHandler(w, r)
+	body := w.Body.String()
+	if strings.Contains(body, secret) || !strings.Contains(body, secret[len(secret)-4:]) {
+		t.Error("секрет показан повторно или нет его конца")
+	}
+}